@@ -0,0 +1,155 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/auth"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+func NewCmdAuth() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage the Google sign-in",
+	}
+	cmd.AddCommand(NewCmdAuthLogin())
+	cmd.AddCommand(NewCmdAuthStatus())
+	cmd.AddCommand(NewCmdAuthLogout())
+	return cmd
+}
+
+func NewCmdAuthLogin() *cobra.Command {
+	return &cobra.Command{
+		Use:   "login",
+		Short: "Sign in with the browser and cache the OAuth token",
+		Long: `Sign in with the browser and cache the OAuth token.
+
+The browser is redirected back to a listener on a random local port, so the
+OAuth client in the credentials file must be a "Desktop app" client.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			opts := cfg.AuthOptions()
+			if m := opts.EffectiveMethod(); m != auth.MethodOAuth {
+				fmt.Printf("Auth method is %s, no sign-in needed.\n", m)
+				return nil
+			}
+			if _, err := auth.Login(context.Background(), opts); err != nil {
+				return err
+			}
+			fmt.Printf("Signed in, token saved to %s\n", opts.TokenFile)
+			return nil
+		},
+	}
+}
+
+func NewCmdAuthStatus() *cobra.Command {
+	return &cobra.Command{
+		Use:          "status",
+		Short:        "Show how the quote generator authenticates and as whom",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			opts := cfg.AuthOptions()
+			method := opts.EffectiveMethod()
+			fmt.Printf("Method:      %s\n", method)
+			switch method {
+			case auth.MethodOAuth:
+				fmt.Printf("Credentials: %s\n", opts.CredentialsFile)
+				fmt.Printf("Token file:  %s\n", opts.TokenFile)
+				tok, err := auth.ReadToken(opts.TokenFile)
+				if err != nil {
+					fmt.Println("Token:       none, run `quote-generator auth login`")
+					return nil
+				}
+				expiry := "never"
+				if !tok.Expiry.IsZero() {
+					expiry = tok.Expiry.Local().Format(time.RFC3339)
+				}
+				fmt.Printf("Expiry:      %s\n", expiry)
+				fmt.Printf("Refreshable: %t\n", tok.RefreshToken != "")
+			case auth.MethodServiceAccount:
+				fmt.Printf("Key:         %s\n", opts.ServiceAccountKey)
+			case auth.MethodADC:
+				if f := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); f != "" {
+					fmt.Printf("Key:         %s\n", f)
+				}
+			}
+			if opts.Subject != "" {
+				fmt.Printf("Impersonate: %s\n", opts.Subject)
+			}
+
+			ctx := context.Background()
+			opts.Interactive = false
+			client, err := auth.Client(ctx, opts)
+			if err != nil {
+				return err
+			}
+			srvDrive, err := drive.NewService(ctx, option.WithHTTPClient(client))
+			if err != nil {
+				return fmt.Errorf("unable to retrieve Drive client: %v", err)
+			}
+			about, err := srvDrive.About.Get().Fields("user(emailAddress)").Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("unable to verify credentials: %v", err)
+			}
+			fmt.Printf("Signed in:   %s\n", about.User.EmailAddress)
+			return nil
+		},
+	}
+}
+
+func NewCmdAuthLogout() *cobra.Command {
+	return &cobra.Command{
+		Use:          "logout",
+		Short:        "Revoke and delete the cached OAuth token",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			opts := cfg.AuthOptions()
+			if _, err := os.Stat(opts.TokenFile); os.IsNotExist(err) {
+				fmt.Println("Not signed in.")
+				return nil
+			}
+			if err := auth.Logout(context.Background(), opts); err != nil {
+				return err
+			}
+			fmt.Printf("Signed out, removed %s\n", opts.TokenFile)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"golang.org/x/net/context"
+)
+
+// runBatch generates a quote for every row of the batch file. Rows inherit the
+// template and data of base unless they set them.
+func runBatch(gen *quote.Generator, base quote.Request, batchFile, resultsFile string, workers int) error {
+	rows, err := readBatch(batchFile)
+	if err != nil {
+		return fmt.Errorf("unable to read batch %s: %v", batchFile, err)
+	}
+	for i := range rows {
+		req := &rows[i].Request
+		if req.Template == "" {
+			req.Template = base.Template
+		}
+		data := make(map[string]string, len(base.Data)+len(req.Data))
+		for k, v := range base.Data {
+			data[quote.Placeholder(k)] = v
+		}
+		for k, v := range req.Data {
+			data[quote.Placeholder(k)] = v
+		}
+		req.Data = data
+		req.AllowUnresolved = base.AllowUnresolved
+		req.Send = base.Send
+		req.LineItems = base.LineItems
+		req.Lists = base.Lists
+	}
+
+	results := gen.GenerateBatch(context.TODO(), rows, workers)
+
+	if resultsFile == "" {
+		resultsFile = strings.TrimSuffix(batchFile, filepath.Ext(batchFile)) + ".results.csv"
+	}
+	f, err := os.Create(resultsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = quote.WriteBatchResults(f, results); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	var failed int
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "line %d (%s): %v\n", r.Line, r.Email, r.Err)
+			continue
+		}
+		fmt.Printf("line %d (%s): %s %s\n", r.Line, r.Email, r.Result.Quote, r.Result.PDFPath)
+		for _, w := range r.Result.Warnings {
+			fmt.Fprintf(os.Stderr, "line %d: warning: %s\n", r.Line, w)
+		}
+	}
+	fmt.Println("writing results:", resultsFile)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d quotes failed", failed, len(results))
+	}
+	return nil
+}
+
+// readBatch reads a JSON Lines batch file if its extension is .jsonl, .ndjson
+// or .json, and a CSV file otherwise.
+func readBatch(filename string) ([]quote.BatchRow, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonl", ".ndjson", ".json":
+		return quote.ReadBatchJSONL(f)
+	default:
+		return quote.ReadBatchCSV(f)
+	}
+}
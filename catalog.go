@@ -0,0 +1,112 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCmdCatalog() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "catalog",
+		Short:        "List the products of the pricing catalog",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			catalog, err := loadCatalog(cmd)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "SKU\tPRODUCT\tUNIT\tTERM\tPRICE")
+			for _, p := range catalog.Products {
+				terms := make([]string, 0, len(p.Prices))
+				for term := range p.Prices {
+					terms = append(terms, string(term))
+				}
+				sort.Strings(terms)
+				for _, term := range terms {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s %s\n", p.SKU, p.DisplayName(), p.Unit, term, quote.FormatAmount(p.Prices[quote.Term(term)]), catalog.Currency)
+				}
+			}
+			return w.Flush()
+		},
+	}
+	cmd.AddCommand(NewCmdCatalogPrice())
+	return cmd
+}
+
+func NewCmdCatalogPrice() *cobra.Command {
+	return &cobra.Command{
+		Use:          "price LINE_ITEMS",
+		Short:        "Calculate the line totals and total of a line-items file",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			catalog, err := loadCatalog(cmd)
+			if err != nil {
+				return err
+			}
+			items, err := readLineItems(args[0])
+			if err != nil {
+				return err
+			}
+			items, err = catalog.Price(items)
+			if err != nil {
+				return err
+			}
+			if err = items.Validate(); err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+			fmt.Fprintln(w, "PRODUCT\tTERM\tQUANTITY\tUNIT PRICE\tAMOUNT\t")
+			for _, li := range items.Items {
+				fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\t\n", li.Product, li.TermDescription(), li.Quantity, quote.FormatAmount(li.UnitPrice), quote.FormatAmount(li.Amount()))
+			}
+			fmt.Fprintf(w, "\t\t\tSubtotal\t%s\t\n", quote.FormatAmount(items.Subtotal()))
+			if items.Discount != 0 {
+				fmt.Fprintf(w, "\t\t\tDiscount %v%%\t%s\t\n", items.Discount, quote.FormatAmount(items.DiscountAmount()))
+			}
+			fmt.Fprintf(w, "\t\t\tTotal\t%s %s\t\n", quote.FormatAmount(items.Total()), items.Currency)
+			return w.Flush()
+		},
+	}
+}
+
+func loadCatalog(cmd *cobra.Command) (*quote.Catalog, error) {
+	cfg, err := loadConfig(cmd.Flags())
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := cfg.LoadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	if catalog == nil {
+		return nil, errors.New("no pricing catalog configured, set catalog in the config file or pass --catalog")
+	}
+	return catalog, nil
+}
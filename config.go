@@ -0,0 +1,58 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/appscodelabs/quote-generator/pkg/config"
+
+	"github.com/spf13/cobra"
+)
+
+func NewCmdConfig() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the quote generator config file",
+	}
+	cmd.AddCommand(NewCmdConfigValidate())
+	return cmd
+}
+
+func NewCmdConfigValidate() *cobra.Command {
+	return &cobra.Command{
+		Use:          "validate",
+		Short:        "Validate the config file",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if err = cfg.Validate(); err != nil {
+				return fmt.Errorf("invalid config: %v", err)
+			}
+			if path := config.Path(configFile); path != "" {
+				fmt.Println("config is valid:", path)
+			} else {
+				fmt.Println("no config file found, built-in config is valid")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+// Ways of grouping expiring quotes.
+const (
+	groupByRep    = "rep"
+	groupByDomain = "domain"
+)
+
+// noSalesRep groups the quotes without sales rep.
+const noSalesRep = "(no sales rep)"
+
+func NewCmdExpiring() *cobra.Command {
+	var (
+		within   string
+		groupBy  string
+		remind   bool
+		remindTo string
+		icsFile  string
+	)
+	cmd := &cobra.Command{
+		Use:   "expiring",
+		Short: "List open quotes about to expire",
+		Long: `List the open quotes of the quotation log that expire within a period,
+grouped by sales rep or customer domain.
+
+With --remind, every sales rep is emailed the list of their quotes, with the
+expiration dates attached as calendar file. Quotes without sales rep are sent
+to --remind-to. With --ics, the expiration dates of all listed quotes are
+written to an iCalendar file instead.`,
+		Example: `  quote-generator expiring --within 7d
+  quote-generator expiring --within 14d --group-by domain
+  quote-generator expiring --within 7d --remind --remind-to sales@appscode.com
+  quote-generator expiring --within 30d --ics expiring.ics`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			period, err := parseWithin(within)
+			if err != nil {
+				return err
+			}
+			if groupBy != groupByRep && groupBy != groupByDomain {
+				return fmt.Errorf("unknown --group-by %q, must be %s or %s", groupBy, groupByRep, groupByDomain)
+			}
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if remind {
+				if newMailer(cfg) == nil {
+					return errors.New("--remind needs an SMTP server, set smtp in the config file")
+				}
+				if cfg.Email.From == "" {
+					return errors.New("--remind needs a sender, set email.from in the config file")
+				}
+			}
+			ledger, err := newLedger(context.TODO(), cfg, nil)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			quotes, err := quote.ExpiringQuotes(context.TODO(), ledger, now, period)
+			if err != nil {
+				return err
+			}
+			// the calendar alone goes to stdout with --ics -
+			if len(quotes) == 0 {
+				fmt.Fprintf(os.Stderr, "No open quotes expire within %s.\n", within)
+			} else if icsFile != "-" {
+				printExpiring(quotes, groupBy, now)
+			}
+
+			if icsFile != "" {
+				if err = writeCalendar(icsFile, quotes, now); err != nil {
+					return err
+				}
+			}
+			if remind && len(quotes) > 0 {
+				return sendReminders(cfg.Email.From, remindTo, quotes, now, newMailer(cfg))
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&within, "within", "7d", "Period from today in which quotes expire, in days like 7d or as duration like 36h")
+	flags.StringVar(&groupBy, "group-by", groupByRep, fmt.Sprintf("Group quotes by %s or %s", groupByRep, groupByDomain))
+	flags.BoolVar(&remind, "remind", false, "Email every sales rep the list of their expiring quotes")
+	flags.StringVar(&remindTo, "remind-to", "", "Who is reminded of quotes without sales rep, e.g. sales@appscode.com")
+	flags.StringVar(&icsFile, "ics", "", "Path to iCalendar file with the expiration dates, or - to write it to stdout")
+	return cmd
+}
+
+// parseWithin parses a number of days like 7d, or a duration like 36h.
+func parseWithin(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid --within %q, must be days like 7d or a duration like 36h", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid --within %q, must be days like 7d or a duration like 36h", s)
+	}
+	return d, nil
+}
+
+// groupExpiring groups quotes by sales rep or domain and returns the sorted
+// group names.
+func groupExpiring(quotes []quote.ExpiringQuote, groupBy string) ([]string, map[string][]quote.ExpiringQuote) {
+	groups := map[string][]quote.ExpiringQuote{}
+	for _, q := range quotes {
+		key := q.Domain()
+		if groupBy == groupByRep {
+			key = q.SalesRep
+			if key == "" {
+				key = noSalesRep
+			}
+		}
+		groups[key] = append(groups[key], q)
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, groups
+}
+
+func printExpiring(quotes []quote.ExpiringQuote, groupBy string, now time.Time) {
+	names, groups := groupExpiring(quotes, groupBy)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s\n", name)
+		fmt.Fprintln(w, "  QUOTE\tEXPIRES\tDAYS LEFT\tSTATUS\tCUSTOMER\tEMAIL\tTOTAL")
+		for _, q := range groups[name] {
+			customer := q.Company
+			if customer == "" {
+				customer = q.Name
+			}
+			total := ""
+			if q.Total != "" {
+				total = q.Currency + " " + q.Total
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%d\t%s\t%s\t%s\t%s\n", q.Quote, q.Expiry.Format(quote.DateLayout), q.DaysLeft(now), q.Status, customer, q.Email, total)
+		}
+	}
+	_ = w.Flush()
+}
+
+func writeCalendar(filename string, quotes []quote.ExpiringQuote, now time.Time) error {
+	if filename == "-" {
+		return quote.WriteCalendar(os.Stdout, quotes, now)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = quote.WriteCalendar(f, quotes, now); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "writing calendar:", filename)
+	return nil
+}
+
+// sendReminders emails every sales rep the list of their quotes. Quotes whose
+// sales rep is not an email address are sent to remindTo.
+func sendReminders(from, remindTo string, quotes []quote.ExpiringQuote, now time.Time, mailer quote.Mailer) error {
+	names, groups := groupExpiring(quotes, groupByRep)
+	byRecipient := map[string][]quote.ExpiringQuote{}
+	var recipients []string
+	var errs []string
+	for _, name := range names {
+		to := name
+		if _, err := mail.ParseAddress(name); err != nil {
+			if remindTo == "" {
+				errs = append(errs, fmt.Sprintf("no reminder sent for the quotes of %s, set --remind-to", name))
+				continue
+			}
+			to = remindTo
+		}
+		if _, ok := byRecipient[to]; !ok {
+			recipients = append(recipients, to)
+		}
+		byRecipient[to] = append(byRecipient[to], groups[name]...)
+	}
+
+	for _, to := range recipients {
+		list := byRecipient[to]
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Expiry.Before(list[j].Expiry)
+		})
+		msg, err := quote.Reminder(from, to, list, now)
+		if err == nil {
+			err = mailer.Send(context.TODO(), msg)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("unable to remind %s: %v", to, err))
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "reminded %s of %d expiring quotes\n", to, len(list))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
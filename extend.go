@@ -0,0 +1,76 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+func NewCmdExtend() *cobra.Command {
+	var (
+		days     int
+		delivery deliveryFlags
+	)
+	cmd := &cobra.Command{
+		Use:   "extend QUOTE",
+		Short: "Give a quote a new validity, keeping its number",
+		Long: `Give a quote a new validity, keeping its number.
+
+The document and PDF of the quote are generated again from the same template
+and inputs, prepared today and expiring after --days. The quotation log gets
+the new dates and link; the old document is trashed. An expired quote is open
+again. To quote a new term with a new number, use renew instead.`,
+		Example:      "  quote-generator extend AC2410007 --days 30 --send",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if days <= 0 {
+				return fmt.Errorf("invalid --days %d", days)
+			}
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			gen, err := newGenerator(context.TODO(), cfg)
+			if err != nil {
+				return err
+			}
+			send := delivery.apply(cmd.Flags(), nil)
+			result, err := gen.Extend(context.TODO(), args[0], time.Duration(days)*24*time.Hour, send)
+			if err != nil {
+				var sendErr *quote.SendError
+				if errors.As(err, &sendErr) {
+					printResult(sendErr.Result)
+				}
+				return err
+			}
+			printResult(result)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&days, "days", int(quote.Validity.Hours()/24), "Number of days from today the quote is valid")
+	delivery.addFlags(flags)
+	return cmd
+}
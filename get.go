@@ -0,0 +1,57 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+func NewCmdGet() *cobra.Command {
+	return &cobra.Command{
+		Use:          "get QUOTE",
+		Short:        "Show the quotation log entry of a quote",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			ledger, err := newLedger(context.TODO(), cfg, nil)
+			if err != nil {
+				return err
+			}
+			row, _, err := quote.FindQuote(context.TODO(), ledger, args[0])
+			if err != nil {
+				return err
+			}
+			for i, h := range quote.LedgerHeaders {
+				var v string
+				if i < len(row) {
+					v = row[i]
+				}
+				fmt.Printf("%s: %s\n", h, v)
+			}
+			return nil
+		},
+	}
+}
@@ -5,11 +5,15 @@ go 1.18
 require (
 	github.com/davegardnerisme/phonegeocode v0.0.0-20160120101024-a49b977f8889
 	github.com/gobuffalo/flect v0.3.0
+	github.com/spf13/cobra v1.8.0
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/net v0.7.0
+	golang.org/x/oauth2 v0.1.0
+	golang.org/x/sys v0.5.0
+	golang.org/x/time v0.3.0
 	gomodules.xyz/email-providers v0.1.2
-	gomodules.xyz/gdrive-utils v0.0.0-20210204225940-6681833950ff
 	google.golang.org/api v0.39.0
+	sigs.k8s.io/yaml v1.4.0
 )
 
 require (
@@ -17,10 +21,9 @@ require (
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/googleapis/gax-go/v2 v2.0.5 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/tchap/go-patricia v2.3.0+incompatible // indirect
 	go.opencensus.io v0.22.5 // indirect
-	golang.org/x/oauth2 v0.1.0 // indirect
-	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20201214200347-8c77b98c765d // indirect
@@ -17,267 +17,564 @@ limitations under the License.
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 
-	"github.com/davegardnerisme/phonegeocode"
-	"github.com/gobuffalo/flect"
+	"github.com/appscodelabs/quote-generator/pkg/auth"
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/config"
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
 	flag "github.com/spf13/pflag"
 	"golang.org/x/net/context"
-	. "gomodules.xyz/email-providers"
-	gdrive "gomodules.xyz/gdrive-utils"
 	"google.golang.org/api/docs/v1"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"sigs.k8s.io/yaml"
 )
 
-var businessFolderId = "1RBXgSR0jud5cpCqeC90fAdyb0Oaz7EIc"
-
-var templateIds = map[string]string{
-	"stash-on-demand":     "1zvnJ6PNWqesnh9-33kF47k2jN2WSPwxrlPPRojSO1Y0",
-	"stash-on-demand-100": "1U4GAovUia7K96PpBj0PWj4juTPaHMKB4nNK9LPyLgFk",
-	"stash-50":            "1EXMmcztXGb-EOrebHCrPrhFwQuRB0RpTl0UVeMtcMNk",
-	"stash-100":           "1Y2z7UZIIuvF3Twka6tXoovkbxyxXXz4qLnr9W43BIFs",
-	"kubedb-30":           "1n8zRoI5qjBaqa5hrogAey8OFd8-q7nCE9ysxwullb0g",
-	"kubedb-40":           "1s5751cd1SWZAy824njvTz2-iSC4V7NXRoFoCmZfoIcQ",
-	"kubedb-45":           "1VN3C_fDdUG_-zgFwvPkASVYzVmVr9E2Scv1Z2uqBRrY",
-	"kubedb-cluster-edu":  "18niPAUxB0OzsWTSln2OYuMqlXvHidozquqVwhtaFKYg",
-	"kubedb-cluster-gov":  "11cfcXar6p9cuHQhecOu0MW6f5zTYBbXjY2P0KpQCZq0",
-	"combined-cluster":    "14aeqhYnU88it0D8cXT65lfVefxmouaeu3buNnUppw-U",
-}
-
 var (
-	parentFolderId       string
-	templateDocId        string
-	outDir               string
-	replacementInput     map[string]string
-	replacements         map[string]string
-	email                string
-	quote                string
-	LicenseSpreadsheetId = "1evwv2ON94R38M-Lkrw8b6dpVSkRYHUWsNOuI7X0_-zA"
+	configFile     string
+	parentFolderId string
+	outDir         string
+	ledgerType     string
+	spreadsheetId  string
+	ledgerFile     string
+	catalogFile    string
+	authMethod     string
+	serviceAccount string
+	impersonate    string
+	credentials    string
+	tokenFile      string
+	nonInteractive bool
+	sharing        quote.Sharing
 )
 
-func init() {
-	flag.StringVar(&parentFolderId, "parent-folder-id", businessFolderId, "Parent folder id where generated docs will be stored under a folder with matching email domain")
-	flag.StringVar(&templateDocId, "template-doc-id", "", "Template document id")
-	flag.StringVar(&outDir, "out-dir", filepath.Join("/personal", "AppsCode", "quotes"), "Path to directory where output files are stored")
-	flag.StringToStringVar(&replacementInput, "data", nil, "key-value pairs for text replacement")
-	flag.StringVar(&LicenseSpreadsheetId, "spreadsheet-id", LicenseSpreadsheetId, "Google Spreadsheet Id used to store quotation log")
+func main() {
+	if err := NewRootCmd().Execute(); err != nil {
+		os.Exit(1)
+	}
 }
 
-func main() {
-	flag.Parse()
+func NewRootCmd() *cobra.Command {
+	var (
+		templateDocId    string
+		replacementInput map[string]string
+		revise           string
+		placeholderCheck string
+		allowUnresolved  bool
+		lineItemsFile    string
+		listsFile        string
+		batchFile        string
+		batchResults     string
+		workers          int
+		inputFile        string
+		dryRun           bool
+		preview          bool
+		delivery         deliveryFlags
+	)
+	cmd := &cobra.Command{
+		Use:          "quote-generator",
+		Short:        "Generate quote docs from Google Docs templates",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			req := quote.Request{Data: map[string]string{}}
+			if inputFile != "" {
+				qr, err := readQuoteRequest(inputFile)
+				if err != nil {
+					return err
+				}
+				req, err = qr.Request()
+				if err != nil {
+					return err
+				}
+			}
 
-	if parentFolderId == "" {
-		panic("missing parent folder id")
-	}
-	if templateDocId == "" {
-		panic("missing template doc id")
-	}
-	templateDoc := templateDocId
-	if id, ok := templateIds[templateDocId]; ok {
-		templateDocId = id
+			// flags override the input file, which overrides the config defaults
+			flags := cmd.Flags()
+			if flags.Changed("template-doc-id") {
+				req.Template = templateDocId
+			}
+			if revise != "" {
+				req.Revise = revise
+			}
+			if req.Template == "" && req.Revise == "" {
+				req.Template = cfg.Defaults.Template
+			}
+			req.AllowUnresolved = req.AllowUnresolved || allowUnresolved
+			req.Send = delivery.apply(flags, req.Send)
+			data := map[string]string{}
+			for k, v := range cfg.Defaults.Data {
+				data[quote.Placeholder(k)] = v
+			}
+			for k, v := range req.Data {
+				data[quote.Placeholder(k)] = v
+			}
+			for k, v := range replacementInput {
+				data[quote.Placeholder(k)] = v
+			}
+			req.Data = data
+
+			if lineItemsFile != "" {
+				req.LineItems, err = readLineItems(lineItemsFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			if listsFile != "" {
+				lists, err := readLists(listsFile)
+				if err != nil {
+					return err
+				}
+				if req.Lists == nil {
+					req.Lists = map[string][]map[string]string{}
+				}
+				for name, list := range lists {
+					req.Lists[name] = list
+				}
+			}
+
+			check := quote.PlaceholderCheck(placeholderCheck)
+			if err = check.Validate(); err != nil {
+				return err
+			}
+			gen, err := newGenerator(context.TODO(), cfg)
+			if err != nil {
+				return err
+			}
+			gen.PlaceholderCheck = check
+			if batchFile != "" {
+				if req.Revise != "" {
+					return errors.New("--revise can not be combined with --batch")
+				}
+				if dryRun || preview {
+					return errors.New("--dry-run and --preview can not be combined with --batch")
+				}
+				return runBatch(gen, req, batchFile, batchResults, workers)
+			}
+			if dryRun {
+				plan, err := gen.Plan(context.TODO(), req)
+				if err != nil {
+					return err
+				}
+				printPlan(plan, cfg.ParentFolderID)
+				return nil
+			}
+			if preview {
+				result, err := gen.Preview(context.TODO(), req)
+				if err != nil {
+					return err
+				}
+				fmt.Println("quote (not allocated):", result.Quote)
+				fmt.Println("writing preview:", result.PDFPath)
+				for _, p := range result.Unresolved {
+					fmt.Fprintln(os.Stderr, "unresolved placeholder:", p)
+				}
+				for _, w := range result.Warnings {
+					fmt.Fprintln(os.Stderr, "warning:", w)
+				}
+				return nil
+			}
+			result, err := gen.Generate(context.TODO(), req)
+			if err != nil {
+				var sendErr *quote.SendError
+				if errors.As(err, &sendErr) {
+					printResult(sendErr.Result)
+				}
+				return err
+			}
+			printResult(result)
+			return nil
+		},
 	}
 
-	replacements = map[string]string{}
-	for k, v := range replacementInput {
-		if strings.HasPrefix(k, "{{") && strings.HasSuffix(k, "}}") {
-			replacements[k] = v
-			continue
-		}
-		k = strings.Trim(k, "{}")
-		k = flect.Dasherize(k)
-		replacements[fmt.Sprintf("{{%s}}", k)] = v
+	def := config.Default()
+	pflags := cmd.PersistentFlags()
+	pflags.StringVar(&configFile, "config", "", fmt.Sprintf("Path to config file (defaults to $%s or %s)", config.EnvConfig, config.DefaultPath()))
+	pflags.StringVar(&parentFolderId, "parent-folder-id", def.ParentFolderID, "Parent folder id where generated docs will be stored under a folder with matching email domain")
+	pflags.StringVar(&outDir, "out-dir", def.OutDir, "Path to directory where output files are stored")
+	pflags.StringVar(&ledgerType, "ledger", def.Ledger, fmt.Sprintf("Where the quotation log is stored: %s or %s", config.LedgerSheets, config.LedgerFile))
+	pflags.StringVar(&spreadsheetId, "spreadsheet-id", def.SpreadsheetID, "Google Spreadsheet Id used to store quotation log")
+	pflags.StringVar(&ledgerFile, "ledger-file", def.LedgerFile, "Path to JSON Lines file used to store quotation log")
+	pflags.StringVar(&authMethod, "auth", "", fmt.Sprintf("How to authenticate with Google: %s, %s or %s (defaults to $%s, or %s if a service account key is set, else %s)", auth.MethodOAuth, auth.MethodServiceAccount, auth.MethodADC, config.EnvAuth, auth.MethodServiceAccount, auth.MethodOAuth))
+	pflags.StringVar(&credentials, "credentials", "", fmt.Sprintf("Path to OAuth client secrets file (defaults to %s)", filepath.Join(filepath.Dir(config.DefaultPath()), "credentials.json")))
+	pflags.StringVar(&tokenFile, "token-file", "", fmt.Sprintf("Path to file caching the OAuth token (defaults to %s)", filepath.Join(filepath.Dir(config.DefaultPath()), "token.json")))
+	pflags.StringVar(&serviceAccount, "service-account-key", "", fmt.Sprintf("Path to JSON key of a service account (defaults to $%s)", config.EnvServiceAccountKey))
+	pflags.StringVar(&impersonate, "impersonate", "", fmt.Sprintf("User a service account with domain-wide delegation acts as (defaults to $%s)", config.EnvImpersonate))
+	pflags.BoolVar(&nonInteractive, "non-interactive", false, "Fail instead of opening the browser to sign in when there is no OAuth token")
+	pflags.StringVar(&sharing.Customer, "share-customer", "", fmt.Sprintf("Share generated docs with the customer's email as %s or %s", backend.RoleReader, backend.RoleCommenter))
+	pflags.BoolVar(&sharing.Notify, "share-notify", false, "Email the customer about the shared doc")
+	pflags.StringVar(&sharing.Message, "share-message", "", "Message added to the notification email, may use placeholders like {{name}} and {{quote}}")
+	pflags.StringVar(&sharing.Group, "share-group", "", "Google Group to share generated docs with, e.g. sales@appscode.com")
+	pflags.StringVar(&sharing.GroupRole, "share-group-role", "", fmt.Sprintf("Role granted to the group: %s, %s or %s (defaults to %s)", backend.RoleReader, backend.RoleCommenter, backend.RoleWriter, backend.RoleWriter))
+	pflags.BoolVar(&sharing.AnyoneWithLink, "share-anyone-with-link", false, "Let anyone with the link view generated docs")
+	pflags.StringVar(&catalogFile, "catalog", def.Catalog, "Path to YAML or JSON pricing catalog used to price line items by SKU")
+
+	flags := cmd.Flags()
+	flags.StringVar(&inputFile, "input", "", "Path to YAML or JSON quote request, or - to read it from stdin")
+	flags.StringVar(&templateDocId, "template-doc-id", "", "Template document id")
+	flags.StringToStringVar(&replacementInput, "data", nil, "key-value pairs for text replacement, overriding the --input values")
+	flags.StringVar(&revise, "revise", "", "Quote number to issue a new revision of, e.g. AC2410007")
+	flags.BoolVar(&allowUnresolved, "allow-unresolved", false, "Export the PDF even if placeholders are left in the generated document")
+	flags.StringVar(&lineItemsFile, "line-items", "", "Path to YAML or JSON file with the line items rendered into the template table")
+	flags.StringVar(&listsFile, "lists", "", "Path to YAML or JSON file with the lists repeated by {{#each}} sections of the template")
+	flags.StringVar(&batchFile, "batch", "", "Path to CSV or JSON Lines file with one quote per row, with columns named after placeholders")
+	flags.StringVar(&batchResults, "batch-results", "", "Path to CSV file listing the outcome of every batch row (defaults to <batch>.results.csv)")
+	flags.IntVar(&workers, "workers", 4, "Number of quotes generated in parallel in batch mode")
+	flags.BoolVar(&dryRun, "dry-run", false, "Show the quote number, folder and replacements a quote would get, without writing anything")
+	flags.BoolVar(&preview, "preview", false, "Render the PDF from a scratch copy of the template that is trashed afterwards, without allocating a quote number")
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "preview")
+	delivery.addFlags(flags)
+	flags.StringVar(&placeholderCheck, "placeholder-check", string(quote.PlaceholderCheckWarn), "What to do when template placeholders have no value or data matches no placeholder: ignore, warn or error")
+
+	cmd.AddCommand(NewCmdConfig())
+	cmd.AddCommand(NewCmdGet())
+	cmd.AddCommand(NewCmdPlaceholders())
+	cmd.AddCommand(NewCmdCatalog())
+	cmd.AddCommand(NewCmdSchema())
+	cmd.AddCommand(NewCmdServe())
+	cmd.AddCommand(NewCmdAuth())
+	cmd.AddCommand(NewCmdResume())
+	cmd.AddCommand(NewCmdStatus())
+	cmd.AddCommand(NewCmdExpiring())
+	cmd.AddCommand(NewCmdExtend())
+	cmd.AddCommand(NewCmdRenew())
+	return cmd
+}
+
+// deliveryFlags are the flags emailing a quote.
+type deliveryFlags struct {
+	send bool
+	quote.Delivery
+}
+
+func (d *deliveryFlags) addFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&d.send, "send", false, "Email the PDF to the customer through the SMTP server of the config")
+	flags.StringSliceVar(&d.To, "to", nil, "Recipients of the quote email, instead of the customer's email (implies --send)")
+	flags.StringSliceVar(&d.Cc, "cc", nil, "Recipients copied on the quote email (implies --send)")
+	flags.StringSliceVar(&d.Bcc, "bcc", nil, "Recipients blind copied on the quote email (implies --send)")
+	flags.StringVar(&d.ReplyTo, "reply-to", "", "Reply-To address of the quote email, e.g. the sales rep (implies --send)")
+}
+
+// apply returns the delivery of base, e.g. from an input file, with the flags
+// applied. It returns base if no flag is set.
+func (d *deliveryFlags) apply(flags *flag.FlagSet, base *quote.Delivery) *quote.Delivery {
+	if !d.send && !flags.Changed("to") && !flags.Changed("cc") && !flags.Changed("bcc") && !flags.Changed("reply-to") {
+		return base
 	}
-	if v, ok := replacements["{{email}}"]; !ok {
-		panic("missing email")
-	} else {
-		email = v
+	out := &quote.Delivery{}
+	if base != nil {
+		*out = *base
 	}
-	if IsPublicEmail(email) {
-		replacements["{{website}}"] = ""
-	} else {
-		replacements["{{website}}"] = Domain(email)
+	if flags.Changed("to") {
+		out.To = d.To
 	}
-
-	if v, ok := replacements["{{phone}}"]; ok {
-		replacements["{{tel}}"] = v
+	out.Cc = append(out.Cc, d.Cc...)
+	out.Bcc = append(out.Bcc, d.Bcc...)
+	if flags.Changed("reply-to") {
+		out.ReplyTo = d.ReplyTo
 	}
-	if v, ok := replacements["{{tel}}"]; ok {
-		tel := SanitizeTelNumber(v)
-		if !strings.HasPrefix(tel, "+") && len(tel) == 10 {
-			tel = "+1" + tel
-		}
-		replacements["{{tel}}"] = tel
-		if cc, err := phonegeocode.New().Country(tel); err == nil {
-			replacements["{{country}}"] = cc
-		}
-	}
-
-	now := time.Now()
-	replacements["{{prep-date}}"] = now.Format("Jan 2, 2006")
-	replacements["{{expiry-date}}"] = now.Add(30 * 24 * time.Hour).Format("Jan 2, 2006")
+	return out
+}
 
-	dir, err := os.Getwd()
+// readLineItems reads the line items of a quote from a YAML or JSON file.
+func readLineItems(filename string) (quote.LineItems, error) {
+	var items quote.LineItems
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		log.Fatal(err)
+		return items, err
 	}
-	client, err := gdrive.DefaultClient(dir)
+	err = yaml.UnmarshalStrict(data, &items)
 	if err != nil {
-		log.Fatal(err)
+		return items, fmt.Errorf("unable to parse line items %s: %v", filename, err)
 	}
+	return items, nil
+}
 
-	srvDrive, err := drive.NewService(context.TODO(), option.WithHTTPClient(client))
+// readLists reads the lists of {{#each}} sections from a YAML or JSON file
+// mapping list names to lists of objects.
+func readLists(filename string) (map[string][]map[string]string, error) {
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Docs client: %v", err)
+		return nil, err
 	}
-
-	srvDoc, err := docs.NewService(context.TODO(), option.WithHTTPClient(client))
+	var in map[string][]quote.Values
+	err = yaml.UnmarshalStrict(data, &in)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Docs client: %v", err)
+		return nil, fmt.Errorf("unable to parse lists %s: %v", filename, err)
+	}
+	lists := make(map[string][]map[string]string, len(in))
+	for name, list := range in {
+		for _, el := range list {
+			lists[name] = append(lists[name], el)
+		}
 	}
+	return lists, nil
+}
 
-	srvSheet, err := gdrive.NewSpreadsheet(LicenseSpreadsheetId, option.WithHTTPClient(client))
-	if err != nil {
-		log.Fatalf("Unable to retrieve Sheets client: %v", err)
-	}
-	quote, err = LogQuotation(srvSheet, []string{
-		"Quotation #",
-		"Name",
-		"Designation",
-		"Email",
-		"Telephone",
-		"Company",
-		"Website",
-		"Country",
-		"Pricing Template",
-		"Preparation Date",
-		"Expiration Date",
-	}, []string{
-		"AC_DETECT_QUOTE",
-		replacements["{{name}}"],
-		replacements["{{designation}}"],
-		replacements["{{email}}"],
-		replacements["{{tel}}"],
-		replacements["{{company}}"],
-		replacements["{{website}}"],
-		replacements["{{country}}"],
-		templateDoc,
-		replacements["{{prep-date}}"],
-		replacements["{{expiry-date}}"],
-	})
+// readQuoteRequest reads a quote request from a YAML or JSON file, or from
+// stdin if filename is "-".
+func readQuoteRequest(filename string) (*quote.QuoteRequest, error) {
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
 	if err != nil {
-		log.Fatalf("Unable to append quotation: %v", err)
+		return nil, err
 	}
-	replacements["{{quote}}"] = quote
-
-	err = run(srvDoc, srvDrive)
+	var qr quote.QuoteRequest
+	err = yaml.UnmarshalStrict(data, &qr)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("unable to parse quote request %s: %v", filename, err)
 	}
+	return &qr, nil
 }
 
-func FolderName(email string) string {
-	if IsPublicEmail(email) {
-		return email
+// loadConfig loads the config file and applies the flags set on the command
+// line on top of it.
+func loadConfig(flags *flag.FlagSet) (*config.Config, error) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if flags.Changed("parent-folder-id") {
+		cfg.ParentFolderID = parentFolderId
+	}
+	if flags.Changed("out-dir") {
+		cfg.OutDir = outDir
+	}
+	if flags.Changed("ledger") {
+		cfg.Ledger = ledgerType
+	}
+	if flags.Changed("spreadsheet-id") {
+		cfg.SpreadsheetID = spreadsheetId
+	}
+	if flags.Changed("ledger-file") {
+		cfg.LedgerFile = ledgerFile
+	}
+	if flags.Changed("catalog") {
+		cfg.Catalog = catalogFile
+	}
+	if flags.Changed("auth") {
+		cfg.Auth.Method = authMethod
 	}
-	parts := strings.Split(email, "@")
-	return parts[len(parts)-1]
+	if flags.Changed("credentials") {
+		cfg.Auth.CredentialsFile = credentials
+	}
+	if flags.Changed("token-file") {
+		cfg.Auth.TokenFile = tokenFile
+	}
+	if flags.Changed("service-account-key") {
+		cfg.Auth.ServiceAccountKey = serviceAccount
+	}
+	if flags.Changed("impersonate") {
+		cfg.Auth.Impersonate = impersonate
+	}
+	if flags.Changed("share-customer") {
+		cfg.Sharing.Customer = sharing.Customer
+	}
+	if flags.Changed("share-notify") {
+		cfg.Sharing.Notify = sharing.Notify
+	}
+	if flags.Changed("share-message") {
+		cfg.Sharing.Message = sharing.Message
+	}
+	if flags.Changed("share-group") {
+		cfg.Sharing.Group = sharing.Group
+	}
+	if flags.Changed("share-group-role") {
+		cfg.Sharing.GroupRole = sharing.GroupRole
+	}
+	if flags.Changed("share-anyone-with-link") {
+		cfg.Sharing.AnyoneWithLink = sharing.AnyoneWithLink
+	}
+	return cfg, nil
 }
 
-func run(srvDoc *docs.Service, srvDrive *drive.Service) error {
-	var domainFolderId string
-
-	// https://developers.google.com/drive/api/v3/search-files
-	q := fmt.Sprintf("name = '%s' and mimeType = 'application/vnd.google-apps.folder' and '%s' in parents", FolderName(email), parentFolderId)
-	files, err := srvDrive.Files.List().Q(q).Spaces("drive").Do()
+// newGoogleClient returns a client authenticated as selected by the config.
+// It opens the browser to sign in only when run interactively. Requests are
+// throttled and retried as configured in cfg.API.
+func newGoogleClient(ctx context.Context, cfg *config.Config) (*http.Client, error) {
+	opts := cfg.AuthOptions()
+	opts.Interactive = !nonInteractive && auth.IsTerminal(os.Stdin)
+	client, err := auth.Client(ctx, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if len(files.Files) > 0 {
-		domainFolderId = files.Files[0].Id
-	} else {
-		// https://developers.google.com/drive/api/v3/folder#java
-		folderMetadata := &drive.File{
-			Name:     FolderName(email),
-			MimeType: "application/vnd.google-apps.folder",
-			Parents:  []string{parentFolderId},
+	return backend.WrapClient(client, cfg.RetryPolicy(), cfg.API.RateLimits), nil
+}
+
+// newLedger returns the quotation log selected in the config. client is only
+// used for the Google Spreadsheet and may be nil otherwise.
+func newLedger(ctx context.Context, cfg *config.Config, client *http.Client) (backend.QuoteLedger, error) {
+	switch cfg.Ledger {
+	case config.LedgerFile:
+		return backend.NewFileLedger(cfg.LedgerFile), nil
+	case config.LedgerSheets:
+		if client == nil {
+			var err error
+			client, err = newGoogleClient(ctx, cfg)
+			if err != nil {
+				return nil, err
+			}
 		}
-		folder, err := srvDrive.Files.Create(folderMetadata).Fields("id").Do()
+		srvSheet, err := sheets.NewService(ctx, option.WithHTTPClient(client))
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("unable to retrieve Sheets client: %v", err)
 		}
-		domainFolderId = folder.Id
+		return backend.NewGoogleSheet(srvSheet, cfg.SpreadsheetID, quote.LedgerSheet), nil
+	default:
+		return nil, fmt.Errorf("unknown ledger %q", cfg.Ledger)
 	}
-	fmt.Println("Using domain folder id:", domainFolderId)
+}
 
-	// https://developers.google.com/docs/api/how-tos/documents#copying_an_existing_document
-	docName := fmt.Sprintf("%s QUOTE #%s", FolderName(email), quote)
-	copyMetadata := &drive.File{
-		Name:    docName,
-		Parents: []string{domainFolderId},
+// newGoogleServices returns the Google Drive and Docs services.
+func newGoogleServices(ctx context.Context, client *http.Client) (*drive.Service, *docs.Service, error) {
+	srvDrive, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve Drive client: %v", err)
 	}
-	copyFile, err := srvDrive.Files.Copy(templateDocId, copyMetadata).Fields("id", "parents").Do()
+
+	srvDoc, err := docs.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		return err
-	}
-	fmt.Println("doc id:", copyFile.Id)
-
-	// https://developers.google.com/docs/api/how-tos/merge
-	req := &docs.BatchUpdateDocumentRequest{
-		Requests: make([]*docs.Request, 0, len(replacements)),
-	}
-	for k, v := range replacements {
-		req.Requests = append(req.Requests, &docs.Request{
-			ReplaceAllText: &docs.ReplaceAllTextRequest{
-				ContainsText: &docs.SubstringMatchCriteria{
-					MatchCase: true,
-					Text:      k,
-				},
-				ReplaceText: v,
-			},
-		})
-	}
-	doc, err := srvDoc.Documents.BatchUpdate(copyFile.Id, req).Do()
+		return nil, nil, fmt.Errorf("unable to retrieve Docs client: %v", err)
+	}
+	return srvDrive, srvDoc, nil
+}
+
+// newGenerator returns a quote generator backed by Google Drive and Docs.
+func newGenerator(ctx context.Context, cfg *config.Config) (*quote.Generator, error) {
+	client, err := newGoogleClient(ctx, cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resp, err := srvDrive.Files.Export(doc.DocumentId, "application/pdf").Download()
+	srvDrive, srvDoc, err := newGoogleServices(ctx, client)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
+
+	ledger, err := newLedger(ctx, cfg, client)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	filename := filepath.Join(outDir, FolderName(email), docName+".pdf")
-	err = os.MkdirAll(filepath.Dir(filename), 0o755)
+
+	scheme, err := cfg.Scheme()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println("writing file:", filename)
-	err = os.WriteFile(filename, buf.Bytes(), 0o644)
+
+	catalog, err := cfg.LoadCatalog()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return &quote.Generator{
+		Folders:        backend.NewGoogleDrive(srvDrive),
+		Documents:      backend.NewGoogleDocs(srvDrive, srvDoc),
+		Ledger:         ledger,
+		ParentFolderID: cfg.ParentFolderID,
+		OutDir:         cfg.OutDir,
+		Templates:      cfg.Templates,
+		Scheme:         scheme,
+		Catalog:        catalog,
+		Sharing:        cfg.Sharing,
+		Mailer:         newMailer(cfg),
+		Email:          cfg.Email,
+		Journal:        backend.NewFileJournal(cfg.JournalDir),
+	}, nil
+}
+
+// newMailer returns the SMTP server of cfg, or nil if none is configured.
+func newMailer(cfg *config.Config) quote.Mailer {
+	if cfg.SMTP.Host == "" {
+		return nil
 	}
-	return nil
+	return &cfg.SMTP
 }
 
-func SanitizeTelNumber(tel string) string {
-	var buf bytes.Buffer
-	for _, r := range tel {
-		if r == '+' || (r >= '0' && r <= '9') {
-			buf.WriteRune(r)
+func printPlan(plan *quote.Plan, parentFolderID string) {
+	fmt.Printf("template: %s (doc id %s)\n", plan.Template, plan.TemplateID)
+	fmt.Println("quote (not allocated):", plan.Quote)
+	if plan.FolderID != "" {
+		fmt.Printf("folder: reuse %s (id %s)\n", plan.FolderName, plan.FolderID)
+	} else {
+		fmt.Printf("folder: create %s under %s\n", plan.FolderName, parentFolderID)
+	}
+	fmt.Println("doc name:", plan.DocName)
+	fmt.Println("pdf:", plan.PDFPath)
+	if len(plan.LineItems.Items) > 0 {
+		fmt.Printf("line items: %d, total %s %s\n", len(plan.LineItems.Items), plan.LineItems.Currency, quote.FormatAmount(plan.LineItems.Total()))
+	}
+	for _, p := range plan.Permissions {
+		grantee := p.EmailAddress
+		if grantee == "" {
+			grantee = "anyone with the link"
+		}
+		notify := ""
+		if p.Notify {
+			notify = ", notified"
+		}
+		fmt.Printf("share: %s as %s%s\n", grantee, p.Role, notify)
+	}
+	if m := plan.Email; m != nil {
+		fmt.Printf("email: %q to %s\n", m.Subject, strings.Join(m.To, ", "))
+		if len(m.Cc) > 0 {
+			fmt.Println("  cc:", strings.Join(m.Cc, ", "))
 		}
+		if len(m.Bcc) > 0 {
+			fmt.Println("  bcc:", strings.Join(m.Bcc, ", "))
+		}
+		if m.ReplyTo != "" {
+			fmt.Println("  reply-to:", m.ReplyTo)
+		}
+	}
+	fmt.Println("replacements:")
+	keys := make([]string, 0, len(plan.Replacements))
+	for k := range plan.Replacements {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %s: %s\n", k, plan.Replacements[k])
+	}
+	for _, w := range plan.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+}
+
+func printResult(result *quote.Result) {
+	fmt.Println("quote:", result.Quote)
+	fmt.Println("Using domain folder id:", result.FolderID)
+	fmt.Println("doc id:", result.DocID)
+	fmt.Println("writing file:", result.PDFPath)
+	if result.Link != "" {
+		fmt.Println("link:", result.Link)
+	}
+	if len(result.SentTo) > 0 {
+		fmt.Println("sent to:", strings.Join(result.SentTo, ", "))
+	}
+	for _, p := range result.Unresolved {
+		fmt.Fprintln(os.Stderr, "unresolved placeholder:", p)
+	}
+	for _, w := range result.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
 	}
-	return buf.String()
 }
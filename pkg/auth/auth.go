@@ -0,0 +1,233 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Methods of authenticating with the Google APIs.
+const (
+	// MethodOAuth signs in as a user with an OAuth client and a cached token.
+	MethodOAuth = "oauth"
+	// MethodServiceAccount uses the JSON key of a service account.
+	MethodServiceAccount = "service-account"
+	// MethodADC uses Application Default Credentials: the key file named by
+	// $GOOGLE_APPLICATION_CREDENTIALS, the gcloud user credentials or the
+	// metadata server on Google Cloud.
+	MethodADC = "adc"
+)
+
+// Scopes are the OAuth scopes needed to copy and export documents and to write
+// the quotation log. With domain-wide delegation, the service account must be
+// authorized for them in the Google Workspace admin console.
+var Scopes = []string{
+	"https://www.googleapis.com/auth/documents",
+	"https://www.googleapis.com/auth/drive",
+	"https://www.googleapis.com/auth/spreadsheets",
+}
+
+// Options selects how to authenticate.
+type Options struct {
+	// Method is MethodOAuth, MethodServiceAccount or MethodADC. Defaults to
+	// MethodServiceAccount if ServiceAccountKey is set, else MethodOAuth.
+	Method string
+	// CredentialsFile is the OAuth client secrets file of MethodOAuth.
+	CredentialsFile string
+	// TokenFile caches the OAuth token of MethodOAuth.
+	TokenFile string
+	// ServiceAccountKey is the JSON key file of MethodServiceAccount.
+	ServiceAccountKey string
+	// Subject is the user impersonated by a service account with domain-wide
+	// delegation, e.g. a sales rep. Only used by MethodServiceAccount and by
+	// MethodADC with a service account key.
+	Subject string
+	// Interactive allows signing in with the browser if there is no cached
+	// OAuth token.
+	Interactive bool
+}
+
+// ErrLoginRequired is returned when MethodOAuth has no cached token and can
+// not sign in interactively.
+var ErrLoginRequired = errors.New("login required")
+
+// EffectiveMethod returns the authentication method used, applying the
+// default if Method is empty.
+func (o Options) EffectiveMethod() string {
+	if o.Method != "" {
+		return o.Method
+	}
+	if o.ServiceAccountKey != "" {
+		return MethodServiceAccount
+	}
+	return MethodOAuth
+}
+
+// Validate checks that the options needed by the method are set.
+func (o Options) Validate() error {
+	switch o.EffectiveMethod() {
+	case MethodOAuth:
+		if o.CredentialsFile == "" || o.TokenFile == "" {
+			return errors.New("oauth authentication needs a credentials and a token file")
+		}
+		if o.Subject != "" {
+			return errors.New("impersonating a user needs a service account")
+		}
+	case MethodServiceAccount:
+		if o.ServiceAccountKey == "" {
+			return errors.New("missing service account key")
+		}
+	case MethodADC:
+	default:
+		return fmt.Errorf("unknown auth method %q, must be %s, %s or %s", o.Method, MethodOAuth, MethodServiceAccount, MethodADC)
+	}
+	return nil
+}
+
+// Client returns an HTTP client authorized for Scopes.
+func Client(ctx context.Context, opts Options) (*http.Client, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	switch opts.EffectiveMethod() {
+	case MethodServiceAccount:
+		key, err := os.ReadFile(opts.ServiceAccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read service account key: %v", err)
+		}
+		cfg, err := google.JWTConfigFromJSON(key, Scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse service account key %s: %v", opts.ServiceAccountKey, err)
+		}
+		cfg.Subject = opts.Subject
+		return cfg.Client(ctx), nil
+	case MethodADC:
+		creds, err := google.FindDefaultCredentialsWithParams(ctx, google.CredentialsParams{
+			Scopes:  Scopes,
+			Subject: opts.Subject,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to find application default credentials: %v", err)
+		}
+		return oauth2.NewClient(ctx, creds.TokenSource), nil
+	default:
+		return oauthClient(ctx, opts)
+	}
+}
+
+// oauthConfig reads the OAuth client secrets file.
+func oauthConfig(opts Options) (*oauth2.Config, error) {
+	b, err := os.ReadFile(opts.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+	}
+	cfg, err := google.ConfigFromJSON(b, Scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	return cfg, nil
+}
+
+func oauthClient(ctx context.Context, opts Options) (*http.Client, error) {
+	cfg, err := oauthConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := ReadToken(opts.TokenFile)
+	if err != nil {
+		if !opts.Interactive {
+			return nil, fmt.Errorf("%w: no usable OAuth token in %s and no interactive terminal to sign in; run `quote-generator auth login` from a terminal, or use a service account or application default credentials", ErrLoginRequired, opts.TokenFile)
+		}
+		tok, err = login(ctx, cfg, opts.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ts := &savingTokenSource{
+		src:      cfg.TokenSource(ctx, tok),
+		filename: opts.TokenFile,
+		last:     tok,
+	}
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+// savingTokenSource writes refreshed tokens back to the token file, so that the
+// next run starts with a valid access token.
+type savingTokenSource struct {
+	src      oauth2.TokenSource
+	filename string
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil || tok.AccessToken != s.last.AccessToken {
+		s.last = tok
+		// a token that can not be cached is still good for this run
+		_ = writeToken(s.filename, tok)
+	}
+	return tok, nil
+}
+
+// ReadToken reads a cached OAuth token.
+func ReadToken(filename string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	tok := &oauth2.Token{}
+	if err = json.Unmarshal(data, tok); err != nil {
+		return nil, err
+	}
+	if tok.AccessToken == "" && tok.RefreshToken == "" {
+		return nil, errors.New("empty token")
+	}
+	return tok, nil
+}
+
+func writeToken(filename string, tok *oauth2.Token) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0o700)
+	if err == nil {
+		err = os.WriteFile(filename, data, 0o600)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to cache OAuth token: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenServer is a Google OAuth token endpoint issuing access tokens that
+// name how they were granted, and an API echoing the access token it gets.
+type tokenServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	forms []url.Values
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	t.Helper()
+	ts := &tokenServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ts.mu.Lock()
+		ts.forms = append(ts.forms, r.PostForm)
+		ts.mu.Unlock()
+
+		tok := map[string]interface{}{"token_type": "Bearer", "expires_in": 3600}
+		switch r.PostForm.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+			claims, err := jwtClaims(r.PostForm.Get("assertion"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			tok["access_token"] = "jwt:" + claims["iss"] + ":" + claims["sub"]
+		case "refresh_token":
+			tok["access_token"] = "refreshed:" + r.PostForm.Get("refresh_token")
+		case "authorization_code":
+			tok["access_token"] = "code:" + r.PostForm.Get("code")
+			tok["refresh_token"] = "refresh"
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(tok)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	})
+	ts.Server = httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// jwtClaims returns the string claims of a JWT without verifying it.
+func jwtClaims(assertion string) (map[string]string, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed assertion")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for k, v := range claims {
+		if s, ok := v.(string); ok {
+			out[k] = s
+		}
+	}
+	return out, nil
+}
+
+// call sends a request to the API of ts with client and returns the access
+// token it was authorized with.
+func (ts *tokenServer) call(t *testing.T, client *http.Client) string {
+	t.Helper()
+	resp, err := client.Get(ts.URL + "/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimPrefix(string(data), "Bearer ")
+}
+
+func writeJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "file.json")
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serviceAccountKey writes a service account key whose tokens are issued by
+// ts.
+func serviceAccountKey(t *testing.T, ts *tokenServer) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeJSON(t, map[string]string{
+		"type":           "service_account",
+		"project_id":     "quotes",
+		"private_key_id": "key1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "quotes@quotes.iam.gserviceaccount.com",
+		"client_id":      "1",
+		"token_uri":      ts.URL + "/token",
+	})
+}
+
+// clientSecrets writes an OAuth client secrets file of an installed app
+// signing in with ts.
+func clientSecrets(t *testing.T, ts *tokenServer) string {
+	t.Helper()
+	return writeJSON(t, map[string]interface{}{
+		"installed": map[string]interface{}{
+			"client_id":     "client-id",
+			"client_secret": "client-secret",
+			"auth_uri":      ts.URL + "/auth",
+			"token_uri":     ts.URL + "/token",
+			"redirect_uris": []string{"http://localhost"},
+		},
+	})
+}
+
+func TestEffectiveMethod(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, MethodOAuth},
+		{Options{ServiceAccountKey: "key.json"}, MethodServiceAccount},
+		{Options{Method: MethodOAuth, ServiceAccountKey: "key.json"}, MethodOAuth},
+		{Options{Method: MethodADC, ServiceAccountKey: "key.json"}, MethodADC},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.EffectiveMethod(); got != tt.want {
+			t.Errorf("EffectiveMethod(%+v) = %s, want %s", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		opts Options
+		err  string
+	}{
+		{Options{CredentialsFile: "credentials.json", TokenFile: "token.json"}, ""},
+		{Options{CredentialsFile: "credentials.json"}, "oauth authentication needs a credentials and a token file"},
+		{Options{CredentialsFile: "credentials.json", TokenFile: "token.json", Subject: "sales@appscode.com"}, "impersonating a user needs a service account"},
+		{Options{ServiceAccountKey: "key.json", Subject: "sales@appscode.com"}, ""},
+		{Options{Method: MethodServiceAccount}, "missing service account key"},
+		{Options{Method: MethodADC, Subject: "sales@appscode.com"}, ""},
+		{Options{Method: "password"}, `unknown auth method "password", must be oauth, service-account or adc`},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Validate()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("Validate(%+v) = %v, want %q", tt.opts, err, tt.err)
+		}
+	}
+}
+
+func TestClientServiceAccount(t *testing.T) {
+	ts := newTokenServer(t)
+	key := serviceAccountKey(t, ts)
+	ctx := context.Background()
+
+	for subject, want := range map[string]string{
+		"":                   "jwt:quotes@quotes.iam.gserviceaccount.com:",
+		"sales@appscode.com": "jwt:quotes@quotes.iam.gserviceaccount.com:sales@appscode.com",
+	} {
+		client, err := Client(ctx, Options{ServiceAccountKey: key, Subject: subject})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ts.call(t, client); got != want {
+			t.Errorf("impersonating %q: got token %q, want %q", subject, got, want)
+		}
+	}
+
+	// the scopes are requested in the assertion
+	ts.mu.Lock()
+	form := ts.forms[0]
+	ts.mu.Unlock()
+	claims, err := jwtClaims(form.Get("assertion"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["scope"] != strings.Join(Scopes, " ") {
+		t.Errorf("requested scopes %q, want %q", claims["scope"], strings.Join(Scopes, " "))
+	}
+}
+
+func TestClientServiceAccountErrors(t *testing.T) {
+	ctx := context.Background()
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	if _, err := Client(ctx, Options{ServiceAccountKey: missing}); err == nil || !strings.Contains(err.Error(), "unable to read service account key") {
+		t.Errorf("got error %v for a missing key", err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(bad, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Client(ctx, Options{ServiceAccountKey: bad}); err == nil || !strings.Contains(err.Error(), "unable to parse service account key") {
+		t.Errorf("got error %v for an invalid key", err)
+	}
+}
+
+func TestClientADC(t *testing.T) {
+	ts := newTokenServer(t)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", serviceAccountKey(t, ts))
+
+	client, err := Client(context.Background(), Options{Method: MethodADC, Subject: "sales@appscode.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ts.call(t, client), "jwt:quotes@quotes.iam.gserviceaccount.com:sales@appscode.com"; got != want {
+		t.Errorf("got token %q, want %q", got, want)
+	}
+}
+
+func TestClientOAuth(t *testing.T) {
+	ts := newTokenServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	expired := &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+	if err := writeToken(tokenFile, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := Client(context.Background(), Options{CredentialsFile: clientSecrets(t, ts), TokenFile: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ts.call(t, client); got != "refreshed:refresh" {
+		t.Errorf("got token %q, want the refreshed one", got)
+	}
+	// the refreshed token is cached for the next run
+	tok, err := ReadToken(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "refreshed:refresh" || tok.RefreshToken != "refresh" {
+		t.Errorf("cached token %+v", tok)
+	}
+}
+
+func TestClientLoginRequired(t *testing.T) {
+	ts := newTokenServer(t)
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(empty, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tokenFile := range []string{filepath.Join(dir, "missing.json"), empty} {
+		_, err := Client(context.Background(), Options{CredentialsFile: clientSecrets(t, ts), TokenFile: tokenFile})
+		if !errors.Is(err, ErrLoginRequired) {
+			t.Errorf("token file %s: got error %v, want %v", filepath.Base(tokenFile), err, ErrLoginRequired)
+		}
+	}
+	if len(ts.forms) != 0 {
+		t.Errorf("requested %d tokens without signing in", len(ts.forms))
+	}
+
+	missing := filepath.Join(dir, "credentials.json")
+	if _, err := Client(context.Background(), Options{CredentialsFile: missing, TokenFile: empty}); err == nil || !strings.Contains(err.Error(), "unable to read client secret file") {
+		t.Errorf("got error %v for missing client secrets", err)
+	}
+}
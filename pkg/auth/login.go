@@ -0,0 +1,233 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// loginTimeout bounds how long Login waits for the browser to redirect back.
+const loginTimeout = 5 * time.Minute
+
+// Login signs in with the OAuth client of opts and caches the token in
+// opts.TokenFile.
+//
+// The authorization code is received on a loopback redirect: a listener on a
+// random port of 127.0.0.1 waits for the browser to return after the user
+// grants access. The request carries a random state, checked against the
+// redirect, and a PKCE code challenge, so that only this process can exchange
+// the code for a token.
+func Login(ctx context.Context, opts Options) (*oauth2.Token, error) {
+	cfg, err := oauthConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return login(ctx, cfg, opts.TokenFile)
+}
+
+func login(ctx context.Context, cfg *oauth2.Config, tokenFile string) (*oauth2.Token, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for the OAuth redirect: %v", err)
+	}
+	defer ln.Close()
+
+	c := *cfg
+	c.RedirectURL = fmt.Sprintf("http://%s/", ln.Addr().String())
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(64)
+	if err != nil {
+		return nil, err
+	}
+	authURL := c.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("prompt", "consent"),
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+
+	results := make(chan callback, 1)
+	srv := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			res := parseCallback(r.URL.Query(), state)
+			if res.err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "<p>Sign in failed: %s</p>", html.EscapeString(res.err.Error()))
+			} else {
+				fmt.Fprint(w, "<p>Signed in to quote-generator. You can close this window.</p>")
+			}
+			select {
+			case results <- res:
+			default:
+			}
+		}),
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	fmt.Fprintf(os.Stderr, "Opening the browser to sign in. If it does not open, go to:\n%s\n", authURL)
+	browse(authURL)
+
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+	var res callback
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for the browser to sign in: %v", ctx.Err())
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	tok, err := c.Exchange(ctx, res.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange the authorization code: %v", err)
+	}
+	if err = writeToken(tokenFile, tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+type callback struct {
+	code string
+	err  error
+}
+
+// parseCallback returns the authorization code of the redirect after checking
+// its state.
+func parseCallback(q url.Values, state string) callback {
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		return callback{err: errors.New("state of the OAuth redirect does not match")}
+	}
+	if e := q.Get("error"); e != "" {
+		return callback{err: fmt.Errorf("authorization denied: %s", e)}
+	}
+	code := q.Get("code")
+	if code == "" {
+		return callback{err: errors.New("OAuth redirect has no authorization code")}
+	}
+	return callback{code: code}
+}
+
+// codeChallenge returns the S256 PKCE code challenge of verifier: the
+// unpadded base64url encoding of its SHA-256 hash.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// browse opens the sign-in page. Tests replace it to follow the redirect
+// themselves.
+var browse = openBrowser
+
+// openBrowser tries to open url in the default browser.
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err == nil {
+		go func() { _ = cmd.Wait() }()
+	}
+}
+
+// revokeURL is Google's OAuth token revocation endpoint.
+var revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// Logout revokes the cached OAuth token of opts and deletes the token file.
+// The file is deleted even if the token can not be revoked.
+func Logout(ctx context.Context, opts Options) error {
+	tok, err := ReadToken(opts.TokenFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	var revokeErr error
+	if err == nil {
+		t := tok.RefreshToken
+		if t == "" {
+			t = tok.AccessToken
+		}
+		revokeErr = revoke(ctx, t)
+	}
+	if err = os.Remove(opts.TokenFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if revokeErr != nil {
+		return fmt.Errorf("token file removed, but the token could not be revoked: %v", revokeErr)
+	}
+	return nil
+}
+
+func revoke(ctx context.Context, token string) error {
+	req, err := http.NewRequest(http.MethodPost, revokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// an expired or already revoked token is reported as invalid_token
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("revoke returned %s", resp.Status)
+	}
+	return nil
+}
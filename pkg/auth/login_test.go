@@ -0,0 +1,294 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	// the example of RFC 7636, appendix B
+	if got, want := codeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("codeChallenge = %s, want %s", got, want)
+	}
+}
+
+func TestParseCallback(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		code  string
+		err   string
+	}{
+		{"code", url.Values{"state": {"s1"}, "code": {"c1"}}, "c1", ""},
+		{"state mismatch", url.Values{"state": {"s2"}, "code": {"c1"}}, "", "state of the OAuth redirect does not match"},
+		{"state missing", url.Values{"code": {"c1"}}, "", "state of the OAuth redirect does not match"},
+		{"state prefix", url.Values{"state": {"s"}, "code": {"c1"}}, "", "state of the OAuth redirect does not match"},
+		{"denied", url.Values{"state": {"s1"}, "error": {"access_denied"}}, "", "authorization denied: access_denied"},
+		// an error of a foreign redirect is not reported
+		{"denied with state mismatch", url.Values{"state": {"s2"}, "error": {"access_denied"}}, "", "state of the OAuth redirect does not match"},
+		{"code missing", url.Values{"state": {"s1"}}, "", "OAuth redirect has no authorization code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := parseCallback(tt.query, "s1")
+			if tt.err == "" && res.err != nil || tt.err != "" && (res.err == nil || res.err.Error() != tt.err) {
+				t.Fatalf("got error %v, want %q", res.err, tt.err)
+			}
+			if res.code != tt.code {
+				t.Errorf("got code %q, want %q", res.code, tt.code)
+			}
+		})
+	}
+}
+
+// fakeBrowser replaces browse with a browser that follows the sign-in page to
+// the loopback redirect with the query returned by redirect.
+type fakeBrowser struct {
+	t        *testing.T
+	redirect func(auth url.Values) url.Values
+
+	// auth holds the query of the sign-in page and status the reply to the
+	// redirect. browse returns before Login does, so they need no lock.
+	auth   url.Values
+	status int
+}
+
+func newFakeBrowser(t *testing.T, redirect func(auth url.Values) url.Values) *fakeBrowser {
+	b := &fakeBrowser{t: t, redirect: redirect}
+	old := browse
+	browse = b.open
+	t.Cleanup(func() { browse = old })
+	return b
+}
+
+func (b *fakeBrowser) open(authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		b.t.Error(err)
+		return
+	}
+	q := u.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		b.t.Error(err)
+		return
+	}
+	redirect.RawQuery = b.redirect(q).Encode()
+	resp, err := http.Get(redirect.String())
+	if err != nil {
+		b.t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	b.auth, b.status = q, resp.StatusCode
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTokenServer(t)
+	b := newFakeBrowser(t, func(auth url.Values) url.Values {
+		return url.Values{"state": {auth.Get("state")}, "code": {"granted"}}
+	})
+	tokenFile := filepath.Join(t.TempDir(), "quote-generator", "token.json")
+
+	tok, err := Login(context.Background(), Options{CredentialsFile: clientSecrets(t, ts), TokenFile: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "code:granted" || tok.RefreshToken != "refresh" {
+		t.Errorf("got token %+v", tok)
+	}
+	if cached, err := ReadToken(tokenFile); err != nil || cached.AccessToken != tok.AccessToken {
+		t.Errorf("token not cached: %v", err)
+	}
+
+	auth := b.auth
+	if b.status != http.StatusOK {
+		t.Errorf("redirect returned %d", b.status)
+	}
+	redirect, err := url.Parse(auth.Get("redirect_uri"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.Scheme != "http" || redirect.Hostname() != "127.0.0.1" || redirect.Port() == "" {
+		t.Errorf("redirect to %s, want a loopback address", redirect)
+	}
+	for k, want := range map[string]string{
+		"client_id":             "client-id",
+		"access_type":           "offline",
+		"prompt":                "consent",
+		"code_challenge_method": "S256",
+		"scope":                 strings.Join(Scopes, " "),
+	} {
+		if got := auth.Get(k); got != want {
+			t.Errorf("sign-in page %s = %q, want %q", k, got, want)
+		}
+	}
+	if len(auth.Get("state")) < 32 {
+		t.Errorf("state %q is too short", auth.Get("state"))
+	}
+
+	ts.mu.Lock()
+	forms := ts.forms
+	ts.mu.Unlock()
+	if len(forms) != 1 {
+		t.Fatalf("got %d token requests, want 1", len(forms))
+	}
+	exchange := forms[0]
+	if exchange.Get("code") != "granted" || exchange.Get("redirect_uri") != auth.Get("redirect_uri") {
+		t.Errorf("exchanged code %q for %s", exchange.Get("code"), exchange.Get("redirect_uri"))
+	}
+	verifier := exchange.Get("code_verifier")
+	if len(verifier) < 43 || codeChallenge(verifier) != auth.Get("code_challenge") {
+		t.Errorf("code verifier %q does not match the challenge %q", verifier, auth.Get("code_challenge"))
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		redirect func(auth url.Values) url.Values
+		err      string
+	}{
+		{
+			name: "state mismatch",
+			redirect: func(auth url.Values) url.Values {
+				return url.Values{"state": {"forged"}, "code": {"stolen"}}
+			},
+			err: "state of the OAuth redirect does not match",
+		},
+		{
+			name: "denied",
+			redirect: func(auth url.Values) url.Values {
+				return url.Values{"state": {auth.Get("state")}, "error": {"access_denied"}}
+			},
+			err: "authorization denied: access_denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTokenServer(t)
+			b := newFakeBrowser(t, tt.redirect)
+			tokenFile := filepath.Join(t.TempDir(), "token.json")
+
+			_, err := Login(context.Background(), Options{CredentialsFile: clientSecrets(t, ts), TokenFile: tokenFile})
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if b.status != http.StatusBadRequest {
+				t.Errorf("redirect returned %d, want %d", b.status, http.StatusBadRequest)
+			}
+			if len(ts.forms) != 0 {
+				t.Errorf("requested %d tokens", len(ts.forms))
+			}
+			if _, err = os.Stat(tokenFile); !os.IsNotExist(err) {
+				t.Errorf("token file written: %v", err)
+			}
+		})
+	}
+}
+
+// revokeServer replaces the revocation endpoint with a server replying
+// status and recording the revoked tokens.
+func revokeServer(t *testing.T, status int) *[]string {
+	t.Helper()
+	var mu sync.Mutex
+	var revoked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			http.Error(w, "bad request", http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		revoked = append(revoked, r.PostFormValue("token"))
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	old := revokeURL
+	revokeURL = srv.URL
+	t.Cleanup(func() { revokeURL = old })
+	return &revoked
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   *oauth2.Token
+		status  int
+		revoked string
+		err     string
+	}{
+		{"refresh token", &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}, http.StatusOK, "refresh", ""},
+		{"access token", &oauth2.Token{AccessToken: "access"}, http.StatusOK, "access", ""},
+		{"already revoked", &oauth2.Token{RefreshToken: "refresh"}, http.StatusBadRequest, "refresh", ""},
+		{"server error", &oauth2.Token{RefreshToken: "refresh"}, http.StatusInternalServerError, "refresh", "token file removed, but the token could not be revoked: revoke returned 500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked := revokeServer(t, tt.status)
+			tokenFile := filepath.Join(t.TempDir(), "token.json")
+			if err := writeToken(tokenFile, tt.token); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Logout(context.Background(), Options{TokenFile: tokenFile})
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			if len(*revoked) != 1 || (*revoked)[0] != tt.revoked {
+				t.Errorf("revoked %q, want %q", *revoked, tt.revoked)
+			}
+			if _, err = os.Stat(tokenFile); !os.IsNotExist(err) {
+				t.Errorf("token file not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestLogoutWithoutToken(t *testing.T) {
+	revoked := revokeServer(t, http.StatusOK)
+	dir := t.TempDir()
+	if err := Logout(context.Background(), Options{TokenFile: filepath.Join(dir, "missing.json")}); err != nil {
+		t.Errorf("logging out without a token: %v", err)
+	}
+
+	// an unreadable token is removed without revoking it
+	corrupt := filepath.Join(dir, "token.json")
+	if err := os.WriteFile(corrupt, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Logout(context.Background(), Options{TokenFile: corrupt}); err != nil {
+		t.Errorf("logging out with a corrupt token: %v", err)
+	}
+	if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Errorf("token file not removed: %v", err)
+	}
+	if len(*revoked) != 0 {
+		t.Errorf("revoked %q", *revoked)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// FolderStore finds and creates the folders generated quotes are stored in.
+type FolderStore interface {
+	// FindFolder returns the id of the folder with the given name under
+	// parentID, or an empty string if no such folder exists.
+	FindFolder(ctx context.Context, parentID, name string) (string, error)
+	// CreateFolder creates a folder with the given name under parentID.
+	CreateFolder(ctx context.Context, parentID, name string) (string, error)
+}
+
+// DocumentStore copies, updates and exports quote documents.
+type DocumentStore interface {
+	// CopyDocument copies the template document into folderID and returns the
+	// id of the copy.
+	CopyDocument(ctx context.Context, templateID, name, folderID string) (string, error)
+	// FindDocuments returns the ids of the documents with the given name in
+	// folderID that are not in the trash.
+	FindDocuments(ctx context.Context, folderID, name string) ([]string, error)
+	// GetDocument returns the document with its content.
+	GetDocument(ctx context.Context, docID string) (*docs.Document, error)
+	// BatchUpdate applies the requests to the document.
+	BatchUpdate(ctx context.Context, docID string, reqs []*docs.Request) (*docs.BatchUpdateDocumentResponse, error)
+	// ExportPDF renders the document as PDF.
+	ExportPDF(ctx context.Context, docID string) ([]byte, error)
+	// TrashDocument moves the document to the trash.
+	TrashDocument(ctx context.Context, docID string) error
+	// ShareDocument grants the permissions on the document and returns the
+	// link to view it.
+	ShareDocument(ctx context.Context, docID string, perms []Permission) (string, error)
+}
+
+// Types of grantees of a Permission.
+const (
+	GranteeUser   = "user"
+	GranteeGroup  = "group"
+	GranteeAnyone = "anyone"
+)
+
+// Roles granted by a Permission.
+const (
+	RoleReader    = "reader"
+	RoleCommenter = "commenter"
+	RoleWriter    = "writer"
+)
+
+// Permission grants access to a document.
+type Permission struct {
+	// Type is GranteeUser, GranteeGroup or GranteeAnyone. A permission for
+	// anyone grants access to whoever has the link; the document can not be
+	// found by searching.
+	Type string `json:"type"`
+	// Role is RoleReader, RoleCommenter or RoleWriter.
+	Role string `json:"role"`
+	// EmailAddress is the user or group granted access.
+	EmailAddress string `json:"emailAddress,omitempty"`
+	// Notify emails the user or group about the shared document, including
+	// Message.
+	Notify  bool   `json:"notify,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// QuoteLedger is the tabular log of issued quotes.
+type QuoteLedger interface {
+	// EnsureSchema creates the ledger with the given column headers, if missing.
+	// Headers are only ever added at the end, so an existing ledger gets the
+	// headers it does not have yet.
+	EnsureSchema(ctx context.Context, headers []string) error
+	// Rows returns the data rows of the ledger, excluding the header row.
+	Rows(ctx context.Context) ([][]string, error)
+	// Append adds a row at the end of the ledger and returns its index in Rows.
+	Append(ctx context.Context, row []string) (int, error)
+	// Update overwrites the row at index i of Rows.
+	Update(ctx context.Context, i int, row []string) error
+}
+
+// ErrNotFound is returned for a document or ledger sheet that does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrBusy is returned when a journal entry is claimed by another run.
+var ErrBusy = errors.New("journal entry is in use")
+
+// Journal persists the progress of quotes being generated, so that a run
+// interrupted half way can be finished or rolled back later.
+type Journal interface {
+	// Acquire claims the entry id for the caller and returns a func that
+	// releases it. It fails with ErrBusy if the entry is claimed already, e.g.
+	// by another process still working on it.
+	Acquire(id string) (func(), error)
+	// Save creates or replaces the entry id.
+	Save(ctx context.Context, id string, data []byte) error
+	// Load returns the entry id.
+	Load(ctx context.Context, id string) ([]byte, error)
+	// Remove deletes the entry id.
+	Remove(ctx context.Context, id string) error
+	// List returns the ids of all entries, sorted.
+	List(ctx context.Context) ([]string, error)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"strings"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// PlainText returns the text of the document body, headers and footers. The
+// text of tables is included in place, one line per cell paragraph.
+func PlainText(doc *docs.Document) string {
+	var sb strings.Builder
+	var write func(content []*docs.StructuralElement)
+	write = func(content []*docs.StructuralElement) {
+		for _, se := range content {
+			switch {
+			case se.Paragraph != nil:
+				sb.WriteString(ParagraphText(se.Paragraph))
+			case se.Table != nil:
+				for _, row := range se.Table.TableRows {
+					for _, cell := range row.TableCells {
+						write(cell.Content)
+					}
+				}
+			}
+		}
+	}
+	if doc.Body != nil {
+		write(doc.Body.Content)
+	}
+	for _, h := range doc.Headers {
+		write(h.Content)
+	}
+	for _, f := range doc.Footers {
+		write(f.Content)
+	}
+	return sb.String()
+}
+
+// WalkContent calls fn for every list of structural elements in the document:
+// the body, headers, footers, footnotes and the cells of tables in them.
+func WalkContent(doc *docs.Document, fn func(content []*docs.StructuralElement)) {
+	var walk func(content []*docs.StructuralElement)
+	walk = func(content []*docs.StructuralElement) {
+		fn(content)
+		for _, se := range content {
+			if se.Table == nil {
+				continue
+			}
+			for _, row := range se.Table.TableRows {
+				for _, cell := range row.TableCells {
+					walk(cell.Content)
+				}
+			}
+		}
+	}
+	if doc.Body != nil {
+		walk(doc.Body.Content)
+	}
+	for _, h := range doc.Headers {
+		walk(h.Content)
+	}
+	for _, f := range doc.Footers {
+		walk(f.Content)
+	}
+	for _, f := range doc.Footnotes {
+		walk(f.Content)
+	}
+}
+
+// ParagraphText returns the text of all text runs in p.
+func ParagraphText(p *docs.Paragraph) string {
+	var sb strings.Builder
+	for _, el := range p.Elements {
+		if el.TextRun != nil {
+			sb.WriteString(el.TextRun.Content)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,195 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileLedger is a QuoteLedger stored in a local append-only JSON Lines file.
+// Every change is appended as a record and the rows are rebuilt by replaying
+// the file. A record cut short by a crash is dropped. Access is serialized
+// with an advisory file lock, so several processes can share the file.
+type FileLedger struct {
+	path string
+}
+
+var _ QuoteLedger = &FileLedger{}
+
+// ledgerRecord is one line of the ledger file.
+type ledgerRecord struct {
+	Op      string   `json:"op"`
+	Headers []string `json:"headers,omitempty"`
+	Index   int      `json:"index,omitempty"`
+	Row     []string `json:"row,omitempty"`
+}
+
+const (
+	opSchema = "schema"
+	opAppend = "append"
+	opUpdate = "update"
+)
+
+func NewFileLedger(path string) *FileLedger {
+	return &FileLedger{path: path}
+}
+
+// withFile opens the ledger file, locks it and calls fn with the current
+// headers and rows. Without exclusive, the file is opened read-only and a
+// missing file is read as an empty ledger, so that reading never creates it.
+func (l *FileLedger) withFile(exclusive bool, fn func(f *os.File, headers []string, rows [][]string) error) error {
+	var f *os.File
+	var err error
+	if exclusive {
+		if err = os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+			return err
+		}
+		f, err = os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	} else {
+		f, err = os.Open(l.path)
+		if os.IsNotExist(err) {
+			return fn(nil, nil, nil)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	unlock, err := lockFile(f, exclusive)
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %v", l.path, err)
+	}
+	defer unlock()
+
+	headers, rows, size, err := replay(f)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", l.path, err)
+	}
+	if exclusive {
+		// the next record must start on a line of its own
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() > size {
+			// by path, as a file opened for appending can not be truncated
+			// on Windows
+			if err = os.Truncate(l.path, size); err != nil {
+				return fmt.Errorf("unable to truncate the incomplete last record of %s: %v", l.path, err)
+			}
+		}
+	}
+	return fn(f, headers, rows)
+}
+
+// replay rebuilds the headers and rows from the records read from r, and
+// returns the size of the complete records. A record is complete once its
+// newline is written: a last line without one is left by a write cut short,
+// e.g. by a crash, and is ignored.
+func replay(r io.Reader) ([]string, [][]string, int64, error) {
+	var headers []string
+	var rows [][]string
+	var size int64
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return headers, rows, size, nil
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		size += int64(len(data))
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+		var rec ledgerRecord
+		if err = json.Unmarshal(data, &rec); err != nil {
+			return nil, nil, 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		switch rec.Op {
+		case opSchema:
+			headers = rec.Headers
+		case opAppend:
+			rows = append(rows, rec.Row)
+		case opUpdate:
+			if rec.Index < 0 || rec.Index >= len(rows) {
+				return nil, nil, 0, fmt.Errorf("line %d: row %d out of range", line, rec.Index)
+			}
+			rows[rec.Index] = rec.Row
+		default:
+			return nil, nil, 0, fmt.Errorf("line %d: unknown op %q", line, rec.Op)
+		}
+	}
+}
+
+func writeRecord(w io.Writer, rec ledgerRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (l *FileLedger) EnsureSchema(_ context.Context, headers []string) error {
+	return l.withFile(true, func(f *os.File, current []string, _ [][]string) error {
+		if len(current) >= len(headers) {
+			return nil
+		}
+		return writeRecord(f, ledgerRecord{Op: opSchema, Headers: headers})
+	})
+}
+
+func (l *FileLedger) Rows(_ context.Context) ([][]string, error) {
+	var result [][]string
+	err := l.withFile(false, func(_ *os.File, _ []string, rows [][]string) error {
+		result = rows
+		return nil
+	})
+	return result, err
+}
+
+func (l *FileLedger) Append(_ context.Context, row []string) (int, error) {
+	idx := -1
+	err := l.withFile(true, func(f *os.File, _ []string, rows [][]string) error {
+		if err := writeRecord(f, ledgerRecord{Op: opAppend, Row: row}); err != nil {
+			return err
+		}
+		idx = len(rows)
+		return nil
+	})
+	return idx, err
+}
+
+func (l *FileLedger) Update(_ context.Context, i int, row []string) error {
+	return l.withFile(true, func(f *os.File, _ []string, rows [][]string) error {
+		if i < 0 || i >= len(rows) {
+			return fmt.Errorf("row %d out of range", i)
+		}
+		return writeRecord(f, ledgerRecord{Op: opUpdate, Index: i, Row: row})
+	})
+}
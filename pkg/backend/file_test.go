@@ -0,0 +1,174 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func checkRows(t *testing.T, l QuoteLedger, want ...string) {
+	t.Helper()
+	rows, err := l.Rows(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(rows))
+	for _, row := range rows {
+		got = append(got, strings.Join(row, ","))
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("rows %q, want %q", got, want)
+	}
+}
+
+func TestFileLedger(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "quotes", "ledger.jsonl")
+	l := NewFileLedger(path)
+
+	// reading a missing ledger does not create it
+	checkRows(t, l)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("reading created the ledger: %v", err)
+	}
+
+	if err := l.EnsureSchema(ctx, []string{"Quote", "Email"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range [][]string{{"Q1", "a@example.com"}, {"Q2", "b@example.com"}, {"Q3", "c@example.com"}} {
+		idx, err := l.Append(ctx, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx != i {
+			t.Errorf("appended row %d at %d", i, idx)
+		}
+	}
+	if err := l.Update(ctx, 1, []string{"Q2", "b@example.org", "void"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{-1, 3} {
+		if err := l.Update(ctx, i, []string{"Q4"}); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("updating row %d: got error %v", i, err)
+		}
+	}
+	// a schema with fewer columns does not replace the current one
+	if err := l.EnsureSchema(ctx, []string{"Quote"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.EnsureSchema(ctx, []string{"Quote", "Email", "Status"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rows are rebuilt from the file by another instance
+	checkRows(t, NewFileLedger(path), "Q1,a@example.com", "Q2,b@example.org,void", "Q3,c@example.com")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 6 {
+		t.Errorf("ledger has %d records, want 6:\n%s", n, data)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	headers, _, _, err := replay(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(headers, ",") != "Quote,Email,Status" {
+		t.Errorf("headers %q", headers)
+	}
+}
+
+func TestFileLedgerIncompleteRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	l := NewFileLedger(path)
+	for _, q := range []string{"Q1", "Q2"} {
+		if _, err := l.Append(ctx, []string{q}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a crash while appending leaves half a record behind
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString(`{"op":"append","row":["Q3"`); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, l, "Q1", "Q2")
+
+	idx, err := l.Append(ctx, []string{"Q4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != 2 {
+		t.Errorf("appended at %d, want 2", idx)
+	}
+	checkRows(t, l, "Q1", "Q2", "Q4")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Q3") || !strings.HasSuffix(string(data), "\n") {
+		t.Errorf("incomplete record not dropped:\n%s", data)
+	}
+}
+
+func TestFileLedgerCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"invalid json", "{\"op\":\"append\",\"row\":[\"Q1\"]}\n{\"op\":\"append\",\"row\":\n{\"op\":\"append\",\"row\":[\"Q2\"]}\n", "line 2"},
+		{"invalid last line", "{\"op\":\"append\",\"row\":[\"Q1\"]}\nnot json\n", "line 2"},
+		{"unknown op", "{\"op\":\"delete\",\"index\":0}\n", `line 1: unknown op "delete"`},
+		{"update out of range", "{\"op\":\"append\",\"row\":[\"Q1\"]}\n\n{\"op\":\"update\",\"index\":1,\"row\":[\"Q2\"]}\n", "line 3: row 1 out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ledger.jsonl")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			l := NewFileLedger(path)
+			if _, err := l.Rows(context.Background()); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			// a corrupt ledger is not written to
+			if _, err := l.Append(context.Background(), []string{"Q9"}); err == nil {
+				t.Error("appended to a corrupt ledger")
+			}
+			if data, err := os.ReadFile(path); err != nil || string(data) != tt.data {
+				t.Errorf("corrupt ledger changed: %v\n%s", err, data)
+			}
+		})
+	}
+}
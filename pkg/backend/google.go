@@ -0,0 +1,376 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// GoogleDrive is a FolderStore backed by Google Drive.
+type GoogleDrive struct {
+	srv *drive.Service
+}
+
+var _ FolderStore = &GoogleDrive{}
+
+func NewGoogleDrive(srv *drive.Service) *GoogleDrive {
+	return &GoogleDrive{srv: srv}
+}
+
+func (g *GoogleDrive) FindFolder(ctx context.Context, parentID, name string) (string, error) {
+	// https://developers.google.com/drive/api/v3/search-files
+	q := fmt.Sprintf("name = '%s' and mimeType = '%s' and '%s' in parents", escapeQuery(name), folderMimeType, parentID)
+	files, err := g.srv.Files.List().Q(q).Spaces("drive").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	if len(files.Files) > 0 {
+		return files.Files[0].Id, nil
+	}
+	return "", nil
+}
+
+func (g *GoogleDrive) CreateFolder(ctx context.Context, parentID, name string) (string, error) {
+	// https://developers.google.com/drive/api/v3/folder#java
+	folderMetadata := &drive.File{
+		Name:     name,
+		MimeType: folderMimeType,
+		Parents:  []string{parentID},
+	}
+	folder, err := g.srv.Files.Create(folderMetadata).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return folder.Id, nil
+}
+
+func escapeQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// GoogleDocs is a DocumentStore backed by Google Docs and Google Drive.
+type GoogleDocs struct {
+	drive *drive.Service
+	docs  *docs.Service
+}
+
+var _ DocumentStore = &GoogleDocs{}
+
+func NewGoogleDocs(srvDrive *drive.Service, srvDoc *docs.Service) *GoogleDocs {
+	return &GoogleDocs{drive: srvDrive, docs: srvDoc}
+}
+
+func (g *GoogleDocs) CopyDocument(ctx context.Context, templateID, name, folderID string) (string, error) {
+	// https://developers.google.com/docs/api/how-tos/documents#copying_an_existing_document
+	copyMetadata := &drive.File{
+		Name:    name,
+		Parents: []string{folderID},
+	}
+	copyFile, err := g.drive.Files.Copy(templateID, copyMetadata).Fields("id", "parents").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return copyFile.Id, nil
+}
+
+func (g *GoogleDocs) FindDocuments(ctx context.Context, folderID, name string) ([]string, error) {
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", escapeQuery(name), folderID)
+	var ids []string
+	err := g.drive.Files.List().Q(q).Spaces("drive").Fields("nextPageToken", "files(id)").Pages(ctx, func(files *drive.FileList) error {
+		for _, f := range files.Files {
+			ids = append(ids, f.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (g *GoogleDocs) GetDocument(ctx context.Context, docID string) (*docs.Document, error) {
+	doc, err := g.docs.Documents.Get(docID).Context(ctx).Do()
+	if isNotFound(err) {
+		return nil, fmt.Errorf("document %s %w: %v", docID, ErrNotFound, err)
+	}
+	return doc, err
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func (g *GoogleDocs) BatchUpdate(ctx context.Context, docID string, reqs []*docs.Request) (*docs.BatchUpdateDocumentResponse, error) {
+	return g.docs.Documents.BatchUpdate(docID, &docs.BatchUpdateDocumentRequest{
+		Requests: reqs,
+	}).Context(ctx).Do()
+}
+
+func (g *GoogleDocs) ExportPDF(ctx context.Context, docID string) ([]byte, error) {
+	resp, err := g.drive.Files.Export(docID, "application/pdf").Context(ctx).Download()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *GoogleDocs) TrashDocument(ctx context.Context, docID string) error {
+	_, err := g.drive.Files.Update(docID, &drive.File{Trashed: true}).Fields("id").Context(ctx).Do()
+	return err
+}
+
+func (g *GoogleDocs) ShareDocument(ctx context.Context, docID string, perms []Permission) (string, error) {
+	// https://developers.google.com/drive/api/v3/manage-sharing
+	for _, p := range perms {
+		call := g.drive.Permissions.Create(docID, &drive.Permission{
+			Type:         p.Type,
+			Role:         p.Role,
+			EmailAddress: p.EmailAddress,
+		})
+		if p.Type != GranteeAnyone {
+			call = call.SendNotificationEmail(p.Notify)
+			if p.Notify && p.Message != "" {
+				call = call.EmailMessage(p.Message)
+			}
+		}
+		if _, err := call.Fields("id").Context(ctx).Do(); err != nil {
+			return "", fmt.Errorf("unable to share with %s: %v", grantee(p), err)
+		}
+	}
+	f, err := g.drive.Files.Get(docID).Fields("webViewLink").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return f.WebViewLink, nil
+}
+
+func grantee(p Permission) string {
+	if p.EmailAddress != "" {
+		return p.EmailAddress
+	}
+	return p.Type
+}
+
+// GoogleSheet is a QuoteLedger stored in a sheet of a Google Spreadsheet.
+type GoogleSheet struct {
+	srv           *sheets.Service
+	spreadsheetID string
+	sheetName     string
+}
+
+var _ QuoteLedger = &GoogleSheet{}
+
+func NewGoogleSheet(srv *sheets.Service, spreadsheetID, sheetName string) *GoogleSheet {
+	return &GoogleSheet{
+		srv:           srv,
+		spreadsheetID: spreadsheetID,
+		sheetName:     sheetName,
+	}
+}
+
+// a1 returns the A1 notation of cells in the ledger sheet.
+func (g *GoogleSheet) a1(cells string) string {
+	return "'" + strings.ReplaceAll(g.sheetName, "'", "''") + "'!" + cells
+}
+
+// EnsureSchema creates the sheet with the headers if it does not exist. For an
+// existing sheet, headers missing at the end of the header row are added.
+func (g *GoogleSheet) EnsureSchema(ctx context.Context, headers []string) error {
+	resp, err := g.srv.Spreadsheets.Get(g.spreadsheetID).Fields("sheets.properties").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to retrieve data from sheet: %v", err)
+	}
+	for _, sheet := range resp.Sheets {
+		if sheet.Properties.Title == g.sheetName {
+			cur, err := g.srv.Spreadsheets.Values.Get(g.spreadsheetID, g.a1("1:1")).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("unable to retrieve data from sheet: %v", err)
+			}
+			var n int
+			if len(cur.Values) > 0 {
+				n = len(cur.Values[0])
+			}
+			if n >= len(headers) {
+				return nil
+			}
+			return g.writeHeaders(ctx, sheet.Properties.SheetId, n, headers[n:])
+		}
+	}
+
+	add, err := g.srv.Spreadsheets.BatchUpdate(g.spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{
+				AddSheet: &sheets.AddSheetRequest{
+					Properties: &sheets.SheetProperties{
+						Title: g.sheetName,
+					},
+				},
+			},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return g.writeHeaders(ctx, add.Replies[0].AddSheet.Properties.SheetId, 0, headers)
+}
+
+// writeHeaders writes headers into the first row, starting at column col.
+func (g *GoogleSheet) writeHeaders(ctx context.Context, sheetID int64, col int, headers []string) error {
+	// header row is bold with a highlighted background
+	format := &sheets.CellFormat{
+		TextFormat: &sheets.TextFormat{
+			Bold: true,
+		},
+		BackgroundColor: &sheets.Color{
+			Alpha: 1,
+			Blue:  149.0 / 255.0,
+			Green: 226.0 / 255.0,
+			Red:   239.0 / 255.0,
+		},
+	}
+	vals := make([]*sheets.CellData, 0, len(headers))
+	for i := range headers {
+		vals = append(vals, &sheets.CellData{
+			UserEnteredFormat: format,
+			UserEnteredValue: &sheets.ExtendedValue{
+				StringValue: &headers[i],
+			},
+		})
+	}
+	_, err := g.srv.Spreadsheets.BatchUpdate(g.spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{
+				UpdateCells: &sheets.UpdateCellsRequest{
+					Fields: "*",
+					Start: &sheets.GridCoordinate{
+						SheetId:     sheetID,
+						ColumnIndex: int64(col),
+					},
+					Rows: []*sheets.RowData{
+						{
+							Values: vals,
+						},
+					},
+				},
+			},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to update: %v", err)
+	}
+	return nil
+}
+
+func (g *GoogleSheet) Rows(ctx context.Context) ([][]string, error) {
+	resp, err := g.srv.Spreadsheets.Values.Get(g.spreadsheetID, g.a1("A2:ZZ")).Context(ctx).Do()
+	if err != nil {
+		// the range of a missing sheet can not be parsed
+		if ok, checkErr := g.sheetExists(ctx); checkErr == nil && !ok {
+			return nil, fmt.Errorf("sheet %s %w", g.sheetName, ErrNotFound)
+		}
+		return nil, fmt.Errorf("unable to retrieve data from sheet: %v", err)
+	}
+	rows := make([][]string, 0, len(resp.Values))
+	for _, vals := range resp.Values {
+		row := make([]string, 0, len(vals))
+		for _, v := range vals {
+			row = append(row, fmt.Sprint(v))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (g *GoogleSheet) sheetExists(ctx context.Context) (bool, error) {
+	resp, err := g.srv.Spreadsheets.Get(g.spreadsheetID).Fields("sheets.properties").Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+	for _, sheet := range resp.Sheets {
+		if sheet.Properties.Title == g.sheetName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (g *GoogleSheet) Append(ctx context.Context, row []string) (int, error) {
+	vals := make([]interface{}, 0, len(row))
+	for _, v := range row {
+		vals = append(vals, v)
+	}
+	resp, err := g.srv.Spreadsheets.Values.Append(g.spreadsheetID, g.a1("A1"), &sheets.ValueRange{
+		Values: [][]interface{}{vals},
+	}).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(ctx).Do()
+	if err != nil {
+		return -1, fmt.Errorf("unable to update: %v", err)
+	}
+	n, err := firstRow(resp.Updates.UpdatedRange)
+	if err != nil {
+		return -1, err
+	}
+	// row 1 holds the headers
+	return n - 2, nil
+}
+
+func (g *GoogleSheet) Update(ctx context.Context, i int, row []string) error {
+	vals := make([]interface{}, 0, len(row))
+	for _, v := range row {
+		vals = append(vals, v)
+	}
+	// row 1 holds the headers
+	_, err := g.srv.Spreadsheets.Values.Update(g.spreadsheetID, g.a1(fmt.Sprintf("A%d", i+2)), &sheets.ValueRange{
+		Values: [][]interface{}{vals},
+	}).ValueInputOption("RAW").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to update: %v", err)
+	}
+	return nil
+}
+
+var a1RowRegex = regexp.MustCompile(`![A-Z]+(\d+)`)
+
+// firstRow returns the 1-based row number where an A1 range starts.
+func firstRow(a1 string) (int, error) {
+	m := a1RowRegex.FindStringSubmatch(a1)
+	if m == nil {
+		return 0, fmt.Errorf("failed to detect row from range %s", a1)
+	}
+	return strconv.Atoi(m[1])
+}
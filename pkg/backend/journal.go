@@ -0,0 +1,150 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileJournal is a Journal keeping each entry in a JSON file of a directory.
+// Entries are claimed with an advisory lock on a companion .lock file, so a
+// run is never resumed while the process that started it is still alive.
+type FileJournal struct {
+	dir string
+}
+
+var _ Journal = &FileJournal{}
+
+func NewFileJournal(dir string) *FileJournal {
+	return &FileJournal{dir: dir}
+}
+
+func (j *FileJournal) path(id, ext string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid journal entry id %q", id)
+	}
+	return filepath.Join(j.dir, id+ext), nil
+}
+
+func (j *FileJournal) Acquire(id string) (func(), error) {
+	name, err := j.path(id, ".lock")
+	if err != nil {
+		return nil, err
+	}
+	entry, _ := j.path(id, ".json")
+	if err = os.MkdirAll(j.dir, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := tryLockFile(f)
+	if err != nil {
+		f.Close()
+		if err == ErrBusy {
+			return nil, fmt.Errorf("%w: %s", ErrBusy, id)
+		}
+		return nil, fmt.Errorf("unable to lock %s: %v", name, err)
+	}
+	return func() {
+		// the lock file of a removed entry is deleted while still locked, so
+		// no other process can claim it in between
+		if _, err := os.Stat(entry); os.IsNotExist(err) {
+			_ = os.Remove(name)
+		}
+		unlock()
+		f.Close()
+	}, nil
+}
+
+// Save writes the entry to a temporary file first, so that an interrupted
+// write never leaves a truncated entry behind.
+func (j *FileJournal) Save(_ context.Context, id string, data []byte) error {
+	name, err := j.path(id, ".json")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(j.dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(j.dir, ".tmp-"+id+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (j *FileJournal) Load(_ context.Context, id string) ([]byte, error) {
+	name, err := j.path(id, ".json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("journal entry %s not found", id)
+	}
+	return data, err
+}
+
+func (j *FileJournal) Remove(_ context.Context, id string) error {
+	name, err := j.path(id, ".json")
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (j *FileJournal) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(j.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
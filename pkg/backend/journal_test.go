@@ -0,0 +1,126 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileJournal(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "journal")
+	j := NewFileJournal(dir)
+
+	if ids, err := j.List(ctx); err != nil || len(ids) != 0 {
+		t.Fatalf("empty journal lists %v: %v", ids, err)
+	}
+	for _, id := range []string{"run-2", "run-1"} {
+		if err := j.Save(ctx, id, []byte(`{"id":"`+id+`"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Save(ctx, "run-1", []byte(`{"id":"run-1","step":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	// files that are not entries are not listed
+	for _, name := range []string{".tmp-run-3-123", "run-4.lock", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "run-5.json"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := j.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, " ") != "run-1 run-2" {
+		t.Errorf("listed %v, want [run-1 run-2]", ids)
+	}
+	data, err := j.Load(ctx, "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"id":"run-1","step":2}` {
+		t.Errorf("loaded %s", data)
+	}
+
+	if err = j.Remove(ctx, "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Remove(ctx, "run-1"); err != nil {
+		t.Errorf("removing a removed entry: %v", err)
+	}
+	if _, err = j.Load(ctx, "run-1"); err == nil || !strings.Contains(err.Error(), "journal entry run-1 not found") {
+		t.Errorf("got error %v loading a removed entry", err)
+	}
+	if ids, err = j.List(ctx); err != nil || strings.Join(ids, " ") != "run-2" {
+		t.Errorf("listed %v after removing run-1: %v", ids, err)
+	}
+}
+
+func TestFileJournalInvalidID(t *testing.T) {
+	ctx := context.Background()
+	j := NewFileJournal(t.TempDir())
+	for _, id := range []string{"", "../run", `a\b`, ".hidden"} {
+		if err := j.Save(ctx, id, nil); err == nil || !strings.Contains(err.Error(), "invalid journal entry id") {
+			t.Errorf("saving %q: got error %v", id, err)
+		}
+		if _, err := j.Acquire(id); err == nil {
+			t.Errorf("acquired %q", id)
+		}
+	}
+}
+
+func TestFileJournalAcquire(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	j := NewFileJournal(dir)
+	if err := j.Save(ctx, "run-1", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := j.Acquire("run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another journal on the same directory, as of another process
+	if _, err = NewFileJournal(dir).Acquire("run-1"); !errors.Is(err, ErrBusy) {
+		t.Errorf("got error %v acquiring a claimed entry, want %v", err, ErrBusy)
+	}
+	release()
+
+	release, err = NewFileJournal(dir).Acquire("run-1")
+	if err != nil {
+		t.Fatalf("acquiring a released entry: %v", err)
+	}
+	// the lock file of a removed entry is deleted on release
+	if err = j.Remove(ctx, "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err = os.Stat(filepath.Join(dir, "run-1.lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file of a removed entry left behind: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build windows
+
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes a lock on f by creating a companion .lock file and returns a
+// func that releases it. Readers take the same lock as writers.
+func lockFile(f *os.File, _ bool) (func(), error) {
+	return acquireLockFile(f.Name()+".lock", true)
+}
+
+// tryLockFile takes a lock on f like lockFile, without waiting. It fails with
+// ErrBusy if f is locked already.
+func tryLockFile(f *os.File) (func(), error) {
+	return acquireLockFile(f.Name()+".lock", false)
+}
+
+const (
+	errorInvalidParameter = syscall.Errno(87)
+	stillActive           = 259
+)
+
+// processAlive reports whether the process pid runs. Processes of other
+// users can not be opened and are taken as running.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err != errorInvalidParameter
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err = syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
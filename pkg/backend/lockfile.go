@@ -0,0 +1,139 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Lock files are the fallback of platforms without advisory file locks: the
+// lock is held by whoever created the file. They record the pid and host of
+// the holder, so that the lock file left behind by a crashed process can be
+// told apart from a lock in use.
+
+// staleLockAge is how old a lock file must be to be taken as stale if its
+// holder can not be checked, e.g. because it runs on another host.
+const staleLockAge = time.Minute
+
+// lockWaitTimeout bounds how long acquireLockFile waits for the lock.
+const lockWaitTimeout = time.Minute
+
+// acquireLockFile takes the lock of the lock file name and returns a func that
+// releases it. If wait is set, it waits up to lockWaitTimeout for the holder
+// to release the lock, else it fails with ErrBusy at once.
+func acquireLockFile(name string, wait bool) (func(), error) {
+	deadline := time.Now().Add(lockWaitTimeout)
+	for {
+		err := createLockFile(name)
+		if err == nil {
+			return func() {
+				_ = os.Remove(name)
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if !wait {
+			return nil, ErrBusy
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the lock %s", name)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// createLockFile creates the lock file name holding the pid and host of this
+// process. A stale lock file is replaced; if name is in use, the error
+// satisfies errors.Is(err, os.ErrExist).
+func createLockFile(name string) error {
+	err := createFileExcl(name, lockOwner())
+	if errors.Is(err, os.ErrExist) && removeStaleLock(name) {
+		err = createFileExcl(name, lockOwner())
+	}
+	return err
+}
+
+func createFileExcl(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(name)
+	}
+	return err
+}
+
+// lockOwner returns the content of the lock files of this process.
+func lockOwner() []byte {
+	return []byte(fmt.Sprintf("%d %s\n", os.Getpid(), hostname()))
+}
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return h
+}
+
+// removeStaleLock removes the lock file name if it is stale and reports
+// whether it did.
+func removeStaleLock(name string) bool {
+	data, fi, err := readLockFile(name)
+	if err != nil || !staleLock(data, fi.ModTime(), time.Now()) {
+		return false
+	}
+	// another process may have replaced the stale lock with its own lock
+	// meanwhile; that lock is left alone
+	again, fi2, err := readLockFile(name)
+	if err != nil || !bytes.Equal(again, data) || !fi2.ModTime().Equal(fi.ModTime()) {
+		return false
+	}
+	return os.Remove(name) == nil
+}
+
+func readLockFile(name string) ([]byte, os.FileInfo, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(name)
+	return data, fi, err
+}
+
+// staleLock reports whether a lock file with data, last modified at mod, was
+// left behind: the process of this host that created it is gone, or, for lock
+// files of other hosts and lock files whose holder is unknown, it is older
+// than staleLockAge.
+func staleLock(data []byte, mod, now time.Time) bool {
+	var pid int
+	var host string
+	if _, err := fmt.Sscanf(string(data), "%d %s", &pid, &host); err == nil && pid > 0 && host == hostname() {
+		return !processAlive(pid)
+	}
+	return now.Sub(mod) > staleLockAge
+}
@@ -0,0 +1,116 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// deadPID returns the pid of a process that exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestAcquireLockFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "ledger.jsonl.lock")
+	release, err := acquireLockFile(name, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%d %s\n", os.Getpid(), hostname()); string(data) != want {
+		t.Errorf("lock file holds %q, want %q", data, want)
+	}
+	if _, err = acquireLockFile(name, false); !errors.Is(err, ErrBusy) {
+		t.Errorf("got error %v taking a held lock, want %v", err, ErrBusy)
+	}
+
+	// a waiting caller gets the lock once it is released
+	held := release
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		held()
+	}()
+	release, err = acquireLockFile(name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("lock file not removed: %v", err)
+	}
+}
+
+func TestAcquireStaleLockFile(t *testing.T) {
+	old := time.Now().Add(-2 * staleLockAge)
+	tests := []struct {
+		name  string
+		data  string
+		mod   time.Time
+		stale bool
+	}{
+		{"running process", fmt.Sprintf("%d %s\n", os.Getpid(), hostname()), old, false},
+		{"crashed process", fmt.Sprintf("%d %s\n", deadPID(t), hostname()), time.Now(), true},
+		{"other host", fmt.Sprintf("%d other-%s\n", deadPID(t), hostname()), time.Now(), false},
+		{"old lock of other host", fmt.Sprintf("%d other-%s\n", os.Getpid(), hostname()), old, true},
+		{"unknown holder", "", time.Now(), false},
+		{"old lock of unknown holder", "", old, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "ledger.jsonl.lock")
+			if err := os.WriteFile(name, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(name, tt.mod, tt.mod); err != nil {
+				t.Fatal(err)
+			}
+
+			release, err := acquireLockFile(name, false)
+			if !tt.stale {
+				if !errors.Is(err, ErrBusy) {
+					t.Errorf("got error %v, want %v", err, ErrBusy)
+				}
+				if data, _ := os.ReadFile(name); string(data) != tt.data {
+					t.Errorf("lock file changed to %q", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stale lock not replaced: %v", err)
+			}
+			defer release()
+			if data, _ := os.ReadFile(name); string(data) != string(lockOwner()) {
+				t.Errorf("lock file holds %q, want %q", data, lockOwner())
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// Memory is an in-memory FolderStore, DocumentStore, QuoteLedger and Journal.
+// It is meant for tests and for exercising the generator without a Google
+// account.
+type Memory struct {
+	mu      sync.Mutex
+	lastID  int
+	folders map[string]memFolder
+	docs    map[string]*docs.Document
+	parents map[string]string
+	trash   map[string]*docs.Document
+	perms   map[string][]Permission
+	headers []string
+	rows    [][]string
+	journal map[string][]byte
+	claimed map[string]bool
+}
+
+type memFolder struct {
+	parentID string
+	name     string
+}
+
+var (
+	_ FolderStore   = &Memory{}
+	_ DocumentStore = &Memory{}
+	_ QuoteLedger   = &Memory{}
+	_ Journal       = &Memory{}
+)
+
+func NewMemory() *Memory {
+	return &Memory{
+		folders: map[string]memFolder{},
+		docs:    map[string]*docs.Document{},
+		parents: map[string]string{},
+		trash:   map[string]*docs.Document{},
+		perms:   map[string][]Permission{},
+		journal: map[string][]byte{},
+		claimed: map[string]bool{},
+	}
+}
+
+func (m *Memory) newID(kind string) string {
+	m.lastID++
+	return fmt.Sprintf("%s-%d", kind, m.lastID)
+}
+
+func (m *Memory) FindFolder(_ context.Context, parentID, name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, f := range m.folders {
+		if f.parentID == parentID && f.name == name {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+func (m *Memory) CreateFolder(_ context.Context, parentID, name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.newID("folder")
+	m.folders[id] = memFolder{parentID: parentID, name: name}
+	return id, nil
+}
+
+// PutDocument stores doc under id, typically to seed a template.
+func (m *Memory) PutDocument(id string, doc *docs.Document) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc.DocumentId = id
+	m.docs[id] = doc
+}
+
+// Document returns the stored document with the given id, or nil.
+func (m *Memory) Document(id string) *docs.Document {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.docs[id]
+}
+
+func (m *Memory) CopyDocument(_ context.Context, templateID, name, folderID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tpl, ok := m.docs[templateID]
+	if !ok {
+		return "", fmt.Errorf("document %s %w", templateID, ErrNotFound)
+	}
+	if _, ok := m.folders[folderID]; !ok {
+		return "", fmt.Errorf("folder %s not found", folderID)
+	}
+	doc, err := cloneDocument(tpl)
+	if err != nil {
+		return "", err
+	}
+	doc.DocumentId = m.newID("doc")
+	doc.Title = name
+	m.docs[doc.DocumentId] = doc
+	m.parents[doc.DocumentId] = folderID
+	return doc.DocumentId, nil
+}
+
+func (m *Memory) FindDocuments(_ context.Context, folderID, name string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for id, doc := range m.docs {
+		if m.parents[id] == folderID && doc.Title == name {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *Memory) GetDocument(_ context.Context, docID string) (*docs.Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.docs[docID]
+	if !ok {
+		return nil, fmt.Errorf("document %s %w", docID, ErrNotFound)
+	}
+	return cloneDocument(doc)
+}
+
+func cloneDocument(doc *docs.Document) (*docs.Document, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var out docs.Document
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// BatchUpdate supports the ReplaceAllText, InsertText, DeleteContentRange and
+// InsertTableRow requests. Like Google Docs, the requests are applied in order
+// and either all or none of them take effect.
+func (m *Memory) BatchUpdate(_ context.Context, docID string, reqs []*docs.Request) (*docs.BatchUpdateDocumentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orig, ok := m.docs[docID]
+	if !ok {
+		return nil, fmt.Errorf("document %s %w", docID, ErrNotFound)
+	}
+	doc, err := cloneDocument(orig)
+	if err != nil {
+		return nil, err
+	}
+	resp := &docs.BatchUpdateDocumentResponse{
+		DocumentId: docID,
+		Replies:    make([]*docs.Response, 0, len(reqs)),
+	}
+	for i, req := range reqs {
+		reply, err := applyRequest(doc, req)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %v", i, err)
+		}
+		resp.Replies = append(resp.Replies, reply)
+	}
+	m.docs[docID] = doc
+	return resp, nil
+}
+
+// ExportPDF returns the plain text of the document instead of a real PDF.
+func (m *Memory) ExportPDF(_ context.Context, docID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.docs[docID]
+	if !ok {
+		return nil, fmt.Errorf("document %s %w", docID, ErrNotFound)
+	}
+	return []byte(PlainText(doc)), nil
+}
+
+func (m *Memory) TrashDocument(_ context.Context, docID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.docs[docID]
+	if !ok {
+		return fmt.Errorf("document %s %w", docID, ErrNotFound)
+	}
+	delete(m.docs, docID)
+	m.trash[docID] = doc
+	return nil
+}
+
+// Trashed reports whether the document with the given id is in the trash.
+func (m *Memory) Trashed(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.trash[id]
+	return ok
+}
+
+func (m *Memory) ShareDocument(_ context.Context, docID string, perms []Permission) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.docs[docID]; !ok {
+		return "", fmt.Errorf("document %s %w", docID, ErrNotFound)
+	}
+	m.perms[docID] = append(m.perms[docID], perms...)
+	return "https://docs.google.com/document/d/" + docID + "/edit", nil
+}
+
+// Permissions returns the permissions granted on the document with the given
+// id.
+func (m *Memory) Permissions(id string) []Permission {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Permission(nil), m.perms[id]...)
+}
+
+func (m *Memory) EnsureSchema(_ context.Context, headers []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.headers) < len(headers) {
+		m.headers = append([]string(nil), headers...)
+	}
+	return nil
+}
+
+func (m *Memory) Rows(_ context.Context) ([][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rows := make([][]string, 0, len(m.rows))
+	for _, row := range m.rows {
+		rows = append(rows, append([]string(nil), row...))
+	}
+	return rows, nil
+}
+
+func (m *Memory) Append(_ context.Context, row []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rows = append(m.rows, append([]string(nil), row...))
+	return len(m.rows) - 1, nil
+}
+
+func (m *Memory) Update(_ context.Context, i int, row []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i < 0 || i >= len(m.rows) {
+		return fmt.Errorf("row %d out of range", i)
+	}
+	m.rows[i] = append([]string(nil), row...)
+	return nil
+}
+
+func (m *Memory) Acquire(id string) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.claimed[id] {
+		return nil, fmt.Errorf("%w: %s", ErrBusy, id)
+	}
+	m.claimed[id] = true
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.claimed, id)
+	}, nil
+}
+
+func (m *Memory) Save(_ context.Context, id string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.journal[id] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *Memory) Load(_ context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.journal[id]
+	if !ok {
+		return nil, fmt.Errorf("journal entry %s not found", id)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (m *Memory) Remove(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.journal, id)
+	return nil
+}
+
+func (m *Memory) List(_ context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0, len(m.journal))
+	for id := range m.journal {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,426 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// NewTextDocument returns a document with one paragraph per line of text.
+// Consecutive lines starting with "|" form a table with one row per line and
+// cells separated by "|", e.g. "| {{item.product}} | {{item.amount}} |".
+func NewTextDocument(title, text string) *docs.Document {
+	doc := &docs.Document{
+		Title: title,
+		Body:  &docs.Body{Content: parseContent(text)},
+	}
+	renumber(doc)
+	return doc
+}
+
+func parseContent(text string) []*docs.StructuralElement {
+	var content []*docs.StructuralElement
+	var table *docs.Table
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "|") {
+			table = nil
+			content = append(content, newParagraph(strings.TrimSuffix(line, "\n")))
+			continue
+		}
+
+		cells := strings.Split(strings.Trim(trimmed, "|"), "|")
+		row := &docs.TableRow{}
+		for _, c := range cells {
+			row.TableCells = append(row.TableCells, &docs.TableCell{
+				Content: []*docs.StructuralElement{newParagraph(strings.TrimSpace(c))},
+			})
+		}
+		if table == nil {
+			table = &docs.Table{Columns: int64(len(cells))}
+			content = append(content, &docs.StructuralElement{Table: table})
+		}
+		table.TableRows = append(table.TableRows, row)
+		table.Rows++
+	}
+	if len(content) == 0 || content[len(content)-1].Paragraph == nil {
+		// like Google Docs, a document ends with a paragraph
+		content = append(content, newParagraph(""))
+	}
+	return content
+}
+
+// newParagraph returns a paragraph with text followed by a newline.
+func newParagraph(text string) *docs.StructuralElement {
+	return &docs.StructuralElement{
+		Paragraph: &docs.Paragraph{
+			Elements: []*docs.ParagraphElement{
+				{TextRun: &docs.TextRun{Content: text + "\n"}},
+			},
+		},
+	}
+}
+
+func setParagraphText(p *docs.Paragraph, text string) {
+	p.Elements = []*docs.ParagraphElement{
+		{TextRun: &docs.TextRun{Content: text}},
+	}
+}
+
+func applyRequest(doc *docs.Document, req *docs.Request) (*docs.Response, error) {
+	reply := &docs.Response{}
+	switch {
+	case req.ReplaceAllText != nil:
+		n := replaceAllText(doc, req.ReplaceAllText)
+		reply.ReplaceAllText = &docs.ReplaceAllTextResponse{OccurrencesChanged: n}
+	case req.InsertText != nil:
+		if err := insertText(doc, req.InsertText); err != nil {
+			return nil, err
+		}
+	case req.DeleteContentRange != nil:
+		if err := deleteContentRange(doc, req.DeleteContentRange); err != nil {
+			return nil, err
+		}
+	case req.InsertTableRow != nil:
+		if err := insertTableRow(doc, req.InsertTableRow); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported request %+v", req)
+	}
+	renumber(doc)
+	return reply, nil
+}
+
+func replaceAllText(doc *docs.Document, req *docs.ReplaceAllTextRequest) int64 {
+	var n int64
+	match := req.ContainsText
+	if match == nil || match.Text == "" {
+		return 0
+	}
+	WalkContent(doc, func(content []*docs.StructuralElement) {
+		for _, se := range content {
+			if se.Paragraph == nil {
+				continue
+			}
+			text := ParagraphText(se.Paragraph)
+			var count int
+			if match.MatchCase {
+				count = strings.Count(text, match.Text)
+				text = strings.ReplaceAll(text, match.Text, req.ReplaceText)
+			} else {
+				count, text = replaceFold(text, match.Text, req.ReplaceText)
+			}
+			if count == 0 {
+				continue
+			}
+			n += int64(count)
+			setParagraphText(se.Paragraph, text)
+		}
+	})
+	return n
+}
+
+// replaceFold is a case-insensitive strings.ReplaceAll that also returns the
+// number of replacements.
+func replaceFold(s, old, repl string) (int, string) {
+	var sb strings.Builder
+	var n int
+	lower, lowerOld := strings.ToLower(s), strings.ToLower(old)
+	if len(lower) != len(s) || len(lowerOld) != len(old) {
+		return strings.Count(s, old), strings.ReplaceAll(s, old, repl)
+	}
+	for {
+		i := strings.Index(lower, lowerOld)
+		if i < 0 {
+			break
+		}
+		sb.WriteString(s[:i])
+		sb.WriteString(repl)
+		s, lower = s[i+len(old):], lower[i+len(old):]
+		n++
+	}
+	sb.WriteString(s)
+	return n, sb.String()
+}
+
+var errIndexNotFound = errors.New("index is not inside a paragraph")
+
+// segmentContent returns the content of the body, header, footer or footnote
+// a request location refers to.
+func segmentContent(doc *docs.Document, segmentID string) ([]*docs.StructuralElement, func([]*docs.StructuralElement), error) {
+	if segmentID == "" {
+		return doc.Body.Content, func(c []*docs.StructuralElement) { doc.Body.Content = c }, nil
+	}
+	if h, ok := doc.Headers[segmentID]; ok {
+		return h.Content, func(c []*docs.StructuralElement) { h.Content = c }, nil
+	}
+	if f, ok := doc.Footers[segmentID]; ok {
+		return f.Content, func(c []*docs.StructuralElement) { f.Content = c }, nil
+	}
+	if f, ok := doc.Footnotes[segmentID]; ok {
+		return f.Content, func(c []*docs.StructuralElement) { f.Content = c }, nil
+	}
+	return nil, nil, fmt.Errorf("segment %s not found", segmentID)
+}
+
+func insertText(doc *docs.Document, req *docs.InsertTextRequest) error {
+	if req.Location == nil {
+		return errors.New("insert text: location is required")
+	}
+	content, set, err := segmentContent(doc, req.Location.SegmentId)
+	if err != nil {
+		return err
+	}
+	content, ok := insertInContent(content, req.Location.Index, req.Text)
+	if !ok {
+		return fmt.Errorf("insert text at %d: %v", req.Location.Index, errIndexNotFound)
+	}
+	set(content)
+	return nil
+}
+
+func insertInContent(content []*docs.StructuralElement, idx int64, text string) ([]*docs.StructuralElement, bool) {
+	for _, se := range content {
+		switch {
+		case se.Paragraph != nil && se.StartIndex <= idx && idx < se.EndIndex:
+			units := utf16.Encode([]rune(ParagraphText(se.Paragraph)))
+			off := idx - se.StartIndex
+			s := string(utf16.Decode(units[:off])) + text + string(utf16.Decode(units[off:]))
+			setParagraphText(se.Paragraph, s)
+			return normalize(content), true
+		case se.Table != nil && se.StartIndex <= idx && idx < se.EndIndex:
+			for _, row := range se.Table.TableRows {
+				for _, cell := range row.TableCells {
+					if c, ok := insertInContent(cell.Content, idx, text); ok {
+						cell.Content = c
+						return content, true
+					}
+				}
+			}
+		}
+	}
+	return content, false
+}
+
+func deleteContentRange(doc *docs.Document, req *docs.DeleteContentRangeRequest) error {
+	r := req.Range
+	if r == nil || r.StartIndex >= r.EndIndex {
+		return errors.New("delete content range: invalid range")
+	}
+	content, set, err := segmentContent(doc, r.SegmentId)
+	if err != nil {
+		return err
+	}
+	set(deleteInContent(content, r.StartIndex, r.EndIndex))
+	return nil
+}
+
+func deleteInContent(content []*docs.StructuralElement, start, end int64) []*docs.StructuralElement {
+	out := content[:0]
+	for _, se := range content {
+		if se.EndIndex <= start || se.StartIndex >= end {
+			out = append(out, se)
+			continue
+		}
+		switch {
+		case se.Paragraph != nil:
+			units := utf16.Encode([]rune(ParagraphText(se.Paragraph)))
+			from, to := start-se.StartIndex, end-se.StartIndex
+			if from < 0 {
+				from = 0
+			}
+			if to > int64(len(units)) {
+				to = int64(len(units))
+			}
+			s := string(utf16.Decode(units[:from])) + string(utf16.Decode(units[to:]))
+			setParagraphText(se.Paragraph, s)
+			out = append(out, se)
+		case se.Table != nil:
+			if start <= se.StartIndex && se.EndIndex <= end {
+				// the whole table is deleted
+				continue
+			}
+			for _, row := range se.Table.TableRows {
+				for _, cell := range row.TableCells {
+					cell.Content = deleteInContent(cell.Content, start, end)
+				}
+			}
+			out = append(out, se)
+		default:
+			out = append(out, se)
+		}
+	}
+	return normalize(out)
+}
+
+// normalize merges paragraphs that lost their trailing newline with the next
+// paragraph and splits paragraphs at inner newlines, so that every paragraph
+// ends with exactly one newline.
+func normalize(content []*docs.StructuralElement) []*docs.StructuralElement {
+	var out []*docs.StructuralElement
+	var pending *docs.StructuralElement
+	var pendingText string
+	flush := func() {
+		if pending == nil {
+			return
+		}
+		lines := strings.SplitAfter(pendingText, "\n")
+		for i, line := range lines {
+			if line == "" {
+				continue
+			}
+			p := pending
+			if i > 0 {
+				p = &docs.StructuralElement{Paragraph: &docs.Paragraph{ParagraphStyle: pending.Paragraph.ParagraphStyle}}
+			}
+			setParagraphText(p.Paragraph, line)
+			out = append(out, p)
+		}
+		pending, pendingText = nil, ""
+	}
+	for _, se := range content {
+		if se.Paragraph == nil {
+			flush()
+			out = append(out, se)
+			continue
+		}
+		if pending == nil {
+			pending = se
+		}
+		pendingText += ParagraphText(se.Paragraph)
+		if strings.HasSuffix(pendingText, "\n") {
+			flush()
+		}
+	}
+	if pending != nil {
+		if !strings.HasSuffix(pendingText, "\n") {
+			pendingText += "\n"
+		}
+		flush()
+	}
+	return out
+}
+
+func insertTableRow(doc *docs.Document, req *docs.InsertTableRowRequest) error {
+	loc := req.TableCellLocation
+	if loc == nil || loc.TableStartLocation == nil {
+		return errors.New("insert table row: table cell location is required")
+	}
+	content, _, err := segmentContent(doc, loc.TableStartLocation.SegmentId)
+	if err != nil {
+		return err
+	}
+	table := findTable(content, loc.TableStartLocation.Index)
+	if table == nil {
+		return fmt.Errorf("insert table row: no table starts at %d", loc.TableStartLocation.Index)
+	}
+	if loc.RowIndex < 0 || loc.RowIndex >= int64(len(table.TableRows)) {
+		return fmt.Errorf("insert table row: row %d out of range", loc.RowIndex)
+	}
+
+	ref := table.TableRows[loc.RowIndex]
+	row := &docs.TableRow{TableRowStyle: ref.TableRowStyle}
+	for _, cell := range ref.TableCells {
+		row.TableCells = append(row.TableCells, &docs.TableCell{
+			TableCellStyle: cell.TableCellStyle,
+			Content:        []*docs.StructuralElement{newParagraph("")},
+		})
+	}
+	at := loc.RowIndex
+	if req.InsertBelow {
+		at++
+	}
+	table.TableRows = append(table.TableRows[:at], append([]*docs.TableRow{row}, table.TableRows[at:]...)...)
+	table.Rows++
+	return nil
+}
+
+func findTable(content []*docs.StructuralElement, start int64) *docs.Table {
+	for _, se := range content {
+		if se.Table == nil {
+			continue
+		}
+		if se.StartIndex == start {
+			return se.Table
+		}
+		for _, row := range se.Table.TableRows {
+			for _, cell := range row.TableCells {
+				if t := findTable(cell.Content, start); t != nil {
+					return t
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renumber recomputes the start and end indexes of every element the way
+// Google Docs counts them, in UTF-16 code units.
+func renumber(doc *docs.Document) {
+	if doc.Body != nil {
+		renumberContent(doc.Body.Content, 1)
+	}
+	for _, h := range doc.Headers {
+		renumberContent(h.Content, 0)
+	}
+	for _, f := range doc.Footers {
+		renumberContent(f.Content, 0)
+	}
+	for _, f := range doc.Footnotes {
+		renumberContent(f.Content, 0)
+	}
+}
+
+func renumberContent(content []*docs.StructuralElement, idx int64) int64 {
+	for _, se := range content {
+		se.StartIndex = idx
+		switch {
+		case se.Paragraph != nil:
+			for _, el := range se.Paragraph.Elements {
+				el.StartIndex = idx
+				if el.TextRun != nil {
+					idx += int64(len(utf16.Encode([]rune(el.TextRun.Content))))
+				}
+				el.EndIndex = idx
+			}
+		case se.Table != nil:
+			idx++
+			for _, row := range se.Table.TableRows {
+				row.StartIndex = idx
+				idx++
+				for _, cell := range row.TableCells {
+					cell.StartIndex = idx
+					idx = renumberContent(cell.Content, idx+1)
+					cell.EndIndex = idx
+				}
+				row.EndIndex = idx
+			}
+			idx++
+		}
+		se.EndIndex = idx
+	}
+	return idx
+}
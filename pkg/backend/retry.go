@@ -0,0 +1,267 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Names of the Google APIs throttled by a Transport.
+const (
+	APIDrive  = "drive"
+	APIDocs   = "docs"
+	APISheets = "sheets"
+)
+
+// DefaultRateLimits are the requests per minute sent to each Google API. They
+// stay below the default per-user quotas of the Docs and Sheets write
+// requests.
+var DefaultRateLimits = map[string]float64{
+	APIDrive:  600,
+	APIDocs:   60,
+	APISheets: 60,
+}
+
+// RetryPolicy selects how often and how long a Transport retries a failed
+// request.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent, including the
+	// first.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles with every
+	// retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy follows the exponential backoff recommended for the
+// Google APIs.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     32 * time.Second,
+}
+
+// backoff returns the jittered wait before retry n, counted from 0.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 0; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// wait between half and the full backoff, so that concurrent requests
+	// do not retry in lockstep
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Transport is an http.RoundTripper for the Google API clients. It limits the
+// rate of requests sent to each API and retries requests that failed because
+// of rate limits, and idempotent requests that failed with server errors.
+type Transport struct {
+	// Base sends the requests. Defaults to http.DefaultTransport.
+	Base   http.RoundTripper
+	Policy RetryPolicy
+	// Limiters throttle the requests to the API of the same name.
+	Limiters map[string]*rate.Limiter
+}
+
+// NewTransport returns a Transport sending at most perMinute requests per
+// minute to each API. APIs without a positive limit are not throttled.
+func NewTransport(base http.RoundTripper, policy RetryPolicy, perMinute map[string]float64) *Transport {
+	limiters := map[string]*rate.Limiter{}
+	for api, n := range perMinute {
+		if n > 0 {
+			// allow a burst of a few requests, so a single quote is not slowed
+			// down
+			limiters[api] = rate.NewLimiter(rate.Limit(n/60), 10)
+		}
+	}
+	return &Transport{
+		Base:     base,
+		Policy:   policy,
+		Limiters: limiters,
+	}
+}
+
+// WrapClient returns a copy of client sending its requests through a
+// Transport.
+func WrapClient(client *http.Client, policy RetryPolicy, perMinute map[string]float64) *http.Client {
+	c := *client
+	c.Transport = NewTransport(client.Transport, policy, perMinute)
+	return &c
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	limiter := t.Limiters[apiName(req.URL)]
+	// a request body can only be sent again if it can be recreated
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(ctx)
+			r.Body = body
+		}
+
+		resp, err := t.base().RoundTrip(r)
+		last := attempt+1 >= t.Policy.MaxAttempts || !replayable
+		if last || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		wait := t.Policy.backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = d
+				if t.Policy.MaxBackoff > 0 && wait > t.Policy.MaxBackoff {
+					wait = t.Policy.MaxBackoff
+				}
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// the retry could not be sent in time, so return this failure
+			// rather than the deadline error
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// apiName returns the name of the Google API a request is sent to.
+func apiName(u *url.URL) string {
+	switch {
+	case strings.HasPrefix(u.Host, "docs."):
+		return APIDocs
+	case strings.HasPrefix(u.Host, "sheets."):
+		return APISheets
+	case strings.HasPrefix(u.Host, "drive."),
+		strings.HasPrefix(u.Path, "/drive/"),
+		strings.HasPrefix(u.Path, "/upload/drive/"):
+		return APIDrive
+	}
+	return ""
+}
+
+// retryable reports whether a request failed with a temporary error. Rate
+// limit responses are retried for every request, as the server rejects them
+// before applying them. Server and network errors are only retried for
+// idempotent requests, as the server may have applied the request before it
+// failed or the connection broke.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if req.Context().Err() != nil || !idempotent(req.Method) {
+			return false
+		}
+		var ne net.Error
+		return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return rateLimited(resp)
+	case http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return idempotent(req.Method)
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// rateLimited reports whether a 403 response is Drive's way of reporting an
+// exceeded rate limit. The body is restored for the caller.
+func rateLimited(resp *http.Response) bool {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	s := string(data)
+	return strings.Contains(s, `"rateLimitExceeded"`) || strings.Contains(s, `"userRateLimitExceeded"`)
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
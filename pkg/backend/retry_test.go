@@ -0,0 +1,321 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var testPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     4 * time.Millisecond,
+}
+
+// flakyServer fails the first fails requests with the given status, header
+// and body, and records the bodies of all requests.
+type flakyServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	fails  int
+	status int
+	header http.Header
+	body   string
+	bodies []string
+}
+
+func newFlakyServer(t *testing.T, fails, status int, body string) *flakyServer {
+	s := &flakyServer{fails: fails, status: status, header: http.Header{}, body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(data))
+		fail := len(s.bodies) <= s.fails
+		s.mu.Unlock()
+		if fail {
+			for k, v := range s.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(s.status)
+			_, _ = io.WriteString(w, s.body)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *flakyServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func TestTransportRetries(t *testing.T) {
+	const rateLimitBody = `{"error": {"errors": [{"reason": "rateLimitExceeded"}]}}`
+	tests := []struct {
+		name     string
+		method   string
+		status   int
+		body     string
+		attempts int
+		code     int
+	}{
+		{"429 GET", http.MethodGet, http.StatusTooManyRequests, "", 2, http.StatusOK},
+		{"429 POST", http.MethodPost, http.StatusTooManyRequests, "", 2, http.StatusOK},
+		{"429 PATCH", http.MethodPatch, http.StatusTooManyRequests, "", 2, http.StatusOK},
+		{"403 rate limit POST", http.MethodPost, http.StatusForbidden, rateLimitBody, 2, http.StatusOK},
+		{"403 forbidden GET", http.MethodGet, http.StatusForbidden, `{"error": {"errors": [{"reason": "forbidden"}]}}`, 1, http.StatusForbidden},
+		{"503 GET", http.MethodGet, http.StatusServiceUnavailable, "", 2, http.StatusOK},
+		{"500 PUT", http.MethodPut, http.StatusInternalServerError, "", 2, http.StatusOK},
+		{"503 POST", http.MethodPost, http.StatusServiceUnavailable, "", 1, http.StatusServiceUnavailable},
+		{"502 PATCH", http.MethodPatch, http.StatusBadGateway, "", 1, http.StatusBadGateway},
+		{"404 GET", http.MethodGet, http.StatusNotFound, "", 1, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFlakyServer(t, 1, tt.status, tt.body)
+			client := &http.Client{Transport: NewTransport(nil, testPolicy, nil)}
+
+			req, err := http.NewRequest(tt.method, srv.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.code {
+				t.Errorf("got %d, want %d", resp.StatusCode, tt.code)
+			}
+			if tt.code == http.StatusForbidden && string(body) != tt.body {
+				t.Errorf("403 body not restored: %q", body)
+			}
+			if got := srv.attempts(); got != tt.attempts {
+				t.Errorf("sent %d times, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestTransportMaxAttempts(t *testing.T) {
+	srv := newFlakyServer(t, 10, http.StatusTooManyRequests, "")
+	client := &http.Client{Transport: NewTransport(nil, testPolicy, nil)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if got := srv.attempts(); got != testPolicy.MaxAttempts {
+		t.Errorf("sent %d times, want %d", got, testPolicy.MaxAttempts)
+	}
+}
+
+func TestTransportReplaysBody(t *testing.T) {
+	srv := newFlakyServer(t, 2, http.StatusTooManyRequests, "")
+	client := &http.Client{Transport: NewTransport(nil, testPolicy, nil)}
+
+	// bytes.Reader bodies get a GetBody
+	req, err := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader([]byte(`{"requests": []}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	for i, b := range srv.bodies {
+		if b != `{"requests": []}` {
+			t.Errorf("attempt %d sent body %q", i+1, b)
+		}
+	}
+	if len(srv.bodies) != 3 {
+		t.Errorf("sent %d times, want 3", len(srv.bodies))
+	}
+}
+
+func TestTransportBodyWithoutGetBody(t *testing.T) {
+	srv := newFlakyServer(t, 1, http.StatusTooManyRequests, "")
+	client := &http.Client{Transport: NewTransport(nil, testPolicy, nil)}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, io.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || srv.attempts() != 1 {
+		t.Errorf("got %d after %d attempts, want one 429", resp.StatusCode, srv.attempts())
+	}
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	srv := newFlakyServer(t, 1, http.StatusTooManyRequests, "")
+	srv.header.Set("Retry-After", "3600")
+	policy := testPolicy
+	policy.MaxBackoff = 20 * time.Millisecond
+	client := &http.Client{Transport: NewTransport(nil, policy, nil)}
+
+	start := time.Now()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if d := time.Since(start); d < policy.MaxBackoff || d > 10*time.Second {
+		t.Errorf("waited %v, want about %v", d, policy.MaxBackoff)
+	}
+}
+
+func TestTransportRetryAfterDeadline(t *testing.T) {
+	srv := newFlakyServer(t, 1, http.StatusTooManyRequests, "")
+	srv.header.Set("Retry-After", "30")
+	policy := testPolicy
+	policy.MaxBackoff = time.Minute
+	client := &http.Client{Transport: NewTransport(nil, policy, nil)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("got %v, want the 429 response", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || srv.attempts() != 1 {
+		t.Errorf("got %d after %d attempts, want one 429", resp.StatusCode, srv.attempts())
+	}
+}
+
+func TestTransportLimiter(t *testing.T) {
+	srv := newFlakyServer(t, 0, 0, "")
+	tr := NewTransport(nil, testPolicy, nil)
+	const interval = 20 * time.Millisecond
+	tr.Limiters[APIDrive] = rate.NewLimiter(rate.Every(interval), 1)
+	client := &http.Client{Transport: tr}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		// the path selects the Drive limiter
+		resp, err := client.Get(srv.URL + "/drive/v3/files")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if d := time.Since(start); d < 3*interval {
+		t.Errorf("4 requests took %v, want at least %v", d, 3*interval)
+	}
+
+	// other APIs are not throttled by the Drive limiter
+	start = time.Now()
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(srv.URL + "/v1/documents")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if d := time.Since(start); d >= 3*interval {
+		t.Errorf("unthrottled requests took %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/drive/v3/files", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Do(req); err == nil {
+		t.Error("canceled limiter wait succeeded")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 8 * time.Second}
+	tests := []struct {
+		n   int
+		max time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{10, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(tt.n); d < tt.max/2 || d > tt.max {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", tt.n, d, tt.max/2, tt.max)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("zero policy backoff = %v, want 0", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		v  string
+		d  time.Duration
+		ok bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		d, ok := retryAfter(tt.v)
+		if d != tt.d || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.v, d, ok, tt.d, tt.ok)
+		}
+	}
+}
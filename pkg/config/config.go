@@ -0,0 +1,444 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/auth"
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/email"
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"sigs.k8s.io/yaml"
+)
+
+// EnvConfig is the environment variable holding the path to the config file.
+const EnvConfig = "QUOTE_GENERATOR_CONFIG"
+
+// Environment variables overriding the auth settings of the config file.
+const (
+	EnvAuth              = "QUOTE_GENERATOR_AUTH"
+	EnvServiceAccountKey = "QUOTE_GENERATOR_SERVICE_ACCOUNT_KEY"
+	EnvImpersonate       = "QUOTE_GENERATOR_IMPERSONATE"
+)
+
+// EnvSMTPPassword is the environment variable holding the SMTP password, so
+// that it need not be stored in the config file.
+const EnvSMTPPassword = "QUOTE_GENERATOR_SMTP_PASSWORD"
+
+// Config holds the settings of the quote generator. It is read from a YAML or
+// JSON file.
+type Config struct {
+	// ParentFolderID is the Google Drive folder under which a folder per email
+	// domain is created to hold the generated documents.
+	ParentFolderID string `json:"parentFolderID,omitempty"`
+	// Ledger selects where the quotation log is stored: LedgerSheets for the
+	// Google Spreadsheet or LedgerFile for a local file.
+	Ledger string `json:"ledger,omitempty"`
+	// SpreadsheetID is the Google Spreadsheet used to store the quotation log.
+	SpreadsheetID string `json:"spreadsheetID,omitempty"`
+	// LedgerFile is the JSON Lines file used to store the quotation log.
+	LedgerFile string `json:"ledgerFile,omitempty"`
+	// OutDir is the local directory where PDFs are written.
+	OutDir string `json:"outDir,omitempty"`
+	// JournalDir is the local directory recording the progress of quotes
+	// being generated, for quote-generator resume.
+	JournalDir string `json:"journalDir,omitempty"`
+	// Templates maps template names to Google Docs template ids. They are
+	// added to the built-in catalog, replacing built-in templates of the same
+	// name.
+	Templates map[string]string `json:"templates,omitempty"`
+	// Defaults are used when a quote request does not set them.
+	Defaults Defaults `json:"defaults,omitempty"`
+	// NumberScheme configures the format of quote numbers.
+	NumberScheme NumberScheme `json:"numberScheme,omitempty"`
+	// Catalog is the YAML or JSON file with the pricing catalog.
+	Catalog string `json:"catalog,omitempty"`
+	// Auth selects how to authenticate with the Google APIs.
+	Auth Auth `json:"auth,omitempty"`
+	// Sharing selects who generated quotes are shared with.
+	Sharing quote.Sharing `json:"sharing,omitempty"`
+	// SMTP is the server used to email quotes.
+	SMTP email.SMTP `json:"smtp,omitempty"`
+	// Email composes the emails sending quotes.
+	Email quote.EmailTemplate `json:"email,omitempty"`
+	// API configures retries and throttling of the Google API calls.
+	API API `json:"api,omitempty"`
+}
+
+type API struct {
+	// MaxAttempts is the number of times a Google API request is sent before
+	// giving up on rate limit and server errors.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// RateLimits are the requests per minute sent to the drive, docs and
+	// sheets APIs. Zero disables throttling of an API.
+	RateLimits map[string]float64 `json:"rateLimits,omitempty"`
+}
+
+type Auth struct {
+	// Method is auth.MethodOAuth, auth.MethodServiceAccount or auth.MethodADC.
+	// Defaults to service-account if ServiceAccountKey is set, else oauth.
+	Method string `json:"method,omitempty"`
+	// CredentialsFile is the OAuth client secrets file. Defaults to
+	// $XDG_CONFIG_HOME/quote-generator/credentials.json.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// TokenFile caches the OAuth token. Defaults to
+	// $XDG_CONFIG_HOME/quote-generator/token.json.
+	TokenFile string `json:"tokenFile,omitempty"`
+	// ServiceAccountKey is the JSON key file of a service account.
+	ServiceAccountKey string `json:"serviceAccountKey,omitempty"`
+	// Impersonate is the user a service account with domain-wide delegation
+	// acts as, e.g. sales@appscode.com.
+	Impersonate string `json:"impersonate,omitempty"`
+}
+
+type Defaults struct {
+	// Template is the template name or id used when none is given.
+	Template string `json:"template,omitempty"`
+	// Data holds default placeholder values.
+	Data map[string]string `json:"data,omitempty"`
+}
+
+type NumberScheme struct {
+	// Pattern is the quote number pattern, e.g. AC{YY}{MM}{SEQ:3}.
+	Pattern string `json:"pattern,omitempty"`
+	// FiscalYearStart is the first month (1-12) of the fiscal year.
+	FiscalYearStart int `json:"fiscalYearStart,omitempty"`
+	// Products maps template names to product codes for the {PRODUCT} token.
+	Products map[string]string `json:"products,omitempty"`
+}
+
+// Scheme compiles the quote number scheme.
+func (c *Config) Scheme() (*quote.NumberScheme, error) {
+	ns := c.NumberScheme
+	if ns.Pattern == "" {
+		ns.Pattern = quote.DefaultNumberPattern
+	}
+	return quote.NewNumberScheme(ns.Pattern, time.Month(ns.FiscalYearStart), ns.Products)
+}
+
+const (
+	LedgerSheets = "sheets"
+	LedgerFile   = "file"
+)
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		ParentFolderID: "1RBXgSR0jud5cpCqeC90fAdyb0Oaz7EIc",
+		Ledger:         LedgerSheets,
+		SpreadsheetID:  "1evwv2ON94R38M-Lkrw8b6dpVSkRYHUWsNOuI7X0_-zA",
+		LedgerFile:     filepath.Join(configDir(), "ledger.jsonl"),
+		OutDir:         filepath.Join("/personal", "AppsCode", "quotes"),
+		JournalDir:     filepath.Join(configDir(), "journal"),
+		API: API{
+			MaxAttempts: backend.DefaultRetryPolicy.MaxAttempts,
+			RateLimits: map[string]float64{
+				backend.APIDrive:  backend.DefaultRateLimits[backend.APIDrive],
+				backend.APIDocs:   backend.DefaultRateLimits[backend.APIDocs],
+				backend.APISheets: backend.DefaultRateLimits[backend.APISheets],
+			},
+		},
+		Templates: map[string]string{
+			"stash-on-demand":     "1zvnJ6PNWqesnh9-33kF47k2jN2WSPwxrlPPRojSO1Y0",
+			"stash-on-demand-100": "1U4GAovUia7K96PpBj0PWj4juTPaHMKB4nNK9LPyLgFk",
+			"stash-50":            "1EXMmcztXGb-EOrebHCrPrhFwQuRB0RpTl0UVeMtcMNk",
+			"stash-100":           "1Y2z7UZIIuvF3Twka6tXoovkbxyxXXz4qLnr9W43BIFs",
+			"kubedb-30":           "1n8zRoI5qjBaqa5hrogAey8OFd8-q7nCE9ysxwullb0g",
+			"kubedb-40":           "1s5751cd1SWZAy824njvTz2-iSC4V7NXRoFoCmZfoIcQ",
+			"kubedb-45":           "1VN3C_fDdUG_-zgFwvPkASVYzVmVr9E2Scv1Z2uqBRrY",
+			"kubedb-cluster-edu":  "18niPAUxB0OzsWTSln2OYuMqlXvHidozquqVwhtaFKYg",
+			"kubedb-cluster-gov":  "11cfcXar6p9cuHQhecOu0MW6f5zTYBbXjY2P0KpQCZq0",
+			"combined-cluster":    "14aeqhYnU88it0D8cXT65lfVefxmouaeu3buNnUppw-U",
+		},
+	}
+}
+
+// configDir returns $XDG_CONFIG_HOME/quote-generator, or an empty string if
+// the user config directory is unknown.
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "quote-generator")
+}
+
+// DefaultPath returns the config file used when neither a path nor EnvConfig
+// is given: $XDG_CONFIG_HOME/quote-generator/config.yaml.
+func DefaultPath() string {
+	if dir := configDir(); dir != "" {
+		return filepath.Join(dir, "config.yaml")
+	}
+	return ""
+}
+
+// Path returns the config file to load: path if set, else the EnvConfig
+// environment variable, else DefaultPath if that file exists. It returns an
+// empty string if there is no config file.
+func Path(path string) string {
+	if path != "" {
+		return path
+	}
+	if path = os.Getenv(EnvConfig); path != "" {
+		return path
+	}
+	if path = DefaultPath(); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Load reads the config file chosen by Path. Fields missing in the file fall
+// back to the built-in configuration.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	path = Path(path)
+	if path == "" {
+		cfg.applyEnv()
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %v", err)
+	}
+	var in Config
+	err = yaml.UnmarshalStrict(data, &in)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+	cfg.merge(&in)
+	cfg.applyEnv()
+	return cfg, nil
+}
+
+// applyEnv applies the auth and SMTP settings of the environment.
+func (c *Config) applyEnv() {
+	if v := os.Getenv(EnvAuth); v != "" {
+		c.Auth.Method = v
+	}
+	if v := os.Getenv(EnvServiceAccountKey); v != "" {
+		c.Auth.ServiceAccountKey = v
+	}
+	if v := os.Getenv(EnvImpersonate); v != "" {
+		c.Auth.Impersonate = v
+	}
+	if v := os.Getenv(EnvSMTPPassword); v != "" {
+		c.SMTP.Password = v
+	}
+}
+
+func (c *Config) merge(in *Config) {
+	if in.ParentFolderID != "" {
+		c.ParentFolderID = in.ParentFolderID
+	}
+	if in.Ledger != "" {
+		c.Ledger = in.Ledger
+	}
+	if in.SpreadsheetID != "" {
+		c.SpreadsheetID = in.SpreadsheetID
+	}
+	if in.LedgerFile != "" {
+		c.LedgerFile = in.LedgerFile
+	}
+	if in.OutDir != "" {
+		c.OutDir = in.OutDir
+	}
+	if in.JournalDir != "" {
+		c.JournalDir = in.JournalDir
+	}
+	if c.Templates == nil && len(in.Templates) > 0 {
+		c.Templates = map[string]string{}
+	}
+	for k, v := range in.Templates {
+		c.Templates[k] = v
+	}
+	if in.Defaults.Template != "" {
+		c.Defaults.Template = in.Defaults.Template
+	}
+	if len(in.Defaults.Data) > 0 {
+		c.Defaults.Data = in.Defaults.Data
+	}
+	c.NumberScheme = in.NumberScheme
+	if in.Catalog != "" {
+		c.Catalog = in.Catalog
+	}
+	c.Auth = in.Auth
+	c.Sharing = in.Sharing
+	c.SMTP = in.SMTP
+	c.Email = in.Email
+	if in.API.MaxAttempts != 0 {
+		c.API.MaxAttempts = in.API.MaxAttempts
+	}
+	for api, n := range in.API.RateLimits {
+		c.API.RateLimits[api] = n
+	}
+}
+
+// RetryPolicy returns the retry policy of the Google API calls.
+func (c *Config) RetryPolicy() backend.RetryPolicy {
+	p := backend.DefaultRetryPolicy
+	if c.API.MaxAttempts > 0 {
+		p.MaxAttempts = c.API.MaxAttempts
+	}
+	return p
+}
+
+// AuthOptions returns the options to authenticate with.
+func (c *Config) AuthOptions() auth.Options {
+	opts := auth.Options{
+		Method:            c.Auth.Method,
+		CredentialsFile:   c.Auth.CredentialsFile,
+		TokenFile:         c.Auth.TokenFile,
+		ServiceAccountKey: c.Auth.ServiceAccountKey,
+		Subject:           c.Auth.Impersonate,
+	}
+	if opts.CredentialsFile == "" {
+		opts.CredentialsFile = filepath.Join(configDir(), "credentials.json")
+	}
+	if opts.TokenFile == "" {
+		opts.TokenFile = filepath.Join(configDir(), "token.json")
+	}
+	return opts
+}
+
+// LoadCatalog reads the pricing catalog. It returns nil if no catalog is
+// configured.
+func (c *Config) LoadCatalog() (*quote.Catalog, error) {
+	if c.Catalog == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.Catalog)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read pricing catalog: %v", err)
+	}
+	var catalog quote.Catalog
+	err = yaml.UnmarshalStrict(data, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse pricing catalog %s: %v", c.Catalog, err)
+	}
+	if err = catalog.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid pricing catalog %s: %v", c.Catalog, err)
+	}
+	return &catalog, nil
+}
+
+var docIdRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{20,}$`)
+
+// Validate reports every problem found in the config.
+func (c *Config) Validate() error {
+	var errs []string
+	if c.ParentFolderID == "" {
+		errs = append(errs, "missing parent folder id")
+	} else if !docIdRegex.MatchString(c.ParentFolderID) {
+		errs = append(errs, fmt.Sprintf("invalid parent folder id %q", c.ParentFolderID))
+	}
+	switch c.Ledger {
+	case LedgerSheets:
+		if c.SpreadsheetID == "" {
+			errs = append(errs, "missing spreadsheet id")
+		} else if !docIdRegex.MatchString(c.SpreadsheetID) {
+			errs = append(errs, fmt.Sprintf("invalid spreadsheet id %q", c.SpreadsheetID))
+		}
+	case LedgerFile:
+		if c.LedgerFile == "" {
+			errs = append(errs, "missing ledger file")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("unknown ledger %q, must be %s or %s", c.Ledger, LedgerSheets, LedgerFile))
+	}
+	if c.OutDir == "" {
+		errs = append(errs, "missing output directory")
+	}
+	if c.JournalDir == "" {
+		errs = append(errs, "missing journal directory")
+	}
+
+	names := make([]string, 0, len(c.Templates))
+	for name := range c.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, "template with empty name")
+		}
+		if id := c.Templates[name]; !docIdRegex.MatchString(id) {
+			errs = append(errs, fmt.Sprintf("template %s has invalid doc id %q", name, id))
+		}
+	}
+	if t := c.Defaults.Template; t != "" {
+		if _, ok := c.Templates[t]; !ok && !docIdRegex.MatchString(t) {
+			errs = append(errs, fmt.Sprintf("default template %s is neither a known template nor a doc id", t))
+		}
+	}
+	if _, err := c.Scheme(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := c.LoadCatalog(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := c.AuthOptions().Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := c.Sharing.Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.SMTP.Host != "" {
+		if err := c.SMTP.Validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if err := c.Email.Validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if c.API.MaxAttempts < 0 {
+		errs = append(errs, fmt.Sprintf("invalid api max attempts %d", c.API.MaxAttempts))
+	}
+	apis := make([]string, 0, len(c.API.RateLimits))
+	for api := range c.API.RateLimits {
+		apis = append(apis, api)
+	}
+	sort.Strings(apis)
+	for _, api := range apis {
+		switch api {
+		case backend.APIDrive, backend.APIDocs, backend.APISheets:
+		default:
+			errs = append(errs, fmt.Sprintf("rate limit for unknown api %q, must be %s, %s or %s", api, backend.APIDrive, backend.APIDocs, backend.APISheets))
+			continue
+		}
+		if c.API.RateLimits[api] < 0 {
+			errs = append(errs, fmt.Sprintf("invalid rate limit %v for api %s", c.API.RateLimits[api], api))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
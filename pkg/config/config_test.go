@@ -0,0 +1,400 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appscodelabs/quote-generator/pkg/auth"
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+)
+
+const (
+	testDocID    = "1abcdefghijklmnopqrstuvwxyz0123456789"
+	testFolderID = "1ZYXWVUTSRQPONMLKJIHGFEDCBA9876543210"
+)
+
+// isolate keeps the tests from reading the user's config directory and
+// environment.
+func isolate(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	for _, env := range []string{EnvConfig, EnvAuth, EnvServiceAccountKey, EnvImpersonate, EnvSMTPPassword} {
+		t.Setenv(env, "")
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testYAML = `
+parentFolderID: ` + testFolderID + `
+ledger: file
+ledgerFile: /var/lib/quotes/ledger.jsonl
+templates:
+  kubedb-30: ` + testDocID + `
+  custom: ` + testDocID + `
+defaults:
+  template: custom
+  data:
+    company: AppsCode Inc.
+numberScheme:
+  pattern: "{PRODUCT}-{YYYY}-{SEQ:4}"
+  products:
+    custom: CU
+auth:
+  method: service-account
+  serviceAccountKey: /etc/quotes/key.json
+smtp:
+  host: smtp.example.com
+  username: sales
+email:
+  from: sales@appscode.com
+api:
+  maxAttempts: 3
+  rateLimits:
+    drive: 0
+`
+
+const testJSON = `{
+  "parentFolderID": "` + testFolderID + `",
+  "ledger": "file",
+  "ledgerFile": "/var/lib/quotes/ledger.jsonl",
+  "templates": {"kubedb-30": "` + testDocID + `", "custom": "` + testDocID + `"},
+  "defaults": {"template": "custom", "data": {"company": "AppsCode Inc."}},
+  "numberScheme": {"pattern": "{PRODUCT}-{YYYY}-{SEQ:4}", "products": {"custom": "CU"}},
+  "auth": {"method": "service-account", "serviceAccountKey": "/etc/quotes/key.json"},
+  "smtp": {"host": "smtp.example.com", "username": "sales"},
+  "email": {"from": "sales@appscode.com"},
+  "api": {"maxAttempts": 3, "rateLimits": {"drive": 0}}
+}`
+
+func TestLoad(t *testing.T) {
+	for name, data := range map[string]string{"config.yaml": testYAML, "config.json": testJSON} {
+		t.Run(name, func(t *testing.T) {
+			isolate(t)
+			cfg, err := Load(writeFile(t, name, data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			def := Default()
+
+			for field, got := range map[string][2]string{
+				"parentFolderID":    {cfg.ParentFolderID, testFolderID},
+				"ledger":            {cfg.Ledger, LedgerFile},
+				"ledgerFile":        {cfg.LedgerFile, "/var/lib/quotes/ledger.jsonl"},
+				"spreadsheetID":     {cfg.SpreadsheetID, def.SpreadsheetID},
+				"outDir":            {cfg.OutDir, def.OutDir},
+				"journalDir":        {cfg.JournalDir, def.JournalDir},
+				"defaults.template": {cfg.Defaults.Template, "custom"},
+				"defaults.company":  {cfg.Defaults.Data["company"], "AppsCode Inc."},
+				"pattern":           {cfg.NumberScheme.Pattern, "{PRODUCT}-{YYYY}-{SEQ:4}"},
+				"product":           {cfg.NumberScheme.Products["custom"], "CU"},
+				"auth.method":       {cfg.Auth.Method, auth.MethodServiceAccount},
+				"smtp.host":         {cfg.SMTP.Host, "smtp.example.com"},
+				"email.from":        {cfg.Email.From, "sales@appscode.com"},
+			} {
+				if got[0] != got[1] {
+					t.Errorf("%s = %q, want %q", field, got[0], got[1])
+				}
+			}
+
+			// the templates of the file are merged over the built-in ones
+			if len(cfg.Templates) != len(def.Templates)+1 {
+				t.Errorf("got %d templates, want %d", len(cfg.Templates), len(def.Templates)+1)
+			}
+			for name, id := range def.Templates {
+				want := id
+				if name == "kubedb-30" {
+					want = testDocID
+				}
+				if cfg.Templates[name] != want {
+					t.Errorf("template %s = %q, want %q", name, cfg.Templates[name], want)
+				}
+			}
+			if cfg.Templates["custom"] != testDocID {
+				t.Errorf("template custom = %q, want %q", cfg.Templates["custom"], testDocID)
+			}
+
+			if cfg.API.MaxAttempts != 3 {
+				t.Errorf("max attempts = %d, want 3", cfg.API.MaxAttempts)
+			}
+			for api, want := range map[string]float64{
+				backend.APIDrive:  0,
+				backend.APIDocs:   def.API.RateLimits[backend.APIDocs],
+				backend.APISheets: def.API.RateLimits[backend.APISheets],
+			} {
+				if got := cfg.API.RateLimits[api]; got != want {
+					t.Errorf("rate limit of %s = %v, want %v", api, got, want)
+				}
+			}
+			if err = cfg.Validate(); err != nil {
+				t.Errorf("loaded config is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadDoesNotChangeDefault(t *testing.T) {
+	isolate(t)
+	if _, err := Load(writeFile(t, "config.yaml", testYAML)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Default().Templates["custom"]; ok {
+		t.Error("loading a config changed the built-in templates")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	isolate(t)
+	for name, tt := range map[string]struct {
+		path string
+		err  string
+	}{
+		"missing":       {filepath.Join(t.TempDir(), "missing.yaml"), "unable to read config file"},
+		"unknown field": {writeFile(t, "config.yaml", "parentFolder: x\n"), `unknown field "parentFolder"`},
+		"wrong type":    {writeFile(t, "config.json", `{"templates": ["kubedb"]}`), "unable to parse config file"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Load(tt.path); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadPath(t *testing.T) {
+	dir := isolate(t)
+
+	// without a config file, the built-in config is used
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Ledger != LedgerSheets || cfg.LedgerFile != filepath.Join(dir, "quote-generator", "ledger.jsonl") {
+		t.Errorf("got ledger %s at %s, want the built-in config", cfg.Ledger, cfg.LedgerFile)
+	}
+
+	def := filepath.Join(dir, "quote-generator", "config.yaml")
+	if err = os.MkdirAll(filepath.Dir(def), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(def, []byte("outDir: /from/default\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = Load(""); err != nil || cfg.OutDir != "/from/default" {
+		t.Errorf("default config file not loaded: %v", err)
+	}
+
+	t.Setenv(EnvConfig, writeFile(t, "env.yaml", "outDir: /from/env\n"))
+	if cfg, err = Load(""); err != nil || cfg.OutDir != "/from/env" {
+		t.Errorf("config file of %s not loaded: %v", EnvConfig, err)
+	}
+
+	if cfg, err = Load(writeFile(t, "flag.yaml", "outDir: /from/flag\n")); err != nil || cfg.OutDir != "/from/flag" {
+		t.Errorf("config file given not loaded: %v", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	isolate(t)
+	path := writeFile(t, "config.yaml", strings.Replace(testYAML, "  username: sales\n", "  username: sales\n  password: from-file\n", 1))
+
+	t.Setenv(EnvAuth, auth.MethodADC)
+	t.Setenv(EnvServiceAccountKey, "/env/key.json")
+	t.Setenv(EnvImpersonate, "sales@appscode.com")
+	t.Setenv(EnvSMTPPassword, "from-env")
+	for _, p := range []string{path, ""} {
+		cfg, err := Load(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for field, got := range map[string][2]string{
+			"auth.method":            {cfg.Auth.Method, auth.MethodADC},
+			"auth.serviceAccountKey": {cfg.Auth.ServiceAccountKey, "/env/key.json"},
+			"auth.impersonate":       {cfg.Auth.Impersonate, "sales@appscode.com"},
+			"smtp.password":          {cfg.SMTP.Password, "from-env"},
+		} {
+			if got[0] != got[1] {
+				t.Errorf("%s of config %q = %q, want %q", field, p, got[0], got[1])
+			}
+		}
+	}
+
+	// empty variables do not override the file
+	t.Setenv(EnvAuth, "")
+	t.Setenv(EnvSMTPPassword, "")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.Method != auth.MethodServiceAccount || cfg.SMTP.Password != "from-file" {
+		t.Errorf("got auth %s and password %q from the file", cfg.Auth.Method, cfg.SMTP.Password)
+	}
+}
+
+func TestDefaultValid(t *testing.T) {
+	isolate(t)
+	if err := Default().Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	isolate(t)
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		errs   []string
+	}{
+		{
+			name:   "folder",
+			modify: func(c *Config) { c.ParentFolderID = "" },
+			errs:   []string{"missing parent folder id"},
+		},
+		{
+			name:   "ids",
+			modify: func(c *Config) { c.ParentFolderID, c.SpreadsheetID = "short", "not/an/id" },
+			errs:   []string{`invalid parent folder id "short"`, `invalid spreadsheet id "not/an/id"`},
+		},
+		{
+			name:   "ledger",
+			modify: func(c *Config) { c.Ledger = "sql" },
+			errs:   []string{`unknown ledger "sql", must be sheets or file`},
+		},
+		{
+			name:   "ledger file",
+			modify: func(c *Config) { c.Ledger, c.LedgerFile = LedgerFile, "" },
+			errs:   []string{"missing ledger file"},
+		},
+		{
+			name:   "directories",
+			modify: func(c *Config) { c.OutDir, c.JournalDir = "", "" },
+			errs:   []string{"missing output directory", "missing journal directory"},
+		},
+		{
+			name: "templates",
+			modify: func(c *Config) {
+				c.Templates = map[string]string{" ": testDocID, "kubedb": "short"}
+				c.Defaults.Template = "stash"
+			},
+			errs: []string{
+				"template with empty name",
+				`template kubedb has invalid doc id "short"`,
+				"default template stash is neither a known template nor a doc id",
+			},
+		},
+		{
+			name:   "default template id",
+			modify: func(c *Config) { c.Defaults.Template = testDocID },
+		},
+		{
+			name:   "number scheme",
+			modify: func(c *Config) { c.NumberScheme.Pattern = "AC{YY}" },
+			errs:   []string{"{SEQ}"},
+		},
+		{
+			name:   "catalog",
+			modify: func(c *Config) { c.Catalog = filepath.Join(os.TempDir(), "missing-catalog.yaml") },
+			errs:   []string{"unable to read pricing catalog"},
+		},
+		{
+			name:   "auth",
+			modify: func(c *Config) { c.Auth.Method = auth.MethodServiceAccount },
+			errs:   []string{"missing service account key"},
+		},
+		{
+			name:   "sharing",
+			modify: func(c *Config) { c.Sharing = quote.Sharing{Customer: backend.RoleWriter} },
+			errs:   []string{`invalid customer role "writer"`},
+		},
+		{
+			name: "email",
+			modify: func(c *Config) {
+				c.SMTP.Host, c.SMTP.TLS = "smtp.example.com", "ssl"
+			},
+			errs: []string{`unknown SMTP tls mode "ssl"`, "missing email sender"},
+		},
+		{
+			name: "api",
+			modify: func(c *Config) {
+				c.API.MaxAttempts = -1
+				c.API.RateLimits = map[string]float64{backend.APIDocs: -1, "gmail": 10}
+			},
+			errs: []string{
+				"invalid api max attempts -1",
+				"invalid rate limit -1 for api docs",
+				`rate limit for unknown api "gmail", must be drive, docs or sheets`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q misses %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCatalog(t *testing.T) {
+	cfg := &Config{}
+	if catalog, err := cfg.LoadCatalog(); catalog != nil || err != nil {
+		t.Errorf("got catalog %v and error %v without a catalog file", catalog, err)
+	}
+
+	cfg.Catalog = writeFile(t, "catalog.yaml", "currency: USD\nproducts:\n- sku: stash\n  name: Stash\n  prices:\n    annual: 100\n")
+	catalog, err := cfg.LoadCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := catalog.Product("stash"); !ok || p.Prices[quote.TermAnnual] != 100 || catalog.Currency != "USD" {
+		t.Errorf("unexpected catalog %+v", catalog)
+	}
+
+	cfg.Catalog = writeFile(t, "catalog.yaml", "products:\n- sku: stash\n  name: Stash\n")
+	if _, err = cfg.LoadCatalog(); err == nil || !strings.Contains(err.Error(), "product stash: missing prices") {
+		t.Errorf("got error %v, want missing prices", err)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain text body and attachments.
+type Message struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	ReplyTo string   `json:"replyTo,omitempty"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+	// Date defaults to the time the message is composed.
+	Date        time.Time    `json:"-"`
+	Attachments []Attachment `json:"-"`
+}
+
+// Attachment is a file attached to a Message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Recipients returns the envelope recipients of the message: To, Cc and Bcc.
+func (m *Message) Recipients() ([]string, error) {
+	var rcpts []string
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		addrs, err := parseAddresses(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			rcpts = append(rcpts, a.Address)
+		}
+	}
+	if len(rcpts) == 0 {
+		return nil, errors.New("message has no recipients")
+	}
+	return rcpts, nil
+}
+
+func parseAddresses(list []string) ([]*mail.Address, error) {
+	addrs := make([]*mail.Address, 0, len(list))
+	for _, s := range list {
+		a, err := mail.ParseAddress(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email address %q: %v", s, err)
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+func formatAddresses(addrs []*mail.Address) string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+// Bytes composes the message as multipart MIME. Bcc recipients are left out
+// of the headers.
+func (m *Message) Bytes() ([]byte, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", m.From, err)
+	}
+	to, err := parseAddresses(m.To)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := parseAddresses(m.Cc)
+	if err != nil {
+		return nil, err
+	}
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	id, err := messageID(from.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	header := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	header("From", from.String())
+	header("To", formatAddresses(to))
+	header("Cc", formatAddresses(cc))
+	if m.ReplyTo != "" {
+		replyTo, err := mail.ParseAddress(m.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply-to address %q: %v", m.ReplyTo, err)
+		}
+		header("Reply-To", replyTo.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", id)
+	header("MIME-Version", "1.0")
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err = qp.Write([]byte(crlf(m.Body))); err != nil {
+		return nil, err
+	}
+	if err = qp.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, a := range m.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": a.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeBase64(part, a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err = mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// lineBreaks normalizes the line breaks of a body to LF.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// crlf returns text with CRLF line breaks, as mail requires, whichever line
+// breaks it had.
+func crlf(text string) string {
+	return strings.ReplaceAll(lineBreaks.Replace(text), "\n", "\r\n")
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) error {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 0 {
+		n := 76
+		if n > len(enc) {
+			n = len(enc)
+		}
+		if _, err := w.Write([]byte(enc[:n] + "\r\n")); err != nil {
+			return err
+		}
+		enc = enc[n:]
+	}
+	return nil
+}
+
+func messageID(from string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndexByte(from, '@'); i >= 0 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain), nil
+}
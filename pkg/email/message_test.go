@@ -0,0 +1,215 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMessage() *Message {
+	return &Message{
+		From:    "Jörg Müller <sales@appscode.com>",
+		To:      []string{"Jane Doe <jane@example.com>", "john@example.com"},
+		Cc:      []string{"boss@example.com"},
+		Bcc:     []string{"archive@appscode.com"},
+		ReplyTo: "rep@appscode.com",
+		Subject: "Angebot für Ihr Team – Q1",
+		Body:    "Dear Jane,\r\n\r\nyour quote is attached.\nRegards\r",
+		Date:    time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC),
+		Attachments: []Attachment{
+			{Filename: "example.com QUOTE #AC2410001.pdf", ContentType: "application/pdf", Data: bytes.Repeat([]byte("%PDF-1.7 "), 20)},
+			{Filename: "Preisliste für 2025.csv", Data: []byte("sku,price\nstash,100\n")},
+		},
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := testMessage()
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range strings.SplitAfter(string(data), "\n") {
+		if line != "" && !strings.HasSuffix(line, "\r\n") || strings.HasSuffix(line, "\r\r\n") {
+			t.Errorf("line %d %q does not end in a single CRLF", i+1, line)
+		}
+		if len(line) > 1000 {
+			t.Errorf("line %d is %d characters long", i+1, len(line))
+		}
+	}
+	if bytes.Contains(data, []byte("archive@appscode.com")) {
+		t.Errorf("Bcc recipient leaked into the message:\n%s", data)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := new(mime.WordDecoder)
+	for k, want := range map[string]string{
+		"From":         "Jörg Müller <sales@appscode.com>",
+		"To":           `"Jane Doe" <jane@example.com>, <john@example.com>`,
+		"Cc":           "<boss@example.com>",
+		"Reply-To":     "<rep@appscode.com>",
+		"Subject":      "Angebot für Ihr Team – Q1",
+		"Date":         "Mon, 07 Oct 2024 09:30:00 +0000",
+		"Mime-Version": "1.0",
+		"Bcc":          "",
+	} {
+		raw := m.Header.Get(k)
+		got, err := dec.DecodeHeader(raw)
+		if err != nil {
+			t.Errorf("%s: %v", k, err)
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+		for _, c := range raw {
+			if c > 127 {
+				t.Errorf("%s header %q is not encoded", k, raw)
+				break
+			}
+		}
+	}
+	if id := m.Header.Get("Message-Id"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@appscode.com>") {
+		t.Errorf("Message-ID %q", id)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %s: %v", mediaType, err)
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+
+	// the reader decodes the quoted-printable body
+	body, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := body.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("body content type %q", ct)
+	}
+	text, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Dear Jane,\r\n\r\nyour quote is attached.\r\nRegards\r\n"; string(text) != want {
+		t.Errorf("body = %q, want %q", text, want)
+	}
+
+	for _, want := range msg.Attachments {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FileName() != want.Filename {
+			t.Errorf("attachment %q, want %q", part.FileName(), want.Filename)
+		}
+		ct, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		wantType := want.ContentType
+		if wantType == "" {
+			wantType = "application/octet-stream"
+		}
+		if err != nil || ct != wantType || params["name"] != want.Filename {
+			t.Errorf("attachment %s has content type %q: %v", want.Filename, part.Header.Get("Content-Type"), err)
+		}
+		if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "base64" {
+			t.Errorf("attachment %s is encoded as %q", want.Filename, enc)
+		}
+		raw, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			if len(line) > 76 {
+				t.Errorf("base64 line of %s is %d characters long", want.Filename, len(line))
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want.Data) {
+			t.Errorf("attachment %s = %q, want %q", want.Filename, data, want.Data)
+		}
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Errorf("got %v after the attachments, want EOF", err)
+	}
+}
+
+func TestMessageBytesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(m *Message)
+		err    string
+	}{
+		{"sender", func(m *Message) { m.From = "sales" }, `invalid sender "sales"`},
+		{"to", func(m *Message) { m.To = []string{"jane@example.com", "john"} }, `invalid email address "john"`},
+		{"cc", func(m *Message) { m.Cc = []string{"boss@"} }, `invalid email address "boss@"`},
+		{"reply-to", func(m *Message) { m.ReplyTo = "rep" }, `invalid reply-to address "rep"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := testMessage()
+			tt.modify(msg)
+			if _, err := msg.Bytes(); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	rcpts, err := testMessage().Recipients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "jane@example.com john@example.com boss@example.com archive@appscode.com"
+	if got := strings.Join(rcpts, " "); got != want {
+		t.Errorf("recipients %s, want %s", got, want)
+	}
+	if _, err = (&Message{From: "sales@appscode.com"}).Recipients(); err == nil {
+		t.Error("message without recipients accepted")
+	}
+	if _, err = (&Message{Bcc: []string{"archive"}}).Recipients(); err == nil || !strings.Contains(err.Error(), `invalid email address "archive"`) {
+		t.Errorf("got error %v for an invalid Bcc recipient", err)
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	for text, want := range map[string]string{
+		"":                "",
+		"one line":        "one line",
+		"a\nb\n":          "a\r\nb\r\n",
+		"a\r\nb\r\n":      "a\r\nb\r\n",
+		"a\rb\r":          "a\r\nb\r\n",
+		"a\r\n\nb\r\r\nc": "a\r\n\r\nb\r\n\r\nc",
+	} {
+		if got := crlf(text); got != want {
+			t.Errorf("crlf(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
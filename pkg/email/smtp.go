@@ -0,0 +1,170 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// Connection security of an SMTP server.
+const (
+	// TLSStartTLS upgrades a plain connection with STARTTLS and fails if the
+	// server does not offer it.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS, usually on port 465.
+	TLSImplicit = "tls"
+	// TLSNone sends in plain text. Only meant for local SMTP sinks in tests.
+	TLSNone = "none"
+)
+
+// sendTimeout bounds sending a message if the context has no deadline.
+const sendTimeout = 2 * time.Minute
+
+// SMTP sends messages through an SMTP server.
+type SMTP struct {
+	Host string `json:"host"`
+	// Port defaults to 587 for TLSStartTLS, 465 for TLSImplicit and 25 for
+	// TLSNone.
+	Port int `json:"port,omitempty"`
+	// TLS is TLSStartTLS, TLSImplicit or TLSNone. Defaults to TLSStartTLS.
+	TLS      string `json:"tls,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// rootCAs verifies the server certificate instead of the system roots.
+	rootCAs *x509.CertPool
+}
+
+func (s *SMTP) tlsMode() string {
+	if s.TLS == "" {
+		return TLSStartTLS
+	}
+	return s.TLS
+}
+
+func (s *SMTP) port() int {
+	if s.Port != 0 {
+		return s.Port
+	}
+	switch s.tlsMode() {
+	case TLSImplicit:
+		return 465
+	case TLSNone:
+		return 25
+	}
+	return 587
+}
+
+// Validate checks that the server is set.
+func (s *SMTP) Validate() error {
+	if s.Host == "" {
+		return errors.New("missing SMTP host")
+	}
+	switch s.tlsMode() {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return fmt.Errorf("unknown SMTP tls mode %q, must be %s, %s or %s", s.TLS, TLSStartTLS, TLSImplicit, TLSNone)
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("invalid SMTP port %d", s.Port)
+	}
+	return nil
+}
+
+// Send delivers msg to its recipients.
+func (s *SMTP) Send(ctx context.Context, msg *Message) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %v", msg.From, err)
+	}
+	rcpts, err := msg.Recipients()
+	if err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.port()))
+	tlsConfig := &tls.Config{ServerName: s.Host, RootCAs: s.rootCAs}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to connect to SMTP server %s: %v", addr, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sendTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	if s.tlsMode() == TLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("unable to connect to SMTP server %s: %v", addr, err)
+	}
+	defer c.Close()
+
+	if s.tlsMode() == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("unable to start TLS with %s: %v", addr, err)
+		}
+	}
+	if s.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("unable to authenticate with %s: %v", addr, err)
+		}
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range rcpts {
+		if err = c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
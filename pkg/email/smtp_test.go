@@ -0,0 +1,350 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool
+// trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtp sink"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// received is a message delivered to an smtpSink.
+type received struct {
+	from string
+	rcpt []string
+	data string
+	// tls and auth record whether the message was sent over TLS and the
+	// credentials given.
+	tls  bool
+	auth string
+}
+
+// smtpSink is an SMTP server accepting every message. It offers STARTTLS if
+// startTLS is set and speaks TLS from the start if implicit is set.
+type smtpSink struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+	startTLS  bool
+	reject    string
+
+	mu   sync.Mutex
+	msgs []received
+}
+
+func newSMTPSink(t *testing.T, mode string) (*smtpSink, *SMTP) {
+	t.Helper()
+	cert, pool := testCertificate(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{
+		ln:        ln,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		startTLS:  mode == TLSStartTLS,
+	}
+	if mode == TLSImplicit {
+		s.ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return s, &SMTP{Host: addr.IP.String(), Port: addr.Port, TLS: mode, rootCAs: pool}
+}
+
+func (s *smtpSink) messages() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.msgs...)
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	_, secure := conn.(*tls.Conn)
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for i, line := range lines {
+			sep := " "
+			if i < len(lines)-1 {
+				sep = "-"
+			}
+			_, _ = io.WriteString(conn, line[:3]+sep+line[4:]+"\r\n")
+		}
+	}
+	reply("220 sink ready")
+	var msg received
+	var auth string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fields := strings.SplitN(line, " ", 2)
+		switch strings.ToUpper(fields[0]) {
+		case "EHLO":
+			lines := []string{"250 sink"}
+			if s.startTLS && !secure {
+				lines = append(lines, "250 STARTTLS")
+			}
+			if secure {
+				lines = append(lines, "250 AUTH PLAIN")
+			}
+			reply(lines...)
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err = tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure, r = tlsConn, true, bufio.NewReader(tlsConn)
+		case "AUTH":
+			args := strings.Fields(fields[1])
+			if len(args) != 2 || args[0] != "PLAIN" {
+				reply("504 unsupported")
+				continue
+			}
+			creds, err := base64.StdEncoding.DecodeString(args[1])
+			if err != nil {
+				reply("501 malformed")
+				continue
+			}
+			auth = string(creds)
+			reply("235 authenticated")
+		case "MAIL":
+			msg = received{from: strings.Trim(strings.TrimPrefix(fields[1], "FROM:"), "<>"), tls: secure, auth: auth}
+			reply("250 ok")
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(fields[1], "TO:"), "<>")
+			if rcpt == s.reject {
+				reply("550 no such user")
+				continue
+			}
+			msg.rcpt = append(msg.rcpt, rcpt)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.String()
+			s.mu.Lock()
+			s.msgs = append(s.msgs, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	for _, mode := range []string{TLSStartTLS, TLSImplicit, TLSNone} {
+		t.Run(mode, func(t *testing.T) {
+			sink, s := newSMTPSink(t, mode)
+			if mode != TLSNone {
+				s.Username, s.Password = "sales", "secret"
+			}
+			if mode == TLSStartTLS {
+				// STARTTLS is the default
+				s.TLS = ""
+			}
+			if err := s.Send(context.Background(), testMessage()); err != nil {
+				t.Fatal(err)
+			}
+
+			msgs := sink.messages()
+			if len(msgs) != 1 {
+				t.Fatalf("sink received %d messages, want 1", len(msgs))
+			}
+			got := msgs[0]
+			if got.from != "sales@appscode.com" {
+				t.Errorf("MAIL FROM %s, want sales@appscode.com", got.from)
+			}
+			if want := "jane@example.com john@example.com boss@example.com archive@appscode.com"; strings.Join(got.rcpt, " ") != want {
+				t.Errorf("RCPT TO %v, want %s", got.rcpt, want)
+			}
+			if got.tls != (mode != TLSNone) {
+				t.Errorf("sent over TLS: %v", got.tls)
+			}
+			if want := map[bool]string{true: "\x00sales\x00secret", false: ""}[mode != TLSNone]; got.auth != want {
+				t.Errorf("authenticated with %q, want %q", got.auth, want)
+			}
+			if !strings.Contains(got.data, "Subject: =?utf-8?q?Angebot_f=C3=BCr") || strings.Contains(got.data, "archive@appscode.com") {
+				t.Errorf("unexpected message:\n%s", got.data)
+			}
+		})
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		modify func(sink *smtpSink, s *SMTP)
+		err    string
+	}{
+		{
+			name:   "no STARTTLS",
+			mode:   TLSNone,
+			modify: func(_ *smtpSink, s *SMTP) { s.TLS = TLSStartTLS },
+			err:    "does not support STARTTLS",
+		},
+		{
+			name:   "untrusted STARTTLS certificate",
+			mode:   TLSStartTLS,
+			modify: func(_ *smtpSink, s *SMTP) { s.rootCAs = nil },
+			err:    "unable to start TLS",
+		},
+		{
+			name:   "untrusted certificate",
+			mode:   TLSImplicit,
+			modify: func(_ *smtpSink, s *SMTP) { s.rootCAs = nil },
+			err:    "unable to connect to SMTP server",
+		},
+		{
+			name:   "rejected recipient",
+			mode:   TLSStartTLS,
+			modify: func(sink *smtpSink, _ *SMTP) { sink.reject = "boss@example.com" },
+			err:    "recipient boss@example.com rejected",
+		},
+		{
+			name:   "unknown mode",
+			mode:   TLSNone,
+			modify: func(_ *smtpSink, s *SMTP) { s.TLS = "ssl" },
+			err:    `unknown SMTP tls mode "ssl"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, s := newSMTPSink(t, tt.mode)
+			tt.modify(sink, s)
+			err := s.Send(context.Background(), testMessage())
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if msgs := sink.messages(); len(msgs) != 0 {
+				t.Errorf("sink received %d messages", len(msgs))
+			}
+		})
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	// a server that never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &SMTP{Host: addr.IP.String(), Port: addr.Port, TLS: TLSNone}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = s.Send(ctx, testMessage()); err == nil {
+		t.Fatal("sent without a greeting")
+	}
+}
+
+func TestSMTPPort(t *testing.T) {
+	for _, tt := range []struct {
+		s    SMTP
+		want int
+	}{
+		{SMTP{}, 587},
+		{SMTP{TLS: TLSStartTLS}, 587},
+		{SMTP{TLS: TLSImplicit}, 465},
+		{SMTP{TLS: TLSNone}, 25},
+		{SMTP{TLS: TLSImplicit, Port: 2465}, 2465},
+	} {
+		if got := tt.s.port(); got != tt.want {
+			t.Errorf("port of %+v = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BatchTemplateKey is the column of a batch file selecting the template of a
+// row. All other columns are placeholder values.
+const BatchTemplateKey = "template"
+
+// BatchRow is a quote request read from a batch file.
+type BatchRow struct {
+	// Line is the line of the row in the batch file.
+	Line    int
+	Request Request
+}
+
+// ReadBatchCSV reads quote requests from CSV with a header row naming the
+// placeholder of each column. Empty and missing cells are left out of the
+// data, so that defaults apply to them. Lines starting with # are comments.
+func ReadBatchCSV(r io.Reader) ([]BatchRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("batch file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+	}
+
+	var rows []BatchRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		fields := map[string]string{}
+		for i, v := range record {
+			if i < len(header) && header[i] != "" {
+				fields[header[i]] = v
+			}
+		}
+		if row, ok := batchRow(line, fields); ok {
+			rows = append(rows, row)
+		}
+	}
+}
+
+// ReadBatchJSONL reads quote requests from JSON Lines, one object of
+// placeholder values per line. Lines starting with # are comments.
+func ReadBatchJSONL(r io.Reader) ([]BatchRow, error) {
+	var rows []BatchRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var fields Values
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if row, ok := batchRow(line, fields); ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows, scanner.Err()
+}
+
+// batchRow returns the request for the fields of a row, or false if all fields
+// are empty.
+func batchRow(line int, fields map[string]string) (BatchRow, bool) {
+	row := BatchRow{Line: line, Request: Request{Data: map[string]string{}}}
+	for k, v := range fields {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.EqualFold(k, BatchTemplateKey) {
+			row.Request.Template = v
+		} else {
+			row.Request.Data[k] = v
+		}
+	}
+	return row, row.Request.Template != "" || len(row.Request.Data) > 0
+}
+
+// BatchResult is the outcome of a row of a batch.
+type BatchResult struct {
+	Line   int
+	Email  string
+	Result *Result
+	Err    error
+}
+
+// GenerateBatch generates a quote for every row with at most workers quotes in
+// flight, and returns the results in the order of the rows. Rows for the same
+// email domain share the lookup of their Drive folder.
+func (g *Generator) GenerateBatch(ctx context.Context, rows []BatchRow, workers int) []BatchResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]BatchResult, len(rows))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				row := rows[i]
+				results[i] = BatchResult{Line: row.Line, Email: dataValue(row.Request.Data, "{{email}}")}
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Result, results[i].Err = g.Generate(ctx, row.Request)
+			}
+		}()
+	}
+	for i := range rows {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// dataValue returns the value of a placeholder in data, whose keys may be
+// given with or without braces and in any case.
+func dataValue(data map[string]string, key string) string {
+	for k, v := range data {
+		if Placeholder(k) == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// WriteBatchResults writes the results of a batch as CSV. Quotes that were
+// generated but could not be emailed keep their number and link next to the
+// error.
+func WriteBatchResults(w io.Writer, results []BatchResult) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"Line", "Email", ColQuote, "Doc ID", "Folder ID", "PDF", ColLink, "Error"})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		record := []string{strconv.Itoa(r.Line), r.Email, "", "", "", "", "", ""}
+		result := r.Result
+		var sendErr *SendError
+		if result == nil && errors.As(r.Err, &sendErr) {
+			result = sendErr.Result
+		}
+		if result != nil {
+			record[2], record[3], record[4], record[5], record[6] = result.Quote, result.DocID, result.FolderID, result.PDFPath, result.Link
+		}
+		if r.Err != nil {
+			record[7] = r.Err.Error()
+		}
+		if err = cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,247 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteBatchResultsSendError(t *testing.T) {
+	g, _ := newTestGenerator(t)
+	g.Mailer.(*testMailer).err = errFault
+
+	sent := testRequest()
+	sent.Send = &Delivery{}
+	invalid := testRequest()
+	invalid.Template = "voyager"
+	results := g.GenerateBatch(context.Background(), []BatchRow{
+		{Line: 2, Request: testRequest()},
+		{Line: 3, Request: sent},
+		{Line: 4, Request: invalid},
+	}, 2)
+
+	var buf bytes.Buffer
+	if err := WriteBatchResults(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4", len(records))
+	}
+	for i, r := range records[1:] {
+		quote, link, msg := r[2], r[6], r[7]
+		switch i {
+		case 0:
+			if quote == "" || link == "" || msg != "" {
+				t.Errorf("line %s: quote %q, link %q, error %q", r[0], quote, link, msg)
+			}
+		case 1:
+			if quote == "" || link == "" || msg == "" {
+				t.Errorf("line %s: send failure lost quote %q, link %q or error %q", r[0], quote, link, msg)
+			}
+		case 2:
+			if quote != "" || msg == "" {
+				t.Errorf("line %s: quote %q, error %q", r[0], quote, msg)
+			}
+		}
+	}
+}
+
+// batchRows formats rows as "line template data", with data sorted by key.
+func batchRows(rows []BatchRow) []string {
+	out := make([]string, 0, len(rows))
+	for _, row := range rows {
+		keys := make([]string, 0, len(row.Request.Data))
+		for k := range row.Request.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var data []string
+		for _, k := range keys {
+			data = append(data, k+"="+row.Request.Data[k])
+		}
+		out = append(out, fmt.Sprintf("%d %s %s", row.Line, row.Request.Template, strings.Join(data, ",")))
+	}
+	return out
+}
+
+func checkBatchRows(t *testing.T, rows []BatchRow, want []string) {
+	t.Helper()
+	got := batchRows(rows)
+	if len(got) != len(want) {
+		t.Fatalf("got rows\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadBatchCSV(t *testing.T) {
+	data := "\ufeff name , Email,Template,,company\n" +
+		"Jane Doe,jane@example.com,kubedb-45,ignored,Example Inc\n" +
+		"\n" +
+		"# John asked to be called next year\n" +
+		"John Roe, john@example.org ,,,\n" +
+		",,,,\n" +
+		"\"Roe, Ann\",ann@example.org,stash,,\"Example\n" +
+		"Org\"\n" +
+		"Max,max@example.com\n" +
+		"Eve,eve@example.com,voyager,,Example Co,extra\n"
+	rows, err := ReadBatchCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBatchRows(t, rows, []string{
+		"2 kubedb-45 Email=jane@example.com,company=Example Inc,name=Jane Doe",
+		"5  Email=john@example.org,name=John Roe",
+		"7 stash Email=ann@example.org,company=Example\nOrg,name=Roe, Ann",
+		"9  Email=max@example.com,name=Max",
+		"10 voyager Email=eve@example.com,company=Example Co,name=Eve",
+	})
+}
+
+func TestReadBatchCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"empty", "", "batch file is empty"},
+		{"comments only", "# leads\n", "batch file is empty"},
+		{"bare quote", "name,email\nJane,jane@example.com\nJohn \"JR\" Roe,john@example.org\n", "line 3"},
+		{"unterminated quote", "name,email\nJane,jane@example.com\n\nJohn,\"john@example.org\n", "line 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadBatchCSV(strings.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadBatchJSONL(t *testing.T) {
+	data := `{"name": "Jane Doe", "email": "jane@example.com", "TEMPLATE": "kubedb-45"}
+
+# John asked to be called next year
+  {"name": "John Roe", "email": "john@example.org", "seats": 5, "trial": false, "fax": null}
+{"name": " ", "template": ""}
+{"email": "ann@example.org"}
+`
+	rows, err := ReadBatchJSONL(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBatchRows(t, rows, []string{
+		"1 kubedb-45 email=jane@example.com,name=Jane Doe",
+		"4  email=john@example.org,name=John Roe,seats=5,trial=false",
+		"6  email=ann@example.org",
+	})
+}
+
+func TestReadBatchJSONLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"malformed", "{\"email\": \"jane@example.com\"}\n\n{\"email\": \"john@example.org\"\n", "line 3: "},
+		{"not an object", "[\"jane@example.com\"]\n", "line 1: "},
+		{"nested value", "{\"email\": \"jane@example.com\"}\n{\"address\": {\"city\": \"Berlin\"}}\n", "line 2: value of address must be a string, number or boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadBatchJSONL(strings.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestGenerateBatchOrder(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	var rows []BatchRow
+	for i := 0; i < 12; i++ {
+		req := testRequest()
+		req.Data = map[string]string{"name": fmt.Sprintf("Lead %d", i), "email": fmt.Sprintf("lead%d@example%d.com", i, i%3)}
+		if i == 5 {
+			req.Template = "voyager"
+		}
+		rows = append(rows, BatchRow{Line: i + 2, Request: req})
+	}
+	results := g.GenerateBatch(ctx, rows, 4)
+	if len(results) != len(rows) {
+		t.Fatalf("got %d results, want %d", len(results), len(rows))
+	}
+	quotes := map[string]bool{}
+	for i, r := range results {
+		if r.Line != rows[i].Line || r.Email != rows[i].Request.Data["email"] {
+			t.Errorf("result %d is for line %d and %s, want line %d and %s", i, r.Line, r.Email, rows[i].Line, rows[i].Request.Data["email"])
+		}
+		if i == 5 {
+			if r.Err == nil {
+				t.Errorf("line %d with an unknown template succeeded", r.Line)
+			}
+			continue
+		}
+		if r.Err != nil {
+			t.Errorf("line %d: %v", r.Line, r.Err)
+			continue
+		}
+		if quotes[r.Result.Quote] {
+			t.Errorf("quote %s issued twice", r.Result.Quote)
+		}
+		quotes[r.Result.Quote] = true
+		row, _, err := FindQuote(ctx, store, r.Result.Quote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Field(row, ColEmail) != r.Email {
+			t.Errorf("quote %s of line %d is for %s, want %s", r.Result.Quote, r.Line, Field(row, ColEmail), r.Email)
+		}
+	}
+}
+
+func TestGenerateBatchCanceled(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := g.GenerateBatch(ctx, []BatchRow{{Line: 2, Request: testRequest()}, {Line: 3, Request: testRequest()}}, 0)
+	for _, r := range results {
+		if !errors.Is(r.Err, context.Canceled) || r.Email != "jane@example.com" {
+			t.Errorf("line %d for %s: got error %v, want %v", r.Line, r.Email, r.Err, context.Canceled)
+		}
+	}
+	if rows, err := store.Rows(context.Background()); err != nil || len(rows) != 0 {
+		t.Errorf("logged %d quotes after canceling: %v", len(rows), err)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// calendarLineLimit is the length in octets after which lines of an iCalendar
+// file are folded.
+const calendarLineLimit = 75
+
+// WriteCalendar writes an iCalendar (RFC 5545) file with an all-day event on
+// the expiration date of every quote and an alarm the day before, so that
+// quotes show up in the calendar of the sales rep following up on them.
+func WriteCalendar(w io.Writer, quotes []ExpiringQuote, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+	stamp := now.UTC().Format("20060102T150405Z")
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//AppsCode//quote-generator//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	for _, q := range quotes {
+		customer := q.Company
+		if customer == "" {
+			customer = q.Email
+		}
+		desc := []string{fmt.Sprintf("%s <%s>", q.Name, q.Email), "Status: " + q.Status}
+		if q.Total != "" {
+			desc = append(desc, fmt.Sprintf("Total: %s %s", q.Currency, q.Total))
+		}
+		if q.SalesRep != "" {
+			desc = append(desc, "Sales rep: "+q.SalesRep)
+		}
+
+		line("BEGIN", "VEVENT")
+		line("UID", q.Quote+"-expiry@quote-generator.appscode.com")
+		line("DTSTAMP", stamp)
+		line("DTSTART;VALUE=DATE", q.Expiry.Format("20060102"))
+		line("DTEND;VALUE=DATE", q.Expiry.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY", escapeCalendarText(fmt.Sprintf("Quote %s for %s expires", q.Quote, customer)))
+		line("DESCRIPTION", escapeCalendarText(strings.Join(desc, "\n")))
+		if q.Link != "" {
+			line("URL", q.Link)
+		}
+		line("TRANSP", "TRANSPARENT")
+		line("BEGIN", "VALARM")
+		line("ACTION", "DISPLAY")
+		line("DESCRIPTION", escapeCalendarText("Follow up on quote "+q.Quote))
+		line("TRIGGER", "-P1D")
+		line("END", "VALARM")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// escapeCalendarText escapes a TEXT value of an iCalendar property.
+func escapeCalendarText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// writeFolded writes a content line, folding it after calendarLineLimit
+// octets without splitting UTF-8 sequences.
+func writeFolded(w *bufio.Writer, s string) {
+	limit := calendarLineLimit
+	for len(s) > limit {
+		i := limit
+		for i > 0 && s[i]&0xC0 == 0x80 {
+			i--
+		}
+		w.WriteString(s[:i])
+		w.WriteString("\r\n ")
+		s = s[i:]
+		// continuation lines start with a space
+		limit = calendarLineLimit - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
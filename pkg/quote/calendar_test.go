@@ -0,0 +1,205 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// calendarLines unfolds the content lines of an iCalendar file.
+func calendarLines(t *testing.T, data []byte) []string {
+	t.Helper()
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		t.Fatalf("calendar does not end in CRLF:\n%s", data)
+	}
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n") {
+		if len(l) > calendarLineLimit {
+			t.Errorf("line of %d octets: %q", len(l), l)
+		}
+		if !utf8.ValidString(l) {
+			t.Errorf("line splits a UTF-8 sequence: %q", l)
+		}
+		if strings.ContainsAny(l, "\r\n") {
+			t.Errorf("line holds a bare line break: %q", l)
+		}
+		if strings.HasPrefix(l, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// calendarEvents returns the properties of the events in lines, in order.
+func calendarEvents(lines []string) []map[string]string {
+	var events []map[string]string
+	var event map[string]string
+	alarm := false
+	for _, l := range lines {
+		name, value := l, ""
+		if i := strings.IndexByte(l, ':'); i >= 0 {
+			name, value = l[:i], l[i+1:]
+		}
+		switch {
+		case l == "BEGIN:VEVENT":
+			event = map[string]string{}
+		case l == "END:VEVENT":
+			events = append(events, event)
+			event = nil
+		case l == "BEGIN:VALARM":
+			alarm = true
+		case l == "END:VALARM":
+			alarm = false
+		case event != nil && alarm:
+			event["VALARM."+name] = value
+		case event != nil:
+			event[name] = value
+		}
+	}
+	return events
+}
+
+func TestWriteCalendar(t *testing.T) {
+	now := time.Date(2024, 12, 24, 9, 30, 15, 0, time.FixedZone("CET", 60*60))
+	quotes := []ExpiringQuote{
+		{
+			Quote:    "AC2412001",
+			Status:   StatusSent,
+			Name:     "Jane Doe",
+			Email:    "jane@example.com",
+			Company:  "Example, Inc; Müller & Söhne \\ Ärzte",
+			SalesRep: "rep@appscode.com",
+			Expiry:   time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC),
+			Currency: "EUR",
+			Total:    "12,117.44",
+			Link:     "https://docs.google.com/document/d/doc-1/edit",
+		},
+		{
+			Quote:  "AC2412002-R2",
+			Status: StatusDraft,
+			Name:   "John Roe",
+			Email:  "john@example.org",
+			Expiry: time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteCalendar(&buf, quotes, now); err != nil {
+		t.Fatal(err)
+	}
+	lines := calendarLines(t, buf.Bytes())
+	if lines[0] != "BEGIN:VCALENDAR" || lines[1] != "VERSION:2.0" || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("not a calendar:\n%s", strings.Join(lines, "\n"))
+	}
+
+	events := calendarEvents(lines)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2:\n%s", len(events), strings.Join(lines, "\n"))
+	}
+	want := []map[string]string{
+		{
+			"UID":                "AC2412001-expiry@quote-generator.appscode.com",
+			"DTSTAMP":            "20241224T083015Z",
+			"DTSTART;VALUE=DATE": "20241231",
+			"DTEND;VALUE=DATE":   "20250101",
+			"SUMMARY":            `Quote AC2412001 for Example\, Inc\; Müller & Söhne \\ Ärzte expires`,
+			"DESCRIPTION":        `Jane Doe <jane@example.com>\nStatus: sent\nTotal: EUR 12\,117.44\nSales rep: rep@appscode.com`,
+			"URL":                "https://docs.google.com/document/d/doc-1/edit",
+			"TRANSP":             "TRANSPARENT",
+			"VALARM.ACTION":      "DISPLAY",
+			"VALARM.DESCRIPTION": "Follow up on quote AC2412001",
+			"VALARM.TRIGGER":     "-P1D",
+		},
+		{
+			"UID":                "AC2412002-R2-expiry@quote-generator.appscode.com",
+			"DTSTAMP":            "20241224T083015Z",
+			"DTSTART;VALUE=DATE": "20250228",
+			"DTEND;VALUE=DATE":   "20250301",
+			"SUMMARY":            "Quote AC2412002-R2 for john@example.org expires",
+			"DESCRIPTION":        `John Roe <john@example.org>\nStatus: draft`,
+			"TRANSP":             "TRANSPARENT",
+			"VALARM.ACTION":      "DISPLAY",
+			"VALARM.DESCRIPTION": "Follow up on quote AC2412002-R2",
+			"VALARM.TRIGGER":     "-P1D",
+		},
+	}
+	for i := range want {
+		for k, v := range want[i] {
+			if events[i][k] != v {
+				t.Errorf("event %d: %s = %q, want %q", i, k, events[i][k], v)
+			}
+		}
+		for k := range events[i] {
+			if _, ok := want[i][k]; !ok {
+				t.Errorf("event %d: unexpected %s:%s", i, k, events[i][k])
+			}
+		}
+	}
+}
+
+func TestWriteCalendarEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCalendar(&buf, nil, time.Date(2024, 11, 1, 9, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	lines := calendarLines(t, buf.Bytes())
+	if len(calendarEvents(lines)) != 0 || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("got calendar:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestEscapeCalendarText(t *testing.T) {
+	tests := map[string]string{
+		"plain":                   "plain",
+		"a, b; c":                 `a\, b\; c`,
+		`C:\quotes`:               `C:\\quotes`,
+		"one\ntwo\r\nthree\rfour": `one\ntwo\nthree\nfour`,
+		`\n`:                      `\\n`,
+	}
+	for in, want := range tests {
+		if got := escapeCalendarText(in); got != want {
+			t.Errorf("escapeCalendarText(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	for _, s := range []string{
+		"",
+		strings.Repeat("a", calendarLineLimit),
+		strings.Repeat("a", calendarLineLimit+1),
+		strings.Repeat("ä", 100),
+		"DESCRIPTION:" + strings.Repeat("a", 62) + strings.Repeat("€", 40),
+	} {
+		var buf bytes.Buffer
+		bw := bufio.NewWriter(&buf)
+		writeFolded(bw, s)
+		if err := bw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		lines := calendarLines(t, buf.Bytes())
+		if len(lines) != 1 || lines[0] != s {
+			t.Errorf("unfolded %q to %q", s, lines)
+		}
+	}
+}
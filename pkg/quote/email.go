@@ -0,0 +1,249 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/appscodelabs/quote-generator/pkg/email"
+)
+
+// Mailer sends email, e.g. an *email.SMTP.
+type Mailer interface {
+	Send(ctx context.Context, msg *email.Message) error
+}
+
+// Delivery selects who a generated quote is emailed to.
+type Delivery struct {
+	// To defaults to the customer's email.
+	To  []string `json:"to,omitempty"`
+	Cc  []string `json:"cc,omitempty"`
+	Bcc []string `json:"bcc,omitempty"`
+	// ReplyTo is usually the sales rep handling the quote.
+	ReplyTo string `json:"replyTo,omitempty"`
+}
+
+// Default subject and body of the email sending a quote.
+const (
+	DefaultEmailSubject = `Quote {{.quote}}`
+	DefaultEmailBody    = `Dear {{.name}},
+
+Please find attached our quote {{.quote}}{{with .link}}, also available at
+{{.}}{{end}}.
+
+Best regards
+`
+)
+
+// EmailTemplate composes the email sending a quote. Subject and Body are Go
+// templates executed with the placeholder values, keyed by name without
+// braces, e.g. {{.name}}, {{.quote}} or {{index . "expiry-date"}}, and the
+// link to the document as {{.link}}.
+type EmailTemplate struct {
+	From string `json:"from,omitempty"`
+	// ReplyTo, Cc and Bcc apply to every email, unless a Delivery sets its own
+	// ReplyTo. Cc and Bcc are added to those of the Delivery.
+	ReplyTo string   `json:"replyTo,omitempty"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	// Subject defaults to DefaultEmailSubject.
+	Subject string `json:"subject,omitempty"`
+	// Body defaults to DefaultEmailBody.
+	Body string `json:"body,omitempty"`
+}
+
+func (t EmailTemplate) templates() (*template.Template, *template.Template, error) {
+	subject, body := t.Subject, t.Body
+	if subject == "" {
+		subject = DefaultEmailSubject
+	}
+	if body == "" {
+		body = DefaultEmailBody
+	}
+	st, err := template.New("subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid email subject: %v", err)
+	}
+	bt, err := template.New("body").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid email body: %v", err)
+	}
+	return st, bt, nil
+}
+
+// Validate checks the addresses and templates.
+func (t EmailTemplate) Validate() error {
+	var errs []string
+	if t.From == "" {
+		errs = append(errs, "missing email sender")
+	} else if _, err := mail.ParseAddress(t.From); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid email sender %q: %v", t.From, err))
+	}
+	if t.ReplyTo != "" {
+		if _, err := mail.ParseAddress(t.ReplyTo); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid reply-to address %q: %v", t.ReplyTo, err))
+		}
+	}
+	for _, a := range append(append([]string(nil), t.Cc...), t.Bcc...) {
+		if _, err := mail.ParseAddress(a); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid email address %q: %v", a, err))
+		}
+	}
+	if _, _, err := t.templates(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// check composes the email of a run before its quote number is allocated, so
+// that templates using data the quote does not have fail before the number is
+// spent.
+func (t EmailTemplate) check(r *Run, d *Delivery) error {
+	trial := *r
+	trial.Replacements = make(map[string]string, len(r.Replacements)+1)
+	for k, v := range r.Replacements {
+		trial.Replacements[k] = v
+	}
+	trial.Replacements["{{quote}}"] = ""
+	_, err := t.compose(&trial, d)
+	return err
+}
+
+// compose returns the email sending the quote of a run, without attachment.
+func (t EmailTemplate) compose(r *Run, d *Delivery) (*email.Message, error) {
+	st, bt, err := t.templates()
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{"link": r.Link}
+	for k, v := range r.Replacements {
+		data[strings.TrimSuffix(strings.TrimPrefix(k, "{{"), "}}")] = v
+	}
+	var subject, body bytes.Buffer
+	if err = st.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("unable to compose email subject: %v", err)
+	}
+	if err = bt.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("unable to compose email body: %v", err)
+	}
+
+	msg := &email.Message{
+		From:    t.From,
+		To:      d.To,
+		Cc:      append(append([]string(nil), t.Cc...), d.Cc...),
+		Bcc:     append(append([]string(nil), t.Bcc...), d.Bcc...),
+		ReplyTo: d.ReplyTo,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+	}
+	if len(msg.To) == 0 && r.Email != "" {
+		msg.To = []string{r.Email}
+	}
+	if msg.ReplyTo == "" {
+		msg.ReplyTo = t.ReplyTo
+	}
+	if len(msg.To) == 0 {
+		return nil, errors.New("no recipient for the quote email")
+	}
+	return msg, nil
+}
+
+// checkDelivery validates the email settings before a quote number is spent
+// on a quote to send.
+func (g *Generator) checkDelivery(d *Delivery) error {
+	if d == nil {
+		return nil
+	}
+	if g.Mailer == nil {
+		return errors.New("sending quotes needs an SMTP server")
+	}
+	if err := g.Email.Validate(); err != nil {
+		return err
+	}
+	for _, a := range append(append(append([]string(nil), d.To...), d.Cc...), d.Bcc...) {
+		if _, err := mail.ParseAddress(a); err != nil {
+			return invalidRequest(fmt.Errorf("invalid email address %q: %v", a, err))
+		}
+	}
+	if d.ReplyTo != "" {
+		if _, err := mail.ParseAddress(d.ReplyTo); err != nil {
+			return invalidRequest(fmt.Errorf("invalid reply-to address %q: %v", d.ReplyTo, err))
+		}
+	}
+	return nil
+}
+
+// send emails the PDF of a run.
+func (g *Generator) send(ctx context.Context, r *Run) error {
+	if g.Mailer == nil {
+		return errors.New("sending quotes needs an SMTP server")
+	}
+	msg, err := g.Email.compose(r, r.Send)
+	if err != nil {
+		return err
+	}
+	pdf, err := os.ReadFile(r.PDFPath)
+	if err != nil {
+		return err
+	}
+	msg.Attachments = []email.Attachment{{
+		Filename:    filepath.Base(r.PDFPath),
+		ContentType: "application/pdf",
+		Data:        pdf,
+	}}
+	if err = g.Mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("unable to send quote %s: %v", r.Quote, err)
+	}
+	r.SentTo = append(append(append([]string(nil), msg.To...), msg.Cc...), msg.Bcc...)
+	// the email is out, so failing to record it must not send it again
+	note := "emailed to " + strings.Join(msg.To, ", ")
+	if err = SetStatus(ctx, g.Ledger, r.Quote, StatusSent, note, g.now()); err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("unable to mark quote %s sent: %v", r.Quote, err))
+	}
+	return nil
+}
+
+// SendError is returned when a quote was generated but could not be emailed.
+// The quote is not rolled back; its run stays in the journal, so that
+// sending can be retried with Generator.Resume.
+type SendError struct {
+	Result *Result
+	RunID  string
+	Err    error
+}
+
+func (e *SendError) Error() string {
+	if e.RunID == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v; quote %s was generated, retry sending with resume %s", e.Err, e.Result.Quote, e.RunID)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,229 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appscodelabs/quote-generator/pkg/email"
+)
+
+// smtpSink is a local SMTP server that accepts every message.
+type smtpSink struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	msgs []sinkMessage
+}
+
+type sinkMessage struct {
+	from string
+	rcpt []string
+	data string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) mailer() *email.SMTP {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return &email.SMTP{Host: addr.IP.String(), Port: addr.Port, TLS: email.TLSNone}
+}
+
+func (s *smtpSink) messages() []sinkMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sinkMessage(nil), s.msgs...)
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = io.WriteString(conn, line+"\r\n")
+	}
+	reply("220 sink ready")
+	var msg sinkMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 sink")
+		case "MAIL":
+			msg = sinkMessage{from: strings.Trim(strings.TrimPrefix(line[5:], "FROM:"), "<>")}
+			reply("250 ok")
+		case "RCPT":
+			msg.rcpt = append(msg.rcpt, strings.Trim(strings.TrimPrefix(line[5:], "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.String()
+			s.mu.Lock()
+			s.msgs = append(s.msgs, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestGenerateSendSMTP(t *testing.T) {
+	g, store := newTestGenerator(t)
+	sink := newSMTPSink(t)
+	g.Mailer = sink.mailer()
+	g.Email = EmailTemplate{
+		From:    "sales@appscode.com",
+		Bcc:     []string{"archive@appscode.com"},
+		Subject: "Your quote {{.quote}}",
+		Body:    "Dear {{.name}},\n\nyour quote is valid until {{index . \"expiry-date\"}}.\n",
+	}
+	ctx := context.Background()
+
+	req := testRequest()
+	req.Send = &Delivery{Cc: []string{"boss@example.com"}, ReplyTo: "rep@appscode.com"}
+	result, err := g.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := sink.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sink received %d messages, want 1", len(msgs))
+	}
+	got := msgs[0]
+	if got.from != "sales@appscode.com" {
+		t.Errorf("MAIL FROM %s, want sales@appscode.com", got.from)
+	}
+	if want := []string{"jane@example.com", "archive@appscode.com", "boss@example.com"}; !sameSet(got.rcpt, want) {
+		t.Errorf("RCPT TO %v, want %v", got.rcpt, want)
+	}
+	m, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := m.Header.Get("Subject"); s != "Your quote "+result.Quote {
+		t.Errorf("subject %q", s)
+	}
+	if s := m.Header.Get("Reply-To"); !strings.Contains(s, "rep@appscode.com") {
+		t.Errorf("reply-to %q", s)
+	}
+	if s := m.Header.Get("Bcc"); s != "" {
+		t.Errorf("Bcc header leaked: %q", s)
+	}
+	if !strings.Contains(got.data, "example.com QUOTE #"+result.Quote+".pdf") {
+		t.Errorf("PDF not attached:\n%s", got.data)
+	}
+
+	row, _, err := FindQuote(ctx, store, result.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Status(row) != StatusSent {
+		t.Errorf("quote is %s, want %s", Status(row), StatusSent)
+	}
+}
+
+func TestGenerateSendTemplateError(t *testing.T) {
+	g, store := newTestGenerator(t)
+	sink := newSMTPSink(t)
+	g.Mailer = sink.mailer()
+	g.Email = EmailTemplate{
+		From: "sales@appscode.com",
+		Body: "Dear {{.nickname}},\n",
+	}
+	ctx := context.Background()
+
+	req := testRequest()
+	req.Send = &Delivery{}
+	_, err := g.Generate(ctx, req)
+	if err == nil || !strings.Contains(err.Error(), "nickname") {
+		t.Fatalf("got error %v, want a missing nickname", err)
+	}
+	rows, err := store.Rows(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("spent %d quote numbers", len(rows))
+	}
+	if msgs := sink.messages(); len(msgs) != 0 {
+		t.Errorf("sent %d messages", len(msgs))
+	}
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]int{}
+	for _, s := range a {
+		seen[s]++
+	}
+	for _, s := range b {
+		if seen[s] == 0 {
+			return false
+		}
+		seen[s]--
+	}
+	return true
+}
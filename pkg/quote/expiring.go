@@ -0,0 +1,164 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/email"
+)
+
+// ExpiringQuote is an open quote of the quotation log about to expire.
+type ExpiringQuote struct {
+	Quote    string `json:"quote"`
+	Status   string `json:"status"`
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email"`
+	Company  string `json:"company,omitempty"`
+	SalesRep string `json:"salesRep,omitempty"`
+	// Expiry is the expiration date, at midnight UTC.
+	Expiry   time.Time `json:"expiry"`
+	Currency string    `json:"currency,omitempty"`
+	Total    string    `json:"total,omitempty"`
+	Link     string    `json:"link,omitempty"`
+}
+
+// Domain returns the folder name of the customer's email: the email domain,
+// or the address itself for public email providers.
+func (q ExpiringQuote) Domain() string {
+	return FolderName(q.Email)
+}
+
+// DaysLeft returns the number of days from the day of now to the expiration
+// date. A quote expiring today has 0 days left.
+func (q ExpiringQuote) DaysLeft(now time.Time) int {
+	today, err := time.Parse(DateLayout, now.Format(DateLayout))
+	if err != nil {
+		return 0
+	}
+	return int(q.Expiry.Sub(today).Hours() / 24)
+}
+
+// ExpiringQuotes returns the open quotes expiring from the day of now until
+// within after now, soonest first. Superseded revisions are left out, as the
+// customer is expected to decide on the latest one.
+func ExpiringQuotes(ctx context.Context, ledger backend.QuoteLedger, now time.Time, within time.Duration) ([]ExpiringQuote, error) {
+	rows, err := ledger.Rows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	today, err := time.Parse(DateLayout, now.Format(DateLayout))
+	if err != nil {
+		return nil, err
+	}
+	until, err := time.Parse(DateLayout, now.Add(within).Format(DateLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	var quotes []ExpiringQuote
+	for _, row := range rows {
+		quote := Field(row, ColQuote)
+		if _, pending := parsePendingMarker(quote); pending || quote == "" {
+			continue
+		}
+		if !OpenStatus(Status(row)) || Field(row, ColSupersededBy) != "" {
+			continue
+		}
+		expiry, err := time.Parse(DateLayout, Field(row, ColExpirationDate))
+		if err != nil || expiry.Before(today) || expiry.After(until) {
+			continue
+		}
+		quotes = append(quotes, ExpiringQuote{
+			Quote:    quote,
+			Status:   Status(row),
+			Name:     Field(row, ColName),
+			Email:    Field(row, ColEmail),
+			Company:  Field(row, ColCompany),
+			SalesRep: Field(row, ColSalesRep),
+			Expiry:   expiry,
+			Currency: Field(row, ColCurrency),
+			Total:    Field(row, ColTotal),
+			Link:     Field(row, ColLink),
+		})
+	}
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].Expiry.Before(quotes[j].Expiry)
+	})
+	return quotes, nil
+}
+
+// Reminder returns the email reminding a sales rep to follow up on quotes
+// before they expire, with the expiration dates attached as calendar.
+func Reminder(from, to string, quotes []ExpiringQuote, now time.Time) (*email.Message, error) {
+	var body strings.Builder
+	fmt.Fprintf(&body, "The following %s soon. Please follow up with the customers.\n\n", plural(len(quotes), "quote expires", "quotes expire"))
+	for _, q := range quotes {
+		fmt.Fprintf(&body, "%s  expires %s (%s)\n", q.Quote, q.Expiry.Format(DateLayout), daysLeft(q.DaysLeft(now)))
+		customer := q.Name
+		if q.Company != "" {
+			customer += ", " + q.Company
+		}
+		fmt.Fprintf(&body, "  %s <%s>\n", strings.TrimPrefix(customer, ", "), q.Email)
+		if q.Total != "" {
+			fmt.Fprintf(&body, "  total %s %s\n", q.Currency, q.Total)
+		}
+		fmt.Fprintf(&body, "  status %s\n", q.Status)
+		if q.Link != "" {
+			fmt.Fprintf(&body, "  %s\n", q.Link)
+		}
+		body.WriteString("\n")
+	}
+
+	var cal strings.Builder
+	if err := WriteCalendar(&cal, quotes, now); err != nil {
+		return nil, err
+	}
+	return &email.Message{
+		From:    from,
+		To:      []string{to},
+		Subject: fmt.Sprintf("%d %s expiring soon", len(quotes), plural(len(quotes), "quote", "quotes")),
+		Body:    body.String(),
+		Attachments: []email.Attachment{{
+			Filename:    "expiring-quotes.ics",
+			ContentType: "text/calendar; charset=utf-8",
+			Data:        []byte(cal.String()),
+		}},
+	}, nil
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+func daysLeft(n int) string {
+	switch n {
+	case 0:
+		return "today"
+	case 1:
+		return "tomorrow"
+	}
+	return fmt.Sprintf("in %d days", n)
+}
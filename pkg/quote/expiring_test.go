@@ -0,0 +1,167 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+// expiringRow returns a quotation log row of an open quote expiring on expiry.
+func expiringRow(quote, expiry string) []string {
+	row := statusRow(quote, StatusSent, expiry)
+	row = SetField(row, ColName, "Jane Doe")
+	row = SetField(row, ColEmail, "jane@"+strings.ToLower(quote)+".com")
+	return row
+}
+
+func TestExpiringQuotes(t *testing.T) {
+	ctx := context.Background()
+	store := backend.NewMemory()
+	// late in the evening of Nov 1 in New York, Nov 2 in UTC
+	now := time.Date(2024, 11, 1, 22, 0, 0, 0, time.FixedZone("EDT", -4*60*60))
+
+	full := expiringRow("AC2410003", "Nov 5, 2024")
+	full = SetField(full, ColCompany, "Example Inc")
+	full = SetField(full, ColSalesRep, "rep@appscode.com")
+	full = SetField(full, ColCurrency, "USD")
+	full = SetField(full, ColTotal, "1,800.00")
+	full = SetField(full, ColLink, "https://docs.google.com/document/d/doc-3/edit")
+	rows := [][]string{
+		expiringRow("AC2410001", "Nov 8, 2024"),
+		expiringRow("AC2410002", "Nov 1, 2024"),
+		full,
+		expiringRow("AC2410004", "Oct 31, 2024"),
+		expiringRow("AC2410005", "Nov 9, 2024"),
+		SetField(expiringRow("AC2410006", "Nov 5, 2024"), ColStatus, StatusAccepted),
+		SetField(expiringRow("AC2410007", "Nov 5, 2024"), ColStatus, StatusVoid),
+		SetField(expiringRow("AC2410008", "Nov 5, 2024"), ColSupersededBy, "AC2410008-R2"),
+		SetField(expiringRow("AC2410008-R2", "Nov 5, 2024"), ColStatus, ""),
+		expiringRow(pendingQuote+"@2024-10-07T09:30:00Z#0a1b2c3d", "Nov 5, 2024"),
+		expiringRow("AC2410009", "2024-11-05"),
+		expiringRow("AC2410010", ""),
+	}
+	for _, row := range rows {
+		if _, err := store.Append(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quotes, err := ExpiringQuotes(ctx, store, now, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, q := range quotes {
+		got = append(got, q.Quote+" "+q.Expiry.Format(DateLayout))
+	}
+	want := []string{"AC2410002 Nov 1, 2024", "AC2410003 Nov 5, 2024", "AC2410008-R2 Nov 5, 2024", "AC2410001 Nov 8, 2024"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got expiring quotes\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	q := quotes[1]
+	wantQuote := ExpiringQuote{
+		Quote:    "AC2410003",
+		Status:   StatusSent,
+		Name:     "Jane Doe",
+		Email:    "jane@ac2410003.com",
+		Company:  "Example Inc",
+		SalesRep: "rep@appscode.com",
+		Expiry:   time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC),
+		Currency: "USD",
+		Total:    "1,800.00",
+		Link:     "https://docs.google.com/document/d/doc-3/edit",
+	}
+	if q != wantQuote {
+		t.Errorf("got %+v, want %+v", q, wantQuote)
+	}
+	if quotes[2].Status != StatusDraft {
+		t.Errorf("quote without status has status %q, want %q", quotes[2].Status, StatusDraft)
+	}
+	for i, want := range []int{0, 4, 4, 7} {
+		if got := quotes[i].DaysLeft(now); got != want {
+			t.Errorf("%s has %d days left, want %d", quotes[i].Quote, got, want)
+		}
+	}
+
+	// a zero window lists the quotes expiring today
+	quotes, err = ExpiringQuotes(ctx, store, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotes) != 1 || quotes[0].Quote != "AC2410002" {
+		t.Errorf("got %+v expiring today, want AC2410002", quotes)
+	}
+}
+
+func TestReminder(t *testing.T) {
+	now := time.Date(2024, 11, 1, 9, 0, 0, 0, time.UTC)
+	quotes := []ExpiringQuote{
+		{Quote: "AC2410002", Status: StatusSent, Name: "Jane Doe", Email: "jane@example.com", Company: "Example Inc", Expiry: time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), Currency: "USD", Total: "1,800.00", Link: "https://docs.google.com/document/d/doc-2/edit"},
+		{Quote: "AC2410003", Status: StatusViewed, Email: "john@example.org", Company: "Example Org", Expiry: time.Date(2024, 11, 2, 0, 0, 0, 0, time.UTC)},
+		{Quote: "AC2410001", Status: StatusDraft, Name: "Ann Roe", Email: "ann@example.net", Expiry: time.Date(2024, 11, 8, 0, 0, 0, 0, time.UTC)},
+	}
+	msg, err := Reminder("sales@appscode.com", "rep@appscode.com", quotes, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.From != "sales@appscode.com" || len(msg.To) != 1 || msg.To[0] != "rep@appscode.com" {
+		t.Errorf("got message from %s to %v", msg.From, msg.To)
+	}
+	if msg.Subject != "3 quotes expiring soon" {
+		t.Errorf("got subject %q", msg.Subject)
+	}
+	wantBody := `The following quotes expire soon. Please follow up with the customers.
+
+AC2410002  expires Nov 1, 2024 (today)
+  Jane Doe, Example Inc <jane@example.com>
+  total USD 1,800.00
+  status sent
+  https://docs.google.com/document/d/doc-2/edit
+
+AC2410003  expires Nov 2, 2024 (tomorrow)
+  Example Org <john@example.org>
+  status viewed
+
+AC2410001  expires Nov 8, 2024 (in 7 days)
+  Ann Roe <ann@example.net>
+  status draft
+
+`
+	if msg.Body != wantBody {
+		t.Errorf("got body\n%s\nwant\n%s", msg.Body, wantBody)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Filename != "expiring-quotes.ics" || !strings.HasPrefix(msg.Attachments[0].ContentType, "text/calendar") {
+		t.Fatalf("got attachments %+v", msg.Attachments)
+	}
+	if cal := string(msg.Attachments[0].Data); strings.Count(cal, "BEGIN:VEVENT\r\n") != 3 {
+		t.Errorf("calendar does not hold 3 events:\n%s", cal)
+	}
+
+	msg, err = Reminder("sales@appscode.com", "rep@appscode.com", quotes[:1], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "1 quote expiring soon" || !strings.HasPrefix(msg.Body, "The following quote expires soon.") {
+		t.Errorf("got subject %q and body\n%s", msg.Subject, msg.Body)
+	}
+}
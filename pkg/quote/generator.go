@@ -0,0 +1,439 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// Generator creates quote documents from templates and logs them in the
+// quotation ledger.
+type Generator struct {
+	Folders   backend.FolderStore
+	Documents backend.DocumentStore
+	Ledger    backend.QuoteLedger
+
+	// ParentFolderID is the folder under which a folder per email domain is
+	// created to hold the generated documents.
+	ParentFolderID string
+	// OutDir is the local directory where PDFs are written.
+	OutDir string
+	// Templates maps template names to template document ids.
+	Templates map[string]string
+	// Catalog prices the line items that name a SKU.
+	Catalog *Catalog
+	// Sharing selects who generated quotes are shared with.
+	Sharing Sharing
+	// Mailer sends the quotes of requests with a Delivery, composed from
+	// Email.
+	Mailer Mailer
+	Email  EmailTemplate
+	// Scheme formats quote numbers. Defaults to DefaultNumberScheme.
+	Scheme *NumberScheme
+	// PlaceholderCheck selects whether placeholders of the template without
+	// value and data matching no placeholder are ignored, reported as
+	// warnings or fail the quote. Defaults to PlaceholderCheckWarn.
+	PlaceholderCheck PlaceholderCheck
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// Journal records the progress of every quote, so that a run interrupted
+	// half way can be resumed or rolled back. Optional.
+	Journal backend.Journal
+
+	folders folderCache
+}
+
+// folderCache remembers the Drive folder of each email domain, so that
+// concurrent quotes for the same domain look it up once and never create it
+// twice.
+type folderCache struct {
+	mu      sync.Mutex
+	entries map[string]*folderEntry
+}
+
+type folderEntry struct {
+	mu sync.Mutex
+	id string
+}
+
+func (c *folderCache) entry(parentID, name string) *folderEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*folderEntry{}
+	}
+	key := parentID + "/" + name
+	e, ok := c.entries[key]
+	if !ok {
+		e = &folderEntry{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// Request describes a quote to generate.
+type Request struct {
+	// Template is a template name from Generator.Templates or a document id.
+	Template string
+	// Data holds the placeholder values, keyed by placeholder with or
+	// without the surrounding braces.
+	Data map[string]string
+	// AllowUnresolved exports the PDF even if placeholders are left in the
+	// generated document.
+	AllowUnresolved bool
+	// Revise is the quote number this quote is a revision of. The customer
+	// data and template of its latest revision are used unless set in the
+	// request.
+	Revise string
+	// LineItems are priced from Generator.Catalog and rendered into the
+	// template table row holding the {{item.*}} placeholders. Their totals fill
+	// in the {{subtotal}}, {{discount}}, {{discount-percent}} and {{total}}
+	// placeholders and are recorded in the quotation log.
+	LineItems LineItems
+	// Lists holds the elements of the {{#each list}} sections of the template,
+	// each a map of the fields filled in for {{this.field}}.
+	Lists map[string][]map[string]string
+	// Send emails the PDF of the quote if set.
+	Send *Delivery
+	// RenewalOf is the quote number this quote renews, recorded in the
+	// quotation log. See Generator.RenewRequest.
+	RenewalOf string
+
+	// optional holds the placeholders filled in from structured input that
+	// templates need not use, like the fields of an address.
+	optional map[string]bool
+}
+
+// Result describes a generated quote.
+type Result struct {
+	Quote    string `json:"quote"`
+	DocID    string `json:"docID"`
+	FolderID string `json:"folderID"`
+	PDFPath  string `json:"pdfPath"`
+	// Link is the link to view the document.
+	Link string `json:"link,omitempty"`
+	// SentTo lists the recipients the quote was emailed to.
+	SentTo []string `json:"sentTo,omitempty"`
+	// Occurrences is how often each placeholder was replaced.
+	Occurrences map[string]int64 `json:"occurrences,omitempty"`
+	// Unresolved lists the placeholders left in the generated document.
+	Unresolved []string `json:"unresolved,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// UnresolvedError is returned when placeholders are left in a generated
+// document and Request.AllowUnresolved is not set.
+type UnresolvedError struct {
+	Quote string
+	// DocID is the generated document. It is cleared once the quote is
+	// rolled back, as the document is trashed then.
+	DocID        string
+	Placeholders []string
+}
+
+func (e *UnresolvedError) Error() string {
+	if e.DocID == "" {
+		return fmt.Sprintf("quote %s has unresolved placeholders: %s; the quote was voided and its document trashed", e.Quote, strings.Join(e.Placeholders, ", "))
+	}
+	return fmt.Sprintf("quote %s (doc id %s) has unresolved placeholders: %s", e.Quote, e.DocID, strings.Join(e.Placeholders, ", "))
+}
+
+// ErrInvalidRequest is returned when a quote request can not be generated
+// as given, e.g. because data or the template are missing.
+var ErrInvalidRequest = errors.New("invalid request")
+
+func invalidRequest(err error) error {
+	return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+}
+
+func (g *Generator) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
+
+func (g *Generator) scheme() *NumberScheme {
+	if g.Scheme != nil {
+		return g.Scheme
+	}
+	return DefaultNumberScheme()
+}
+
+// TemplateID returns the document id for a template name or id.
+func (g *Generator) TemplateID(template string) string {
+	if id, ok := g.Templates[template]; ok {
+		return id
+	}
+	return template
+}
+
+// Generate allocates a quote number, copies the template into the folder of
+// the customer's email domain, fills in the placeholders, writes the PDF to
+// OutDir, shares the document as configured in Sharing and emails the PDF if
+// the request has a Delivery.
+//
+// If a step fails, the steps done so far are compensated: the quote is marked
+// void in the ledger, the document copy is trashed and the PDF removed. If ctx
+// is canceled and there is a Journal, the run is left in it to be resumed.
+func (g *Generator) Generate(ctx context.Context, req Request) (*Result, error) {
+	run, err := g.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	release, err := g.acquire(run.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return g.execute(ctx, run)
+}
+
+// prepare validates a request and computes everything needed to generate the
+// quote, without writing anything.
+func (g *Generator) prepare(ctx context.Context, req Request) (*Run, error) {
+	if g.ParentFolderID == "" {
+		return nil, errors.New("missing parent folder id")
+	}
+	if err := g.Sharing.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sharing: %v", err)
+	}
+	if err := g.PlaceholderCheck.Validate(); err != nil {
+		return nil, err
+	}
+	if err := g.checkDelivery(req.Send); err != nil {
+		return nil, err
+	}
+	data := req.Data
+	if req.Revise != "" {
+		parent, err := findLatestRevision(ctx, g.Ledger, req.Revise)
+		if err != nil {
+			return nil, err
+		}
+		if req.Template == "" {
+			req.Template = Field(parent, ColTemplate)
+		}
+		data = revisionData(parent, req.Data)
+	}
+	if req.Template == "" {
+		return nil, invalidRequest(errors.New("missing template doc id"))
+	}
+	templateDocId := g.TemplateID(req.Template)
+
+	lineItems, err := g.Catalog.Price(req.LineItems)
+	if err != nil {
+		return nil, invalidRequest(err)
+	}
+	if err = lineItems.Validate(); err != nil {
+		return nil, invalidRequest(err)
+	}
+	replacements, err := Replacements(data, g.now())
+	if err != nil {
+		return nil, invalidRequest(err)
+	}
+	hasItems := len(lineItems.Items) > 0
+	if hasItems {
+		for k, v := range lineItems.Replacements() {
+			replacements[k] = v
+		}
+	}
+	email := replacements["{{email}}"]
+
+	warnings, err := g.checkPlaceholders(ctx, req.Template, templateDocId, data, replacements, hasItems, req.optional)
+	if err != nil {
+		return nil, err
+	}
+
+	row := LedgerRow(req.Template, replacements)
+	row = SetField(row, ColParent, req.Revise)
+	row = setStatus(row, StatusDraft, "", g.now())
+	if Field(row, ColSalesRep) == "" && req.Send != nil {
+		row = SetField(row, ColSalesRep, req.Send.ReplyTo)
+	}
+	if hasItems {
+		row = lineItems.ledgerFields(row)
+	}
+	row = SetField(row, ColRenewalOf, req.RenewalOf)
+	inputs, err := json.Marshal(newInputs(req, templateDocId, data, lineItems))
+	if err != nil {
+		return nil, err
+	}
+	row = SetField(row, ColInputs, string(inputs))
+	run, err := g.newRun(req, templateDocId, email, row, replacements, lineItems)
+	if err != nil {
+		return nil, err
+	}
+	if req.Send != nil {
+		if err = g.Email.check(run, req.Send); err != nil {
+			return nil, invalidRequest(err)
+		}
+	}
+	run.Warnings = warnings
+	return run, nil
+}
+
+// DocName returns the name of the document of a quote.
+func DocName(email, quote string) string {
+	return fmt.Sprintf("%s QUOTE #%s", FolderName(email), quote)
+}
+
+// PDFPath returns the path of the PDF of a quote in OutDir.
+func (g *Generator) PDFPath(email, quote string) (string, error) {
+	return g.outPath(email, DocName(email, quote)+".pdf")
+}
+
+// outPath returns the path of the named file in the directory of email in
+// OutDir. It fails if email is invalid or the path would escape OutDir.
+func (g *Generator) outPath(email, name string) (string, error) {
+	if err := ValidateEmail(email); err != nil {
+		return "", err
+	}
+	dir := filepath.Clean(g.OutDir)
+	path := filepath.Clean(filepath.Join(dir, FolderName(email), name))
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w %q: %s is outside %s", ErrInvalidEmail, email, path, dir)
+	}
+	return path, nil
+}
+
+// folder returns the id of the named folder under ParentFolderID, creating it
+// if needed.
+func (g *Generator) folder(ctx context.Context, name string) (string, error) {
+	e := g.folders.entry(g.ParentFolderID, name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.id != "" {
+		return e.id, nil
+	}
+
+	id, err := g.Folders.FindFolder(ctx, g.ParentFolderID, name)
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		id, err = g.Folders.CreateFolder(ctx, g.ParentFolderID, name)
+		if err != nil {
+			return "", err
+		}
+	}
+	e.id = id
+	return id, nil
+}
+
+// replace replaces the placeholders in the document and returns how often each
+// was found.
+func (g *Generator) replace(ctx context.Context, docID string, replacements map[string]string) (map[string]int64, error) {
+	keys := make([]string, 0, len(replacements))
+	for k := range replacements {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// https://developers.google.com/docs/api/how-tos/merge
+	reqs := make([]*docs.Request, 0, len(keys))
+	for _, k := range keys {
+		reqs = append(reqs, &docs.Request{
+			ReplaceAllText: &docs.ReplaceAllTextRequest{
+				ContainsText: &docs.SubstringMatchCriteria{
+					MatchCase: true,
+					Text:      k,
+				},
+				ReplaceText: replacements[k],
+			},
+		})
+	}
+	resp, err := g.Documents.BatchUpdate(ctx, docID, reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := make(map[string]int64, len(keys))
+	for i, reply := range resp.Replies {
+		if i < len(keys) && reply != nil && reply.ReplaceAllText != nil {
+			occurrences[keys[i]] = reply.ReplaceAllText.OccurrencesChanged
+		}
+	}
+	return occurrences, nil
+}
+
+// checkPlaceholders fetches the template and compares its placeholders with
+// the replacements before a quote number is spent on it. The line-item
+// placeholders are known if the quote has line items. Optional data is not
+// reported if the template does not use it.
+func (g *Generator) checkPlaceholders(ctx context.Context, template, templateDocId string, data, replacements map[string]string, lineItems bool, optional map[string]bool) ([]string, error) {
+	if g.PlaceholderCheck == PlaceholderCheckIgnore {
+		return nil, nil
+	}
+	doc, err := g.Documents.GetDocument(ctx, templateDocId)
+	if errors.Is(err, backend.ErrNotFound) {
+		return nil, invalidRequest(fmt.Errorf("unknown template %s", template))
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read template %s: %v", template, err)
+	}
+	if _, err = findBlocks(doc); err != nil {
+		return nil, invalidRequest(fmt.Errorf("template %s: %v", template, err))
+	}
+
+	known := map[string]string{"{{quote}}": ""}
+	for k, v := range replacements {
+		known[k] = v
+	}
+	if lineItems {
+		for k, v := range (LineItem{}).replacements(0) {
+			known[k] = v
+		}
+	}
+	// data recorded in the quotation log is used even if the template does
+	// not show it
+	logged := map[string]bool{"{{phone}}": true}
+	for _, key := range ledgerData {
+		logged[key] = true
+	}
+	supplied := make([]string, 0, len(data))
+	for k := range data {
+		if key := Placeholder(k); !logged[key] && !optional[key] {
+			supplied = append(supplied, key)
+		}
+	}
+	// data used by {{#if}} sections is optional
+	found, keys := sectionTokens(Placeholders(doc))
+	for _, key := range keys {
+		if _, ok := known[key]; !ok {
+			known[key] = ""
+		}
+		found = append(found, key)
+	}
+	missing, unused := CheckPlaceholders(found, known, supplied)
+	problems := placeholderProblems(template, missing, unused)
+	if g.PlaceholderCheck == PlaceholderCheckError && len(problems) > 0 {
+		return nil, invalidRequest(errors.New(strings.Join(problems, "; ")))
+	}
+	return problems, nil
+}
@@ -0,0 +1,578 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/email"
+
+	"google.golang.org/api/docs/v1"
+)
+
+var errFault = errors.New("injected fault")
+
+// faultyStore is a backend.Memory failing the first call of the method named
+// fail. If cancel is set, the call cancels the context of the run instead, as
+// if the process was interrupted.
+//
+// The first call of the method named crash succeeds, but then the process
+// crashes: cancel is called and the journal is not written anymore until
+// crashed is reset.
+type faultyStore struct {
+	*backend.Memory
+
+	mu      sync.Mutex
+	fail    string
+	crash   string
+	crashed bool
+	cancel  context.CancelFunc
+	copies  []string
+}
+
+func (f *faultyStore) fault(ctx context.Context, method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fail != method {
+		return nil
+	}
+	f.fail = ""
+	if f.cancel != nil {
+		f.cancel()
+		return ctx.Err()
+	}
+	return errFault
+}
+
+// crashAfter reports whether the process crashes after a call of method.
+func (f *faultyStore) crashAfter(method string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.crash != method {
+		return false
+	}
+	f.crash = ""
+	f.crashed = true
+	f.cancel()
+	return true
+}
+
+func (f *faultyStore) Save(ctx context.Context, id string, data []byte) error {
+	f.mu.Lock()
+	crashed := f.crashed
+	f.mu.Unlock()
+
+	if crashed {
+		return nil
+	}
+	return f.Memory.Save(ctx, id, data)
+}
+
+func (f *faultyStore) Append(ctx context.Context, row []string) (int, error) {
+	if err := f.fault(ctx, "Append"); err != nil {
+		return 0, err
+	}
+	i, err := f.Memory.Append(ctx, row)
+	if err == nil && f.crashAfter("Append") {
+		return 0, ctx.Err()
+	}
+	return i, err
+}
+
+func (f *faultyStore) Update(ctx context.Context, i int, row []string) error {
+	if err := f.fault(ctx, "Update"); err != nil {
+		return err
+	}
+	err := f.Memory.Update(ctx, i, row)
+	if err == nil && f.crashAfter("Update") {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (f *faultyStore) FindFolder(ctx context.Context, parentID, name string) (string, error) {
+	if err := f.fault(ctx, "FindFolder"); err != nil {
+		return "", err
+	}
+	return f.Memory.FindFolder(ctx, parentID, name)
+}
+
+func (f *faultyStore) CopyDocument(ctx context.Context, templateID, name, folderID string) (string, error) {
+	if err := f.fault(ctx, "CopyDocument"); err != nil {
+		return "", err
+	}
+	id, err := f.Memory.CopyDocument(ctx, templateID, name, folderID)
+	if err == nil {
+		f.mu.Lock()
+		f.copies = append(f.copies, id)
+		f.mu.Unlock()
+		if f.crashAfter("CopyDocument") {
+			return "", ctx.Err()
+		}
+	}
+	return id, err
+}
+
+func (f *faultyStore) BatchUpdate(ctx context.Context, docID string, reqs []*docs.Request) (*docs.BatchUpdateDocumentResponse, error) {
+	if err := f.fault(ctx, "BatchUpdate"); err != nil {
+		return nil, err
+	}
+	return f.Memory.BatchUpdate(ctx, docID, reqs)
+}
+
+func (f *faultyStore) ExportPDF(ctx context.Context, docID string) ([]byte, error) {
+	if err := f.fault(ctx, "ExportPDF"); err != nil {
+		return nil, err
+	}
+	return f.Memory.ExportPDF(ctx, docID)
+}
+
+func (f *faultyStore) ShareDocument(ctx context.Context, docID string, perms []backend.Permission) (string, error) {
+	if err := f.fault(ctx, "ShareDocument"); err != nil {
+		return "", err
+	}
+	return f.Memory.ShareDocument(ctx, docID, perms)
+}
+
+type testMailer struct {
+	mu   sync.Mutex
+	err  error
+	sent []*email.Message
+}
+
+func (m *testMailer) Send(_ context.Context, msg *email.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func newTestGenerator(t *testing.T) (*Generator, *faultyStore) {
+	t.Helper()
+	store := &faultyStore{Memory: backend.NewMemory()}
+	root, err := store.CreateFolder(context.Background(), "", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutDocument("tmpl", backend.NewTextDocument("Template", "Quote #{{quote}}\nFor {{name}} <{{email}}>\nValid until {{expiry-date}}\n"))
+	g := &Generator{
+		Folders:        store,
+		Documents:      store,
+		Ledger:         store,
+		Journal:        store,
+		ParentFolderID: root,
+		OutDir:         t.TempDir(),
+		Templates:      map[string]string{"kubedb": "tmpl"},
+		Sharing:        Sharing{Customer: backend.RoleReader},
+		Mailer:         &testMailer{},
+		Email:          EmailTemplate{From: "sales@appscode.com"},
+		Now: func() time.Time {
+			return time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC)
+		},
+	}
+	return g, store
+}
+
+func testRequest() Request {
+	return Request{
+		Template: "kubedb",
+		Data: map[string]string{
+			"name":  "Jane Doe",
+			"email": "jane@example.com",
+		},
+	}
+}
+
+// checkNoLeftovers fails if a document copy, a PDF or a journal entry is left
+// behind.
+func checkNoLeftovers(t *testing.T, g *Generator, store *faultyStore) {
+	t.Helper()
+	for _, id := range store.copies {
+		if !store.Trashed(id) {
+			t.Errorf("document %s was not trashed", id)
+		}
+	}
+	err := filepath.Walk(g.OutDir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			t.Errorf("file %s was not removed", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := store.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) > 0 {
+		t.Errorf("runs %v left in the journal", ids)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	req := testRequest()
+	req.Send = &Delivery{ReplyTo: "rep@appscode.com"}
+	result, err := g.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := backend.PlainText(store.Document(result.DocID))
+	for _, want := range []string{"Quote #" + result.Quote, "For Jane Doe <jane@example.com>", "Valid until Nov 6, 2024"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("document misses %q:\n%s", want, text)
+		}
+	}
+	pdf, err := os.ReadFile(result.PDFPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pdf) != text {
+		t.Errorf("pdf does not match the document:\n%s", pdf)
+	}
+	if want := filepath.Join(g.OutDir, "example.com", "example.com QUOTE #"+result.Quote+".pdf"); result.PDFPath != want {
+		t.Errorf("pdf path = %s, want %s", result.PDFPath, want)
+	}
+	if perms := store.Permissions(result.DocID); len(perms) != 1 || perms[0].EmailAddress != "jane@example.com" {
+		t.Errorf("unexpected permissions %+v", perms)
+	}
+
+	row, _, err := FindQuote(ctx, store, result.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for col, want := range map[string]string{
+		ColEmail:    "jane@example.com",
+		ColTemplate: "kubedb",
+		ColLink:     result.Link,
+		ColSalesRep: "rep@appscode.com",
+		ColStatus:   StatusSent,
+	} {
+		if got := Field(row, col); got != want {
+			t.Errorf("%s = %q, want %q", col, got, want)
+		}
+	}
+
+	sent := g.Mailer.(*testMailer).sent
+	if len(sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent))
+	}
+	if msg := sent[0]; len(msg.To) != 1 || msg.To[0] != "jane@example.com" || msg.Subject != "Quote "+result.Quote {
+		t.Errorf("unexpected email to %v: %s", msg.To, msg.Subject)
+	}
+	ids, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) > 0 {
+		t.Errorf("runs %v left in the journal", ids)
+	}
+}
+
+func TestGenerateRollback(t *testing.T) {
+	tests := []struct {
+		fail   string
+		logged bool
+	}{
+		{"Append", false},
+		{"FindFolder", true},
+		{"CopyDocument", true},
+		{"BatchUpdate", true},
+		{"ExportPDF", true},
+		{"ShareDocument", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fail, func(t *testing.T) {
+			g, store := newTestGenerator(t)
+			ctx := context.Background()
+			store.fail = tt.fail
+
+			_, err := g.Generate(ctx, testRequest())
+			if err == nil || !strings.Contains(err.Error(), errFault.Error()) {
+				t.Fatalf("got error %v, want %v", err, errFault)
+			}
+
+			rows, err := store.Rows(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case !tt.logged && len(rows) != 0:
+				t.Errorf("logged %d quotes", len(rows))
+			case tt.logged && len(rows) != 1:
+				t.Errorf("logged %d quotes, want 1", len(rows))
+			case tt.logged && Status(rows[0]) != StatusVoid:
+				t.Errorf("quote %s is %s, want %s", Field(rows[0], ColQuote), Status(rows[0]), StatusVoid)
+			}
+			checkNoLeftovers(t, g, store)
+		})
+	}
+}
+
+func TestGenerateSendFailure(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+	mailer := g.Mailer.(*testMailer)
+	mailer.err = errFault
+
+	req := testRequest()
+	req.Send = &Delivery{}
+	_, err := g.Generate(ctx, req)
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("got error %v, want a SendError", err)
+	}
+	if sendErr.Result.Quote == "" || sendErr.RunID == "" {
+		t.Fatalf("incomplete SendError %+v", sendErr)
+	}
+	row, _, err := FindQuote(ctx, store, sendErr.Result.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Status(row) != StatusDraft {
+		t.Errorf("quote is %s, want %s", Status(row), StatusDraft)
+	}
+
+	mailer.err = nil
+	result, err := g.Resume(ctx, sendErr.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Quote != sendErr.Result.Quote || len(mailer.sent) != 1 {
+		t.Errorf("resume sent quote %s %d times, want %s once", result.Quote, len(mailer.sent), sendErr.Result.Quote)
+	}
+}
+
+func TestResume(t *testing.T) {
+	steps := []string{"FindFolder", "CopyDocument", "BatchUpdate", "ExportPDF", "ShareDocument"}
+	for _, step := range steps {
+		t.Run(step, func(t *testing.T) {
+			g, store := newTestGenerator(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			store.fail, store.cancel = step, cancel
+
+			_, err := g.Generate(ctx, testRequest())
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("got error %v, want %v", err, context.Canceled)
+			}
+			runs, err := g.Runs(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(runs) != 1 {
+				t.Fatalf("%d runs in the journal, want 1", len(runs))
+			}
+
+			result, err := g.Resume(context.Background(), runs[0].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := store.Rows(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 || Field(rows[0], ColQuote) != result.Quote {
+				t.Fatalf("resume logged %d quotes, want only %s", len(rows), result.Quote)
+			}
+			if Status(rows[0]) != StatusDraft || Field(rows[0], ColLink) != result.Link {
+				t.Errorf("quote is %s with link %q", Status(rows[0]), Field(rows[0], ColLink))
+			}
+			if text := backend.PlainText(store.Document(result.DocID)); !strings.Contains(text, "Quote #"+result.Quote) {
+				t.Errorf("document not rendered:\n%s", text)
+			}
+			if _, err = os.Stat(result.PDFPath); err != nil {
+				t.Error(err)
+			}
+			if runs, _ = g.Runs(context.Background()); len(runs) != 0 {
+				t.Errorf("%d runs left in the journal", len(runs))
+			}
+		})
+	}
+}
+
+// crash generates a quote until the process crashes after the call of
+// method, and returns the run left in the journal.
+func crash(t *testing.T, g *Generator, store *faultyStore, method string) *Run {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.crash, store.cancel = method, cancel
+
+	if _, err := g.Generate(ctx, testRequest()); err == nil || !strings.Contains(err.Error(), "was interrupted") {
+		t.Fatalf("got error %v, want an interrupted run", err)
+	}
+	store.crashed = false
+	runs, err := g.Runs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("%d runs in the journal, want 1", len(runs))
+	}
+	return runs[0]
+}
+
+func TestResumeCrash(t *testing.T) {
+	tests := []struct {
+		method string
+		marker bool
+		copy   bool
+	}{
+		// the row was appended, but its number not written
+		{"Append", true, false},
+		// the number was written, but not saved as the quote of the run
+		{"Update", true, false},
+		// the copy was made, but its id not saved
+		{"CopyDocument", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			g, store := newTestGenerator(t)
+			run := crash(t, g, store, tt.method)
+			if (run.Marker != "") != tt.marker || (run.Copy != "") != tt.copy || run.DocID != "" {
+				t.Fatalf("journal holds marker %q, copy %q and document %q", run.Marker, run.Copy, run.DocID)
+			}
+
+			result, err := g.Resume(context.Background(), run.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := store.Rows(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 || Field(rows[0], ColQuote) != result.Quote || Status(rows[0]) != StatusDraft {
+				t.Fatalf("got ledger %q, want only quote %s", rows, result.Quote)
+			}
+			if result.Quote != "AC2410001" {
+				t.Errorf("resumed quote is %s, want AC2410001", result.Quote)
+			}
+			for _, id := range store.copies {
+				if id != result.DocID && !store.Trashed(id) {
+					t.Errorf("document %s was not trashed", id)
+				}
+			}
+			if text := backend.PlainText(store.Document(result.DocID)); !strings.Contains(text, "Quote #"+result.Quote) {
+				t.Errorf("document not rendered:\n%s", text)
+			}
+			if runs, _ := g.Runs(context.Background()); len(runs) != 0 {
+				t.Errorf("%d runs left in the journal", len(runs))
+			}
+		})
+	}
+}
+
+func TestRollbackCrash(t *testing.T) {
+	for _, method := range []string{"Append", "Update", "CopyDocument"} {
+		t.Run(method, func(t *testing.T) {
+			g, store := newTestGenerator(t)
+			run := crash(t, g, store, method)
+
+			if err := g.Rollback(context.Background(), run.ID); err != nil {
+				t.Fatal(err)
+			}
+			rows, err := store.Rows(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 || Field(rows[0], ColQuote) != "AC2410001" || Status(rows[0]) != StatusVoid {
+				t.Errorf("got ledger %q, want void quote AC2410001", rows)
+			}
+			checkNoLeftovers(t, g, store)
+
+			// the number is not issued again
+			result, err := g.Generate(context.Background(), testRequest())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Quote != "AC2410002" {
+				t.Errorf("next quote is %s, want AC2410002", result.Quote)
+			}
+		})
+	}
+}
+
+func TestRollbackRun(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.fail, store.cancel = "ShareDocument", cancel
+
+	if _, err := g.Generate(ctx, testRequest()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	runs, err := g.Runs(context.Background())
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("got runs %v, %v", runs, err)
+	}
+	if err = g.Rollback(context.Background(), runs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := store.Rows(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || Status(rows[0]) != StatusVoid {
+		t.Errorf("quote was not voided")
+	}
+	checkNoLeftovers(t, g, store)
+}
+
+func TestGenerateUnresolved(t *testing.T) {
+	g, store := newTestGenerator(t)
+	store.PutDocument("tmpl", backend.NewTextDocument("Template", "Quote #{{quote}}\nFor {{name}} at {{address}}\n"))
+	ctx := context.Background()
+
+	_, err := g.Generate(ctx, testRequest())
+	var unresolved *UnresolvedError
+	if !errors.As(err, &unresolved) {
+		t.Fatalf("got error %v, want an UnresolvedError", err)
+	}
+	if unresolved.DocID != "" || !strings.Contains(err.Error(), "trashed") {
+		t.Errorf("error reports document %q: %v", unresolved.DocID, err)
+	}
+	if len(unresolved.Placeholders) != 1 || unresolved.Placeholders[0] != "{{address}}" {
+		t.Errorf("unresolved %v, want [{{address}}]", unresolved.Placeholders)
+	}
+	row, _, err := FindQuote(ctx, store, unresolved.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Status(row) != StatusVoid {
+		t.Errorf("quote is %s, want %s", Status(row), StatusVoid)
+	}
+	checkNoLeftovers(t, g, store)
+}
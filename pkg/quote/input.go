@@ -0,0 +1,203 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RequestSchema is the JSON Schema of QuoteRequest.
+//
+//go:embed schema/quote-request.schema.json
+var RequestSchema []byte
+
+// QuoteRequest is the structured input of a quote, read from a JSON or YAML
+// file. It is turned into a Request with Request.
+type QuoteRequest struct {
+	// Template is a template name or document id.
+	Template string `json:"template,omitempty"`
+	// Revise is the quote number this quote is a revision of.
+	Revise string `json:"revise,omitempty"`
+	// Customer fills in the {{name}}, {{designation}}, {{email}}, {{tel}}
+	// and {{company}} placeholders.
+	Customer Contact `json:"customer"`
+	// SalesRep is the email of the sales rep handling the quote. It fills in
+	// the {{sales-rep}} placeholder and is recorded in the quotation log.
+	SalesRep string `json:"salesRep,omitempty"`
+	// Contacts are further contacts of the customer, repeated by
+	// {{#each contacts}} sections.
+	Contacts []Contact `json:"contacts,omitempty"`
+	// Address fills in the {{address}} placeholder and the
+	// {{address-street}}, {{address-city}}, ... placeholders of its fields.
+	Address *Address `json:"address,omitempty"`
+	// Data holds further placeholder values.
+	Data Values `json:"data,omitempty"`
+	// Lists holds the elements of {{#each}} sections.
+	Lists map[string][]Values `json:"lists,omitempty"`
+	// LineItems, Discount and Currency are rendered as line items.
+	LineItems []LineItem `json:"lineItems,omitempty"`
+	Discount  float64    `json:"discount,omitempty"`
+	Currency  string     `json:"currency,omitempty"`
+	// AllowUnresolved exports the PDF even if placeholders are left.
+	AllowUnresolved bool `json:"allowUnresolved,omitempty"`
+	// Send emails the PDF of the quote.
+	Send *Delivery `json:"send,omitempty"`
+}
+
+// Contact is a person at the customer.
+type Contact struct {
+	Name        string `json:"name,omitempty"`
+	Designation string `json:"designation,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Tel         string `json:"tel,omitempty"`
+	Company     string `json:"company,omitempty"`
+}
+
+func (c Contact) values() map[string]string {
+	v := map[string]string{}
+	for k, val := range map[string]string{
+		"{{name}}":        c.Name,
+		"{{designation}}": c.Designation,
+		"{{email}}":       c.Email,
+		"{{tel}}":         c.Tel,
+		"{{company}}":     c.Company,
+	} {
+		if val != "" {
+			v[k] = val
+		}
+	}
+	return v
+}
+
+// Address is the postal address of the customer.
+type Address struct {
+	Street     string `json:"street,omitempty"`
+	City       string `json:"city,omitempty"`
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postalCode,omitempty"`
+	Country    string `json:"country,omitempty"`
+}
+
+// String returns the address on one line, e.g. "1 Main St, Austin, TX 78701,
+// US".
+func (a Address) String() string {
+	var parts []string
+	for _, p := range []string{a.Street, a.City, strings.TrimSpace(a.State + " " + a.PostalCode), a.Country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (a Address) values() map[string]string {
+	v := map[string]string{"{{address}}": a.String()}
+	for k, val := range map[string]string{
+		"{{address-street}}":      a.Street,
+		"{{address-city}}":        a.City,
+		"{{address-state}}":       a.State,
+		"{{address-postal-code}}": a.PostalCode,
+		"{{address-country}}":     a.Country,
+	} {
+		if val != "" {
+			v[k] = val
+		}
+	}
+	return v
+}
+
+// Values maps placeholders to values. In JSON and YAML, values may be strings,
+// numbers or booleans.
+type Values map[string]string
+
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var in map[string]interface{}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	out := make(Values, len(in))
+	for k, val := range in {
+		switch val := val.(type) {
+		case nil:
+		case string:
+			out[k] = val
+		case float64:
+			out[k] = strconv.FormatFloat(val, 'f', -1, 64)
+		case bool:
+			out[k] = strconv.FormatBool(val)
+		default:
+			return fmt.Errorf("value of %s must be a string, number or boolean", k)
+		}
+	}
+	*v = out
+	return nil
+}
+
+// Request returns the generator request for the quote request. The customer
+// and address come first, so that Data can override them.
+func (r *QuoteRequest) Request() (Request, error) {
+	req := Request{
+		Template:        r.Template,
+		Revise:          r.Revise,
+		AllowUnresolved: r.AllowUnresolved,
+		Send:            r.Send,
+		Data:            r.Customer.values(),
+		LineItems: LineItems{
+			Currency: r.Currency,
+			Items:    r.LineItems,
+			Discount: r.Discount,
+		},
+	}
+	if r.Address != nil {
+		req.optional = map[string]bool{}
+		for k, v := range r.Address.values() {
+			req.Data[k] = v
+			req.optional[k] = true
+		}
+	}
+	if r.SalesRep != "" {
+		req.Data["{{sales-rep}}"] = r.SalesRep
+	}
+	for k, v := range r.Data {
+		req.Data[Placeholder(k)] = v
+	}
+
+	if len(r.Contacts) > 0 || len(r.Lists) > 0 {
+		req.Lists = map[string][]map[string]string{}
+	}
+	for _, c := range r.Contacts {
+		fields := map[string]string{}
+		for k, v := range c.values() {
+			fields[strings.Trim(k, "{}")] = v
+		}
+		req.Lists["contacts"] = append(req.Lists["contacts"], fields)
+	}
+	for name, list := range r.Lists {
+		if name == "contacts" && len(r.Contacts) > 0 {
+			return req, errors.New("contacts are given both as contacts and as a list")
+		}
+		for _, el := range list {
+			req.Lists[name] = append(req.Lists[name], el)
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,295 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+const testQuoteRequest = `template: kubedb
+salesRep: rep@appscode.com
+customer:
+  name: Jane Doe
+  email: jane@example.com
+  company: Example Inc
+contacts:
+- name: John Roe
+  email: john@example.com
+address:
+  street: 1 Main St
+  city: Austin
+  state: TX
+  postalCode: "78701"
+data:
+  company: Example LLC
+  seats: 5
+  trial: true
+  fax: null
+  "{{discount-code}}": FALL24
+lists:
+  nodes:
+  - name: node-1
+    cpu: 4
+lineItems:
+- product: KubeDB Enterprise
+  quantity: 2
+  unitPrice: 1000
+  term: annual
+  periods: 2
+discount: 10
+currency: EUR
+allowUnresolved: true
+send:
+  cc: [rep@appscode.com]
+`
+
+func TestQuoteRequest(t *testing.T) {
+	var qr QuoteRequest
+	if err := yaml.UnmarshalStrict([]byte(testQuoteRequest), &qr); err != nil {
+		t.Fatal(err)
+	}
+	req, err := qr.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Template != "kubedb" || !req.AllowUnresolved || req.Send == nil || !reflect.DeepEqual(req.Send.Cc, []string{"rep@appscode.com"}) {
+		t.Errorf("got template %q, allow unresolved %v and delivery %+v", req.Template, req.AllowUnresolved, req.Send)
+	}
+	wantData := map[string]string{
+		"{{name}}":                "Jane Doe",
+		"{{email}}":               "jane@example.com",
+		"{{company}}":             "Example LLC",
+		"{{sales-rep}}":           "rep@appscode.com",
+		"{{address}}":             "1 Main St, Austin, TX 78701",
+		"{{address-street}}":      "1 Main St",
+		"{{address-city}}":        "Austin",
+		"{{address-state}}":       "TX",
+		"{{address-postal-code}}": "78701",
+		"{{seats}}":               "5",
+		"{{trial}}":               "true",
+		"{{discount-code}}":       "FALL24",
+	}
+	if !reflect.DeepEqual(req.Data, wantData) {
+		t.Errorf("got data %v, want %v", req.Data, wantData)
+	}
+	for _, k := range []string{"{{address}}", "{{address-city}}"} {
+		if !req.optional[k] {
+			t.Errorf("%s is not optional", k)
+		}
+	}
+	if req.optional["{{name}}"] {
+		t.Error("{{name}} is optional")
+	}
+	wantLists := map[string][]map[string]string{
+		"contacts": {{"name": "John Roe", "email": "john@example.com"}},
+		"nodes":    {{"name": "node-1", "cpu": "4"}},
+	}
+	if !reflect.DeepEqual(req.Lists, wantLists) {
+		t.Errorf("got lists %v, want %v", req.Lists, wantLists)
+	}
+	wantItems := LineItems{
+		Currency: "EUR",
+		Discount: 10,
+		Items:    []LineItem{{Product: "KubeDB Enterprise", Quantity: 2, UnitPrice: 1000, Term: TermAnnual, Periods: 2}},
+	}
+	if !reflect.DeepEqual(req.LineItems, wantItems) {
+		t.Errorf("got line items %+v, want %+v", req.LineItems, wantItems)
+	}
+}
+
+func TestQuoteRequestMinimal(t *testing.T) {
+	qr := QuoteRequest{Revise: "AC2410001"}
+	req, err := qr.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Revise != "AC2410001" || len(req.Data) != 0 || req.Lists != nil || req.optional != nil || len(req.LineItems.Items) != 0 {
+		t.Errorf("got request %+v", req)
+	}
+}
+
+func TestQuoteRequestContactsTwice(t *testing.T) {
+	qr := QuoteRequest{
+		Customer: Contact{Email: "jane@example.com"},
+		Contacts: []Contact{{Name: "John Roe"}},
+		Lists:    map[string][]Values{"contacts": {{"name": "Ann Roe"}}},
+	}
+	if _, err := qr.Request(); err == nil {
+		t.Error("contacts given twice were accepted")
+	}
+}
+
+func TestQuoteRequestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		err  string
+		// yaml is set if YAML rejects the input too; YAML turns scalars
+		// into strings
+		yaml bool
+	}{
+		{"unknown field", `{"customer": {"email": "jane@example.com"}, "foo": 1}`, `unknown field "foo"`, true},
+		{"unknown nested field", `{"customer": {"email": "jane@example.com", "phone": "555"}}`, `unknown field "phone"`, true},
+		{"unknown line item field", `{"lineItems": [{"product": "KubeDB", "quantity": 1, "price": 10}]}`, `unknown field "price"`, true},
+		{"string for number", `{"discount": "10%"}`, "discount", true},
+		{"number for string", `{"customer": {"email": 42}}`, "email", false},
+		{"object for list", `{"contacts": {"name": "John Roe"}}`, "contacts", true},
+		{"nested data value", `{"data": {"address": {"city": "Austin"}}}`, "value of address must be a string, number or boolean", true},
+		{"list value", `{"lists": {"nodes": [{"ips": ["10.0.0.1"]}]}}`, "value of ips must be a string, number or boolean", true},
+		{"data array", `{"data": ["jane@example.com"]}`, "cannot unmarshal array", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := json.NewDecoder(strings.NewReader(tt.json))
+			dec.DisallowUnknownFields()
+			var qr QuoteRequest
+			if err := dec.Decode(&qr); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("JSON: got error %v, want %q", err, tt.err)
+			}
+			err := yaml.UnmarshalStrict([]byte(tt.json), &QuoteRequest{})
+			if tt.yaml && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("YAML: got error %v, want %q", err, tt.err)
+			}
+			if !tt.yaml && err != nil {
+				t.Errorf("YAML: %v", err)
+			}
+		})
+	}
+}
+
+func TestQuoteRequestRequiredFields(t *testing.T) {
+	tests := []struct {
+		name string
+		qr   QuoteRequest
+		err  error
+	}{
+		{"customer email", QuoteRequest{Template: "kubedb", Customer: Contact{Name: "Jane Doe", Email: "jane@example.com"}}, nil},
+		{"data email", QuoteRequest{Template: "kubedb", Customer: Contact{Name: "Jane Doe"}, Data: Values{"email": "jane@example.com"}}, nil},
+		{"missing email", QuoteRequest{Template: "kubedb", Customer: Contact{Name: "Jane Doe"}}, ErrInvalidRequest},
+		{"missing template", QuoteRequest{Customer: Contact{Name: "Jane Doe", Email: "jane@example.com"}}, ErrInvalidRequest},
+		{"unknown revision", QuoteRequest{Revise: "AC2410001"}, ErrQuoteNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, store := newTestGenerator(t)
+			req, err := tt.qr.Request()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = g.Generate(context.Background(), req)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			checkNoLeftovers(t, g, store)
+		})
+	}
+}
+
+// schemaObject is the part of a JSON Schema object definition that is checked
+// against the Go types.
+type schemaObject struct {
+	Properties           map[string]json.RawMessage `json:"properties"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Required             []string                   `json:"required"`
+	AnyOf                []struct {
+		Required   []string                `json:"required"`
+		Properties map[string]schemaObject `json:"properties"`
+	} `json:"anyOf"`
+}
+
+// jsonFields returns the sorted JSON names of the fields of a struct.
+func jsonFields(v interface{}) []string {
+	var names []string
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if name := strings.Split(f.Tag.Get("json"), ",")[0]; f.IsExported() && name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRequestSchema(t *testing.T) {
+	var schema struct {
+		schemaObject
+		Defs map[string]schemaObject `json:"$defs"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(RequestSchema))
+	if err := dec.Decode(&schema); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := []struct {
+		name   string
+		schema schemaObject
+		typ    interface{}
+	}{
+		{"QuoteRequest", schema.schemaObject, QuoteRequest{}},
+		{"contact", schema.Defs["contact"], Contact{}},
+		{"address", schema.Defs["address"], Address{}},
+		{"lineItem", schema.Defs["lineItem"], LineItem{}},
+		{"delivery", schema.Defs["delivery"], Delivery{}},
+	}
+	for _, o := range objects {
+		var props []string
+		for k := range o.schema.Properties {
+			props = append(props, k)
+		}
+		sort.Strings(props)
+		if want := jsonFields(o.typ); !reflect.DeepEqual(props, want) {
+			t.Errorf("%s: schema properties %v, want %v", o.name, props, want)
+		}
+		if string(o.schema.AdditionalProperties) != "false" {
+			t.Errorf("%s: schema allows additional properties", o.name)
+		}
+	}
+
+	// a request needs the customer's email, either as customer or as data,
+	// unless it revises a quote
+	var required []string
+	for _, alt := range schema.AnyOf {
+		s := strings.Join(alt.Required, ",")
+		for name, p := range alt.Properties {
+			s += " " + name + "." + strings.Join(p.Required, ",")
+		}
+		required = append(required, s)
+	}
+	if want := []string{"customer customer.email", "data data.email", "revise"}; !reflect.DeepEqual(required, want) {
+		t.Errorf("schema requires one of %q, want %q", required, want)
+	}
+	if got := schema.Defs["lineItem"].Required; !reflect.DeepEqual(got, []string{"quantity"}) {
+		t.Errorf("line items require %v, want [quantity]", got)
+	}
+}
@@ -0,0 +1,412 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+// LedgerSheet is the name of the sheet used as quotation log.
+const LedgerSheet = "Quotation Log"
+
+// Columns of the quotation log.
+const (
+	ColQuote           = "Quotation #"
+	ColName            = "Name"
+	ColDesignation     = "Designation"
+	ColEmail           = "Email"
+	ColTelephone       = "Telephone"
+	ColCompany         = "Company"
+	ColWebsite         = "Website"
+	ColCountry         = "Country"
+	ColTemplate        = "Pricing Template"
+	ColPreparationDate = "Preparation Date"
+	ColExpirationDate  = "Expiration Date"
+	ColParent          = "Parent Quotation #"
+	ColSupersededBy    = "Superseded By"
+	ColCurrency        = "Currency"
+	ColSubtotal        = "Subtotal"
+	ColDiscount        = "Discount"
+	ColTotal           = "Total"
+	ColStatus          = "Status"
+	ColLink            = "Link"
+	ColStatusChanged   = "Status Changed"
+	ColStatusNote      = "Status Note"
+	ColStatusHistory   = "Status History"
+	ColSalesRep        = "Sales Rep"
+	ColRenewalOf       = "Renewal Of"
+	ColInputs          = "Inputs"
+)
+
+// LedgerHeaders are the columns of the quotation log. New columns are only
+// ever added at the end, so that rows written before keep their layout.
+var LedgerHeaders = []string{
+	ColQuote,
+	ColName,
+	ColDesignation,
+	ColEmail,
+	ColTelephone,
+	ColCompany,
+	ColWebsite,
+	ColCountry,
+	ColTemplate,
+	ColPreparationDate,
+	ColExpirationDate,
+	ColParent,
+	ColSupersededBy,
+	ColCurrency,
+	ColSubtotal,
+	ColDiscount,
+	ColTotal,
+	ColStatus,
+	ColLink,
+	ColStatusChanged,
+	ColStatusNote,
+	ColStatusHistory,
+	ColSalesRep,
+	ColRenewalOf,
+	ColInputs,
+}
+
+// ledgerData maps the columns holding customer data and the sales rep to
+// their placeholders.
+var ledgerData = map[string]string{
+	ColName:        "{{name}}",
+	ColDesignation: "{{designation}}",
+	ColEmail:       "{{email}}",
+	ColTelephone:   "{{tel}}",
+	ColCompany:     "{{company}}",
+	ColWebsite:     "{{website}}",
+	ColCountry:     "{{country}}",
+	ColSalesRep:    "{{sales-rep}}",
+}
+
+// LedgerRow returns the quotation log row for a quote prepared from template
+// with the given replacements. The quote number column is left empty.
+func LedgerRow(template string, replacements map[string]string) []string {
+	row := make([]string, len(LedgerHeaders))
+	for col, key := range ledgerData {
+		row = SetField(row, col, replacements[key])
+	}
+	row = SetField(row, ColTemplate, template)
+	row = SetField(row, ColPreparationDate, replacements["{{prep-date}}"])
+	row = SetField(row, ColExpirationDate, replacements["{{expiry-date}}"])
+	return row
+}
+
+// Field returns the value of the named column in a quotation log row.
+func Field(row []string, col string) string {
+	return cell(row, column(LedgerHeaders, col))
+}
+
+// SetField sets the value of the named column in a quotation log row, growing
+// the row if needed, and returns the row.
+func SetField(row []string, col, value string) []string {
+	i := column(LedgerHeaders, col)
+	if i < 0 {
+		return row
+	}
+	for len(row) <= i {
+		row = append(row, "")
+	}
+	row[i] = value
+	return row
+}
+
+// ErrQuoteNotFound is returned when a quote number is not in the ledger.
+var ErrQuoteNotFound = errors.New("quote not found")
+
+// FindQuote returns the ledger row of a quote number and its index in the
+// ledger rows.
+func FindQuote(ctx context.Context, ledger backend.QuoteLedger, quote string) ([]string, int, error) {
+	rows, err := ledger.Rows(ctx)
+	if err != nil {
+		return nil, -1, err
+	}
+	idx := findRow(rows, quote)
+	if idx < 0 {
+		return nil, -1, fmt.Errorf("%w: %s", ErrQuoteNotFound, quote)
+	}
+	return rows[idx], idx, nil
+}
+
+// pendingQuote marks a ledger row whose quote number is not resolved yet. It
+// is followed by the reservation time and a random nonce.
+const pendingQuote = "AC_DETECT_QUOTE"
+
+// maxAllocAttempts bounds how often LogQuotation reserves a new row when the
+// allocated quote number turns out to be taken.
+const maxAllocAttempts = 5
+
+// LogQuotation allocates the next quote number, stores it in the first column
+// of data and appends data to the ledger.
+//
+// Quote numbers are allocated in two phases so that concurrent writers never
+// get the same number. First a row with a unique pending marker is appended;
+// the ledger orders all reservations. Then the number is resolved from the rows
+// above the reservation, counting pending rows as if they were already
+// resolved, and written back. Because every writer resolves the same prefix the
+// same way, numbers are unique as long as rows are only ever appended.
+//
+// Finally the ledger is re-read to verify that the number is not also used by
+// a row written outside this protocol. If it is, the reserved row keeps the
+// number but loses its data, so rows below still resolve the same way, and the
+// allocation is retried with a new reservation.
+func LogQuotation(ctx context.Context, ledger backend.QuoteLedger, scheme *NumberScheme, headers, data []string, now time.Time) (string, error) {
+	return logQuotation(ctx, ledger, scheme, headers, data, now, nil)
+}
+
+// reserveFunc is called with the pending marker of a row before the row is
+// appended, and again with the quote number the row resolves to before the
+// number is written, so that the caller can record both and find the row
+// after a crash.
+type reserveFunc func(marker, quote string) error
+
+func logQuotation(ctx context.Context, ledger backend.QuoteLedger, scheme *NumberScheme, headers, data []string, now time.Time, reserve reserveFunc) (string, error) {
+	err := ledger.EnsureSchema(ctx, headers)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; attempt < maxAllocAttempts; attempt++ {
+		quote, unique, err := allocQuote(ctx, ledger, scheme, headers, data, now, reserve)
+		if err != nil {
+			return "", err
+		}
+		if unique {
+			return quote, nil
+		}
+	}
+	return "", fmt.Errorf("failed to allocate a unique quote number after %d attempts", maxAllocAttempts)
+}
+
+// allocQuote reserves a row for data, resolves its quote number and reports
+// whether the number is unique in the ledger.
+func allocQuote(ctx context.Context, ledger backend.QuoteLedger, scheme *NumberScheme, headers, data []string, now time.Time, reserve reserveFunc) (string, bool, error) {
+	marker, err := newPendingMarker(now)
+	if err != nil {
+		return "", false, err
+	}
+	if reserve != nil {
+		if err = reserve(marker, ""); err != nil {
+			return "", false, err
+		}
+	}
+	data[0] = marker
+	_, err = ledger.Append(ctx, data)
+	if err != nil {
+		return "", false, err
+	}
+
+	rows, err := ledger.Rows(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	idx := findRow(rows, marker)
+	if idx < 0 {
+		return "", false, fmt.Errorf("reserved row %s is missing from the ledger", marker)
+	}
+	return resolveReserved(ctx, ledger, scheme, headers, data, rows, idx, now, reserve)
+}
+
+// resolveReserved writes the quote number of the reserved row at idx and
+// reports whether the number is unique in the ledger. If it is not, the row
+// keeps the number but loses its data.
+func resolveReserved(ctx context.Context, ledger backend.QuoteLedger, scheme *NumberScheme, headers, data []string, rows [][]string, idx int, now time.Time, reserve reserveFunc) (string, bool, error) {
+	quote := resolveQuote(scheme, headers, rows, idx, now)
+	if reserve != nil {
+		if err := reserve(cell(rows[idx], 0), quote); err != nil {
+			return "", false, err
+		}
+	}
+	data[0] = quote
+	err := ledger.Update(ctx, idx, data)
+	if err != nil {
+		return "", false, err
+	}
+
+	rows, err = ledger.Rows(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if countQuote(rows, quote) == 1 {
+		return quote, true, nil
+	}
+	return quote, false, ledger.Update(ctx, idx, blankRow(quote, len(data)))
+}
+
+// reclaimQuote finishes an allocation that was interrupted after the pending
+// marker, and possibly the quote number, were passed to a reserveFunc. Like
+// allocQuote it reports whether the quote number is unique; the quote is empty
+// if the row was never appended.
+func reclaimQuote(ctx context.Context, ledger backend.QuoteLedger, scheme *NumberScheme, headers, data []string, marker, quote string) (string, bool, error) {
+	reserved, ok := parsePendingMarker(marker)
+	if !ok {
+		return "", false, fmt.Errorf("invalid pending marker %q", marker)
+	}
+	rows, err := ledger.Rows(ctx)
+	if errors.Is(err, backend.ErrNotFound) {
+		rows = nil
+	} else if err != nil {
+		return "", false, err
+	}
+	if idx := findRow(rows, marker); idx >= 0 {
+		// the row was appended but its number not written; it resolves as
+		// at the time of the reservation, as other writers counted it
+		return resolveReserved(ctx, ledger, scheme, headers, data, rows, idx, reserved, nil)
+	}
+	if quote == "" {
+		return "", false, nil
+	}
+
+	// the number was written, but maybe not verified to be unique
+	switch countQuote(rows, quote) {
+	case 0:
+		return "", false, fmt.Errorf("quote %s reserved as %s is missing from the ledger", quote, marker)
+	case 1:
+		return quote, true, nil
+	}
+	for i, row := range rows {
+		if cell(row, 0) == quote && sameData(row, data) {
+			return quote, false, ledger.Update(ctx, i, blankRow(quote, len(data)))
+		}
+	}
+	// the row lost its data already
+	return quote, false, nil
+}
+
+// blankRow returns a row of n columns holding only the quote number.
+func blankRow(quote string, n int) []string {
+	blank := make([]string, n)
+	blank[0] = quote
+	return blank
+}
+
+// sameData reports whether two rows hold the same data after the quote
+// number.
+func sameData(a, b []string) bool {
+	for i := 1; i < len(a) || i < len(b); i++ {
+		if cell(a, i) != cell(b, i) {
+			return false
+		}
+	}
+	return true
+}
+
+func newPendingMarker(now time.Time) (string, error) {
+	nonce := make([]byte, 4)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s@%s#%s", pendingQuote, now.UTC().Format(time.RFC3339), hex.EncodeToString(nonce)), nil
+}
+
+// parsePendingMarker returns the reservation time of a pending marker.
+func parsePendingMarker(s string) (time.Time, bool) {
+	if !strings.HasPrefix(s, pendingQuote+"@") {
+		return time.Time{}, false
+	}
+	s = strings.TrimPrefix(s, pendingQuote+"@")
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		s = s[:i]
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// resolveQuote returns the quote number for the row at idx. Rows that hold
+// neither a quote number of the scheme, a revision of one nor a pending marker
+// are skipped.
+func resolveQuote(scheme *NumberScheme, headers []string, rows [][]string, idx int, now time.Time) string {
+	productCol := column(headers, ColTemplate)
+	parentCol := column(headers, ColParent)
+
+	last := map[string]string{}
+	revisions := map[string]int{}
+	for _, row := range rows[:idx] {
+		q := cell(row, 0)
+		if t, ok := parsePendingMarker(q); ok {
+			if parent := cell(row, parentCol); parent != "" {
+				base, _ := SplitRevision(parent)
+				revisions[base] = nextRevision(revisions[base])
+				continue
+			}
+			product := scheme.Product(cell(row, productCol))
+			last[product] = scheme.Next(last[product], t, product)
+		} else if base, rev := SplitRevision(q); rev > 1 {
+			if rev > revisions[base] {
+				revisions[base] = rev
+			}
+		} else if n, err := scheme.Parse(q); err == nil {
+			last[n.Product] = q
+		}
+	}
+
+	if parent := cell(rows[idx], parentCol); parent != "" {
+		base, _ := SplitRevision(parent)
+		return FormatRevision(base, nextRevision(revisions[base]))
+	}
+	product := scheme.Product(cell(rows[idx], productCol))
+	return scheme.Next(last[product], now, product)
+}
+
+func cell(row []string, i int) string {
+	if i >= 0 && i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// column returns the index of the named column, or -1.
+func column(headers []string, name string) int {
+	for i, h := range headers {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func findRow(rows [][]string, quote string) int {
+	for i, row := range rows {
+		if cell(row, 0) == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func countQuote(rows [][]string, quote string) int {
+	var n int
+	for _, row := range rows {
+		if cell(row, 0) == quote {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,231 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+func TestLogQuotationConcurrent(t *testing.T) {
+	ledgers := map[string]func(t *testing.T) backend.QuoteLedger{
+		"memory": func(t *testing.T) backend.QuoteLedger {
+			return backend.NewMemory()
+		},
+		"file": func(t *testing.T) backend.QuoteLedger {
+			return backend.NewFileLedger(filepath.Join(t.TempDir(), "ledger.jsonl"))
+		},
+	}
+	for name, newLedger := range ledgers {
+		t.Run(name, func(t *testing.T) {
+			const n = 32
+			ledger := newLedger(t)
+			ctx := context.Background()
+			now := time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC)
+
+			quotes := make([]string, n)
+			errs := make([]error, n)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					row := LedgerRow("kubedb-45", map[string]string{
+						"{{email}}": fmt.Sprintf("user%d@example.com", i),
+					})
+					quotes[i], errs[i] = LogQuotation(ctx, ledger, DefaultNumberScheme(), LedgerHeaders, row, now)
+				}(i)
+			}
+			wg.Wait()
+
+			seen := map[string]int{}
+			for i, q := range quotes {
+				if errs[i] != nil {
+					t.Fatalf("LogQuotation %d: %v", i, errs[i])
+				}
+				if j, ok := seen[q]; ok {
+					t.Errorf("quote %s allocated to both %d and %d", q, j, i)
+				}
+				seen[q] = i
+			}
+
+			rows, err := ledger.Rows(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				if q := Field(row, ColQuote); strings.HasPrefix(q, pendingQuote) {
+					t.Errorf("pending marker %s left in the ledger", q)
+				}
+			}
+			for i, q := range quotes {
+				row, _, err := FindQuote(ctx, ledger, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := fmt.Sprintf("user%d@example.com", i); Field(row, ColEmail) != want {
+					t.Errorf("quote %s has email %q, want %q", q, Field(row, ColEmail), want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogQuotationProducts(t *testing.T) {
+	scheme, err := NewNumberScheme("{PRODUCT}-{SEQ:2}", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := backend.NewMemory()
+	ctx := context.Background()
+	now := time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC)
+
+	var got []string
+	for _, template := range []string{"kubedb-45", "kubedb-4", "kubedb-45", "kubedb-4", "kubedb-45"} {
+		row := LedgerRow(template, map[string]string{"{{email}}": "jane@example.com"})
+		quote, err := LogQuotation(ctx, ledger, scheme, LedgerHeaders, row, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, quote)
+	}
+	want := []string{"kubedb-45-01", "kubedb-4-01", "kubedb-45-02", "kubedb-4-02", "kubedb-45-03"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReclaimQuote(t *testing.T) {
+	ctx := context.Background()
+	// the reservation was made before the end of October
+	marker := pendingQuote + "@2024-10-31T23:59:59Z#0a1b2c3d"
+	data := func(email string) []string {
+		return LedgerRow("kubedb-45", map[string]string{"{{email}}": email})
+	}
+
+	tests := []struct {
+		name   string
+		ledger []string
+		quote  string
+		want   string
+		unique bool
+		rows   []string
+	}{
+		{
+			name:   "not appended",
+			ledger: []string{"AC2410004 john@example.org"},
+			rows:   []string{"AC2410004 john@example.org"},
+		},
+		{
+			name:   "pending",
+			ledger: []string{"AC2410004 john@example.org", marker + " jane@example.com", "AC2411001 ann@example.net"},
+			want:   "AC2410005",
+			unique: true,
+			rows:   []string{"AC2410004 john@example.org", "AC2410005 jane@example.com", "AC2411001 ann@example.net"},
+		},
+		{
+			name:   "pending with number",
+			ledger: []string{"AC2410004 john@example.org", marker + " jane@example.com"},
+			quote:  "AC2410005",
+			want:   "AC2410005",
+			unique: true,
+			rows:   []string{"AC2410004 john@example.org", "AC2410005 jane@example.com"},
+		},
+		{
+			name:   "written",
+			ledger: []string{"AC2410004 john@example.org", "AC2410005 jane@example.com"},
+			quote:  "AC2410005",
+			want:   "AC2410005",
+			unique: true,
+			rows:   []string{"AC2410004 john@example.org", "AC2410005 jane@example.com"},
+		},
+		{
+			// the other row was written outside the protocol
+			name:   "duplicate",
+			ledger: []string{"AC2410005 john@example.org", "AC2410005 jane@example.com"},
+			quote:  "AC2410005",
+			want:   "AC2410005",
+			rows:   []string{"AC2410005 john@example.org", "AC2410005 "},
+		},
+		{
+			name:   "duplicate blanked",
+			ledger: []string{"AC2410005 john@example.org", "AC2410005 "},
+			quote:  "AC2410005",
+			want:   "AC2410005",
+			rows:   []string{"AC2410005 john@example.org", "AC2410005 "},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := backend.NewMemory()
+			if err := ledger.EnsureSchema(ctx, LedgerHeaders); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range tt.ledger {
+				fields := strings.SplitN(r, " ", 2)
+				row := data(fields[1])
+				if fields[1] == "" {
+					row = blankRow("", len(LedgerHeaders))
+				}
+				row[0] = fields[0]
+				if _, err := ledger.Append(ctx, row); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			quote, unique, err := reclaimQuote(ctx, ledger, DefaultNumberScheme(), LedgerHeaders, data("jane@example.com"), marker, tt.quote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quote != tt.want || unique != tt.unique {
+				t.Errorf("got %q, unique %v, want %q, unique %v", quote, unique, tt.want, tt.unique)
+			}
+			rows, err := ledger.Rows(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range rows {
+				got = append(got, Field(row, ColQuote)+" "+Field(row, ColEmail))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.rows, "\n") {
+				t.Errorf("got ledger\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.rows, "\n"))
+			}
+		})
+	}
+}
+
+func TestReclaimQuoteErrors(t *testing.T) {
+	ctx := context.Background()
+	ledger := backend.NewMemory()
+	row := LedgerRow("kubedb-45", map[string]string{"{{email}}": "jane@example.com"})
+
+	if _, _, err := reclaimQuote(ctx, ledger, DefaultNumberScheme(), LedgerHeaders, row, "AC2410005", ""); err == nil {
+		t.Error("reclaimed a row without a pending marker")
+	}
+	marker := pendingQuote + "@2024-10-07T09:30:00Z#0a1b2c3d"
+	if _, _, err := reclaimQuote(ctx, ledger, DefaultNumberScheme(), LedgerHeaders, row, marker, "AC2410005"); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("got error %v, want a missing quote", err)
+	}
+}
@@ -0,0 +1,341 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// Placeholders of the line-item row of a template table. The row holding them
+// is repeated once per line item.
+const (
+	ItemNo          = "{{item.no}}"
+	ItemProduct     = "{{item.product}}"
+	ItemDescription = "{{item.description}}"
+	ItemQuantity    = "{{item.quantity}}"
+	ItemUnitPrice   = "{{item.unit-price}}"
+	ItemAmount      = "{{item.amount}}"
+	ItemTerm        = "{{item.term}}"
+)
+
+// itemPrefix starts every line-item placeholder.
+const itemPrefix = "{{item."
+
+// LineItem is a product offered in a quote. Items naming a SKU are priced from
+// the pricing catalog; the others need a product and unit price.
+type LineItem struct {
+	SKU         string  `json:"sku,omitempty"`
+	Product     string  `json:"product,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Quantity    float64 `json:"quantity"`
+	UnitPrice   float64 `json:"unitPrice,omitempty"`
+	// Term and Periods select the catalog price of a SKU, e.g. 2 periods of
+	// the annual term. Defaults to one year.
+	Term    Term `json:"term,omitempty"`
+	Periods int  `json:"periods,omitempty"`
+}
+
+// Amount returns the quantity times the unit price, rounded to cents.
+func (li LineItem) Amount() float64 {
+	return roundCents(li.Quantity * li.UnitPrice)
+}
+
+// LineItems are the products of a quote with an optional discount.
+type LineItems struct {
+	// Currency is the ISO 4217 code of the amounts. Defaults to the currency
+	// of the pricing catalog.
+	Currency string     `json:"currency,omitempty"`
+	Items    []LineItem `json:"items"`
+	// Discount is the percentage taken off the subtotal.
+	Discount float64 `json:"discount,omitempty"`
+}
+
+// Validate checks that every item names a product with a positive quantity and
+// a non-negative price.
+func (l LineItems) Validate() error {
+	var errs []string
+	for i, li := range l.Items {
+		if strings.TrimSpace(li.Product) == "" {
+			errs = append(errs, fmt.Sprintf("line item %d: missing product", i+1))
+		}
+		if li.Quantity <= 0 {
+			errs = append(errs, fmt.Sprintf("line item %d: quantity must be positive", i+1))
+		}
+		if li.UnitPrice < 0 {
+			errs = append(errs, fmt.Sprintf("line item %d: unit price must not be negative", i+1))
+		}
+	}
+	if l.Discount < 0 || l.Discount > 100 {
+		errs = append(errs, "discount must be a percentage between 0 and 100")
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Subtotal returns the sum of the item amounts.
+func (l LineItems) Subtotal() float64 {
+	var sum float64
+	for _, li := range l.Items {
+		sum += li.Amount()
+	}
+	return roundCents(sum)
+}
+
+// DiscountAmount returns the amount taken off the subtotal.
+func (l LineItems) DiscountAmount() float64 {
+	return roundCents(l.Subtotal() * l.Discount / 100)
+}
+
+// Total returns the subtotal less the discount.
+func (l LineItems) Total() float64 {
+	return roundCents(l.Subtotal() - l.DiscountAmount())
+}
+
+// Replacements returns the values of the {{currency}}, {{subtotal}},
+// {{discount}}, {{discount-percent}} and {{total}} placeholders.
+func (l LineItems) Replacements() map[string]string {
+	return map[string]string{
+		"{{currency}}":         l.Currency,
+		"{{subtotal}}":         FormatAmount(l.Subtotal()),
+		"{{discount}}":         FormatAmount(l.DiscountAmount()),
+		"{{discount-percent}}": formatNumber(l.Discount),
+		"{{total}}":            FormatAmount(l.Total()),
+	}
+}
+
+// ledgerFields records the currency and amounts in a quotation log row.
+// Amounts are written without thousands separators so that spreadsheets
+// can read them as numbers.
+func (l LineItems) ledgerFields(row []string) []string {
+	row = SetField(row, ColCurrency, l.Currency)
+	row = SetField(row, ColSubtotal, formatCents(l.Subtotal()))
+	row = SetField(row, ColDiscount, formatCents(l.DiscountAmount()))
+	row = SetField(row, ColTotal, formatCents(l.Total()))
+	return row
+}
+
+func (li LineItem) replacements(no int) map[string]string {
+	return map[string]string{
+		ItemNo:          strconv.Itoa(no),
+		ItemProduct:     li.Product,
+		ItemDescription: li.Description,
+		ItemQuantity:    formatNumber(li.Quantity),
+		ItemUnitPrice:   FormatAmount(li.UnitPrice),
+		ItemAmount:      FormatAmount(li.Amount()),
+		ItemTerm:        li.TermDescription(),
+	}
+}
+
+// roundCents rounds half a cent away from zero. The amount is first rounded
+// to a millionth, so that products like 1.5 * 99.99 = 149.98499999999999 are
+// rounded as the 149.985 they stand for.
+func roundCents(v float64) float64 {
+	return math.Round(math.Round(v*1e6)/1e4) / 100
+}
+
+// FormatAmount formats an amount with two decimals and thousands separators,
+// e.g. 1,234.50.
+func FormatAmount(v float64) string {
+	s := strconv.FormatFloat(math.Abs(roundCents(v)), 'f', 2, 64)
+	intPart, frac := s[:len(s)-3], s[len(s)-3:]
+
+	var sb strings.Builder
+	if v < 0 && s != "0.00" {
+		sb.WriteByte('-')
+	}
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(c)
+	}
+	sb.WriteString(frac)
+	return sb.String()
+}
+
+func formatCents(v float64) string {
+	return strconv.FormatFloat(roundCents(v), 'f', 2, 64)
+}
+
+// formatNumber formats quantities and percentages without trailing zeros.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// itemRow locates the line-item row of a template table.
+type itemRow struct {
+	tableStart int64
+	row        int
+	// cells holds the text of each cell of the template row.
+	cells []string
+}
+
+// findItemRow returns the first table row of the document body with a
+// line-item placeholder, or nil.
+func findItemRow(content []*docs.StructuralElement) *itemRow {
+	for _, se := range content {
+		if se.Table == nil {
+			continue
+		}
+		for r, row := range se.Table.TableRows {
+			cells := make([]string, len(row.TableCells))
+			found := false
+			for c, cell := range row.TableCells {
+				cells[c] = cellText(cell)
+				if strings.Contains(cells[c], itemPrefix) {
+					found = true
+				}
+			}
+			if found {
+				return &itemRow{tableStart: se.StartIndex, row: r, cells: cells}
+			}
+			for _, cell := range row.TableCells {
+				if ir := findItemRow(cell.Content); ir != nil {
+					return ir
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cellText returns the text of the paragraphs of a table cell without the
+// final newline.
+func cellText(cell *docs.TableCell) string {
+	var sb strings.Builder
+	for _, se := range cell.Content {
+		if se.Paragraph != nil {
+			sb.WriteString(backend.ParagraphText(se.Paragraph))
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func findTableAt(content []*docs.StructuralElement, start int64) *docs.Table {
+	for _, se := range content {
+		if se.Table == nil {
+			continue
+		}
+		if se.StartIndex == start {
+			return se.Table
+		}
+		for _, row := range se.Table.TableRows {
+			for _, cell := range row.TableCells {
+				if t := findTableAt(cell.Content, start); t != nil {
+					return t
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renderLineItems repeats the line-item row of the document's table once per
+// item and fills in the item placeholders.
+//
+// Rows are inserted below the template row first. Then the text of every cell
+// is replaced from the last cell to the first, so that the indexes of the cells
+// not yet written do not move.
+func renderLineItems(ctx context.Context, store backend.DocumentStore, docID string, items []LineItem) error {
+	doc, err := store.GetDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	ir := findItemRow(doc.Body.Content)
+	if ir == nil {
+		return errors.New("template has no table row with line-item placeholders")
+	}
+
+	if len(items) > 1 {
+		reqs := make([]*docs.Request, 0, len(items)-1)
+		for i := 1; i < len(items); i++ {
+			reqs = append(reqs, &docs.Request{
+				InsertTableRow: &docs.InsertTableRowRequest{
+					TableCellLocation: &docs.TableCellLocation{
+						TableStartLocation: &docs.Location{Index: ir.tableStart},
+						RowIndex:           int64(ir.row),
+					},
+					InsertBelow: true,
+				},
+			})
+		}
+		if _, err = store.BatchUpdate(ctx, docID, reqs); err != nil {
+			return fmt.Errorf("unable to insert line-item rows: %v", err)
+		}
+		doc, err = store.GetDocument(ctx, docID)
+		if err != nil {
+			return err
+		}
+	}
+	table := findTableAt(doc.Body.Content, ir.tableStart)
+	if table == nil || len(table.TableRows) < ir.row+len(items) {
+		return errors.New("line-item rows are missing after inserting them")
+	}
+
+	var reqs []*docs.Request
+	for i := len(items) - 1; i >= 0; i-- {
+		row := table.TableRows[ir.row+i]
+		values := items[i].replacements(i + 1)
+		for c := len(row.TableCells) - 1; c >= 0; c-- {
+			content := row.TableCells[c].Content
+			if len(content) == 0 || c >= len(ir.cells) {
+				continue
+			}
+			start, end := content[0].StartIndex, content[len(content)-1].EndIndex-1
+			if end > start {
+				reqs = append(reqs, &docs.Request{
+					DeleteContentRange: &docs.DeleteContentRangeRequest{
+						Range: &docs.Range{StartIndex: start, EndIndex: end},
+					},
+				})
+			}
+			if text := renderItemText(ir.cells[c], values); text != "" {
+				reqs = append(reqs, &docs.Request{
+					InsertText: &docs.InsertTextRequest{
+						Location: &docs.Location{Index: start},
+						Text:     text,
+					},
+				})
+			}
+		}
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	if _, err = store.BatchUpdate(ctx, docID, reqs); err != nil {
+		return fmt.Errorf("unable to fill in line items: %v", err)
+	}
+	return nil
+}
+
+func renderItemText(text string, values map[string]string) string {
+	for k, v := range values {
+		text = strings.ReplaceAll(text, k, v)
+	}
+	return text
+}
@@ -0,0 +1,302 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+
+	"google.golang.org/api/docs/v1"
+)
+
+const itemsTemplate = "Quote #{{quote}} for {{name}} <{{email}}>\n" +
+	"| No | Product | Qty | Unit price | Amount |\n" +
+	"| {{item.no}} | {{item.product}} ({{item.term}}) | {{item.quantity}} | {{item.unit-price}} | {{item.amount}} |\n" +
+	"| | | | Total | {{total}} |\n" +
+	"Subtotal {{currency}} {{subtotal}}, discount {{discount-percent}}% {{discount}}\n"
+
+// tableRows returns the cell texts of the rows of the first table of doc.
+func tableRows(doc *docs.Document) [][]string {
+	for _, se := range doc.Body.Content {
+		if se.Table == nil {
+			continue
+		}
+		var rows [][]string
+		for _, row := range se.Table.TableRows {
+			var cells []string
+			for _, cell := range row.TableCells {
+				cells = append(cells, cellText(cell))
+			}
+			rows = append(rows, cells)
+		}
+		return rows
+	}
+	return nil
+}
+
+func TestRenderLineItems(t *testing.T) {
+	items := []LineItem{
+		{Product: "KubeDB Enterprise", Term: TermAnnual, Periods: 2, Quantity: 2, UnitPrice: 1500},
+		{Product: "Stash", Term: TermOnDemand, Periods: 1, Quantity: 1.5, UnitPrice: 99.99},
+		{Product: "Support", Quantity: 3, UnitPrice: 0.335},
+	}
+	tests := []struct {
+		name  string
+		items []LineItem
+		want  [][]string
+	}{
+		{
+			name:  "one item",
+			items: items[:1],
+			want: [][]string{
+				{"No", "Product", "Qty", "Unit price", "Amount"},
+				{"1", "KubeDB Enterprise (annual, 2 years)", "2", "1,500.00", "3,000.00"},
+				{"", "", "", "Total", "{{total}}"},
+			},
+		},
+		{
+			name:  "many items",
+			items: items,
+			want: [][]string{
+				{"No", "Product", "Qty", "Unit price", "Amount"},
+				{"1", "KubeDB Enterprise (annual, 2 years)", "2", "1,500.00", "3,000.00"},
+				{"2", "Stash (on-demand, 1 month)", "1.5", "99.99", "149.99"},
+				{"3", "Support ()", "3", "0.34", "1.01"},
+				{"", "", "", "Total", "{{total}}"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := backend.NewMemory()
+			mem.PutDocument("doc", backend.NewTextDocument("Quote", itemsTemplate))
+			if err := renderLineItems(context.Background(), mem, "doc", tt.items); err != nil {
+				t.Fatal(err)
+			}
+			doc := mem.Document("doc")
+			got := tableRows(doc)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d rows, want %d: %q", len(got), len(tt.want), got)
+			}
+			for i := range tt.want {
+				if strings.Join(got[i], "|") != strings.Join(tt.want[i], "|") {
+					t.Errorf("row %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+			if text := backend.PlainText(doc); !strings.HasPrefix(text, "Quote #{{quote}}") || !strings.HasSuffix(text, "{{discount}}\n") {
+				t.Errorf("text around the table changed:\n%s", text)
+			}
+		})
+	}
+}
+
+func TestRenderLineItemsWithoutRow(t *testing.T) {
+	mem := backend.NewMemory()
+	mem.PutDocument("doc", backend.NewTextDocument("Quote", "| Product | Amount |\n| {{product}} | {{amount}} |\n"))
+	err := renderLineItems(context.Background(), mem, "doc", []LineItem{{Product: "Stash", Quantity: 1}})
+	if err == nil || !strings.Contains(err.Error(), "no table row with line-item placeholders") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestLineItemsAmounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		items LineItems
+		want  map[string]string
+	}{
+		{
+			name:  "no discount",
+			items: LineItems{Currency: "USD", Items: []LineItem{{Quantity: 2, UnitPrice: 1500}, {Quantity: 1, UnitPrice: 0.5}}},
+			want: map[string]string{
+				"{{currency}}":         "USD",
+				"{{subtotal}}":         "3,000.50",
+				"{{discount}}":         "0.00",
+				"{{discount-percent}}": "0",
+				"{{total}}":            "3,000.50",
+			},
+		},
+		{
+			name:  "discount",
+			items: LineItems{Currency: "EUR", Items: []LineItem{{Quantity: 4, UnitPrice: 250}}, Discount: 12.5},
+			want: map[string]string{
+				"{{currency}}":         "EUR",
+				"{{subtotal}}":         "1,000.00",
+				"{{discount}}":         "125.00",
+				"{{discount-percent}}": "12.5",
+				"{{total}}":            "875.00",
+			},
+		},
+		{
+			// amounts are rounded per item before they are summed, and the
+			// discount is rounded before it is taken off
+			name:  "rounding",
+			items: LineItems{Items: []LineItem{{Quantity: 3, UnitPrice: 33.333}, {Quantity: 1.5, UnitPrice: 0.99}}, Discount: 10},
+			want: map[string]string{
+				"{{currency}}":         "",
+				"{{subtotal}}":         "101.49",
+				"{{discount}}":         "10.15",
+				"{{discount-percent}}": "10",
+				"{{total}}":            "91.34",
+			},
+		},
+		{
+			name:  "millions",
+			items: LineItems{Items: []LineItem{{Quantity: 1000, UnitPrice: 1234.567}}, Discount: 100},
+			want: map[string]string{
+				"{{currency}}":         "",
+				"{{subtotal}}":         "1,234,567.00",
+				"{{discount}}":         "1,234,567.00",
+				"{{discount-percent}}": "100",
+				"{{total}}":            "0.00",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.items.Replacements()
+			for k, want := range tt.want {
+				if got[k] != want {
+					t.Errorf("%s = %q, want %q", k, got[k], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLineItemsLedgerFields(t *testing.T) {
+	items := LineItems{Currency: "USD", Items: []LineItem{{Quantity: 1000, UnitPrice: 1234.565}}, Discount: 5}
+	row := items.ledgerFields(nil)
+	for col, want := range map[string]string{
+		ColCurrency: "USD",
+		ColSubtotal: "1234565.00",
+		ColDiscount: "61728.25",
+		ColTotal:    "1172836.75",
+	} {
+		if got := Field(row, col); got != want {
+			t.Errorf("%s = %q, want %q", col, got, want)
+		}
+	}
+}
+
+func TestLineItemsValidate(t *testing.T) {
+	tests := []struct {
+		items LineItems
+		err   string
+	}{
+		{LineItems{Items: []LineItem{{Product: "Stash", Quantity: 1}}}, ""},
+		{LineItems{Items: []LineItem{{Product: " ", Quantity: 1}}}, "line item 1: missing product"},
+		{LineItems{Items: []LineItem{{Product: "Stash", Quantity: 1}, {Product: "Stash"}}}, "line item 2: quantity must be positive"},
+		{LineItems{Items: []LineItem{{Product: "Stash", Quantity: 1, UnitPrice: -1}}}, "line item 1: unit price must not be negative"},
+		{LineItems{Discount: 101}, "discount must be a percentage between 0 and 100"},
+	}
+	for _, tt := range tests {
+		err := tt.items.Validate()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("Validate(%+v) = %v, want %q", tt.items, err, tt.err)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	for v, want := range map[float64]string{
+		0:          "0.00",
+		-0.001:     "0.00",
+		0.5:        "0.50",
+		1.005:      "1.01",
+		149.985:    "149.99",
+		999.999:    "1,000.00",
+		1234.5:     "1,234.50",
+		-1234.5:    "-1,234.50",
+		123456789:  "123,456,789.00",
+		1000000.01: "1,000,000.01",
+	} {
+		if got := FormatAmount(v); got != want {
+			t.Errorf("FormatAmount(%v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestGenerateLineItems(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+	store.PutDocument("items", backend.NewTextDocument("Template", itemsTemplate))
+	g.Templates["items"] = "items"
+	g.Catalog = &Catalog{
+		Currency: "USD",
+		Products: []Product{{
+			SKU:     "kubedb-enterprise",
+			Name:    "KubeDB",
+			Edition: "Enterprise",
+			Prices:  map[Term]float64{TermAnnual: 1999.99, TermOnDemand: 199.995},
+		}},
+	}
+
+	req := testRequest()
+	req.Template = "items"
+	req.LineItems = LineItems{
+		Items: []LineItem{
+			{SKU: "kubedb-enterprise", Quantity: 3, Periods: 2},
+			{SKU: "kubedb-enterprise", Quantity: 1, Term: TermOnDemand, Periods: 3},
+			{Product: "Onboarding", Quantity: 1, UnitPrice: 500},
+		},
+		Discount: 7.5,
+	}
+	result, err := g.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := store.Document(result.DocID)
+	want := [][]string{
+		{"No", "Product", "Qty", "Unit price", "Amount"},
+		{"1", "KubeDB Enterprise (annual, 2 years)", "3", "3,999.98", "11,999.94"},
+		{"2", "KubeDB Enterprise (on-demand, 3 months)", "1", "599.99", "599.99"},
+		{"3", "Onboarding ()", "1", "500.00", "500.00"},
+		{"", "", "", "Total", "12,117.44"},
+	}
+	got := tableRows(doc)
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d: %q", len(got), len(want), got)
+	}
+	for i := range want {
+		if strings.Join(got[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("row %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if text := backend.PlainText(doc); !strings.Contains(text, "Subtotal USD 13,099.93, discount 7.5% 982.49\n") {
+		t.Errorf("document misses the totals:\n%s", text)
+	}
+
+	row, _, err := FindQuote(ctx, store, result.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for col, want := range map[string]string{
+		ColCurrency: "USD",
+		ColSubtotal: "13099.93",
+		ColDiscount: "982.49",
+		ColTotal:    "12117.44",
+	} {
+		if got := Field(row, col); got != want {
+			t.Errorf("%s = %q, want %q", col, got, want)
+		}
+	}
+}
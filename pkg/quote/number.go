@@ -0,0 +1,335 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultNumberPattern is the pattern of quote numbers like AC2410007.
+const DefaultNumberPattern = "AC{YY}{MM}{SEQ:3}"
+
+// NumberScheme formats and parses quote numbers following a pattern. A pattern
+// is literal text with these tokens:
+//
+//	{YYYY}, {YY}    calendar year with 4 or 2 digits
+//	{FYYYY}, {FYY}  fiscal year with 4 or 2 digits, named by the calendar year
+//	                it ends in
+//	{MM}            month
+//	{PRODUCT}       product code; each product gets its own counter. It must
+//	                be followed by a separator like -, as codes may end in
+//	                digits
+//	{SEQ:n}         serial number, zero padded to at least n digits
+//
+// The serial restarts at 1 whenever the period formed by the date tokens moves
+// forward, e.g. every month for the default pattern AC{YY}{MM}{SEQ:3}.
+type NumberScheme struct {
+	pattern string
+	// fiscalYearStart is the first month of the fiscal year.
+	fiscalYearStart time.Month
+	// products maps template names to product codes for {PRODUCT}.
+	products map[string]string
+
+	tokens     []numberToken
+	re         *regexp.Regexp
+	hasProduct bool
+}
+
+type numberToken struct {
+	kind    string
+	literal string
+	width   int
+}
+
+const (
+	tokenLiteral  = "literal"
+	tokenYYYY     = "YYYY"
+	tokenYY       = "YY"
+	tokenFYYYY    = "FYYYY"
+	tokenFYY      = "FYY"
+	tokenMM       = "MM"
+	tokenProduct  = "PRODUCT"
+	tokenSequence = "SEQ"
+)
+
+// Number is a parsed quote number.
+type Number struct {
+	Year       int
+	FiscalYear int
+	Month      int
+	Product    string
+	Serial     int
+}
+
+var (
+	numberTokenRegex = regexp.MustCompile(`\{([A-Z]+)(?::(\d+))?\}`)
+	productCodeRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// NewNumberScheme compiles pattern. fiscalYearStart is the first month of the
+// fiscal year and defaults to January. products maps template names to the
+// product codes used for {PRODUCT}; templates without a code use their name.
+func NewNumberScheme(pattern string, fiscalYearStart time.Month, products map[string]string) (*NumberScheme, error) {
+	if fiscalYearStart == 0 {
+		fiscalYearStart = time.January
+	}
+	if fiscalYearStart < time.January || fiscalYearStart > time.December {
+		return nil, fmt.Errorf("invalid fiscal year start month %d", fiscalYearStart)
+	}
+	s := &NumberScheme{
+		pattern:         pattern,
+		fiscalYearStart: fiscalYearStart,
+		products:        products,
+	}
+
+	for template, code := range products {
+		if !productCodeRegex.MatchString(code) {
+			return nil, fmt.Errorf("invalid product code %q of template %s, must only contain letters, digits, - and _", code, template)
+		}
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	var seq int
+	last := 0
+	for _, m := range numberTokenRegex.FindAllStringSubmatchIndex(pattern, -1) {
+		if m[0] > last {
+			lit := pattern[last:m[0]]
+			if err := s.checkAfterProduct(lit); err != nil {
+				return nil, err
+			}
+			s.tokens = append(s.tokens, numberToken{kind: tokenLiteral, literal: lit})
+			expr.WriteString(regexp.QuoteMeta(lit))
+		} else if err := s.checkAfterProduct(pattern[m[0]:m[1]]); err != nil {
+			return nil, err
+		}
+		last = m[1]
+
+		t := numberToken{kind: pattern[m[2]:m[3]]}
+		if m[4] >= 0 {
+			t.width, _ = strconv.Atoi(pattern[m[4]:m[5]])
+		}
+		switch t.kind {
+		case tokenYYYY, tokenFYYYY:
+			expr.WriteString(`(\d{4})`)
+		case tokenYY, tokenFYY, tokenMM:
+			expr.WriteString(`(\d{2})`)
+		case tokenProduct:
+			s.hasProduct = true
+			expr.WriteString(`([A-Za-z0-9_-]+?)`)
+		case tokenSequence:
+			seq++
+			if t.width < 1 {
+				t.width = 1
+			}
+			fmt.Fprintf(&expr, `(\d{%d,})`, t.width)
+		default:
+			return nil, fmt.Errorf("unknown token {%s} in quote number pattern %s", t.kind, pattern)
+		}
+		if t.kind != tokenSequence && m[4] >= 0 {
+			return nil, fmt.Errorf("token {%s} does not take a width in quote number pattern %s", t.kind, pattern)
+		}
+		s.tokens = append(s.tokens, t)
+	}
+	if last < len(pattern) {
+		lit := pattern[last:]
+		if err := s.checkAfterProduct(lit); err != nil {
+			return nil, err
+		}
+		s.tokens = append(s.tokens, numberToken{kind: tokenLiteral, literal: lit})
+		expr.WriteString(regexp.QuoteMeta(lit))
+	}
+	expr.WriteString("$")
+	if seq != 1 {
+		return nil, fmt.Errorf("quote number pattern %s must contain exactly one {SEQ} token", pattern)
+	}
+
+	var err error
+	s.re, err = regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkAfterProduct fails if next follows {PRODUCT} in the pattern and starts
+// with a character product codes can end in, since such numbers can not be
+// split into product and the rest, e.g. kubedb-4501 for {PRODUCT}{SEQ:2}.
+func (s *NumberScheme) checkAfterProduct(next string) error {
+	if len(s.tokens) == 0 || s.tokens[len(s.tokens)-1].kind != tokenProduct {
+		return nil
+	}
+	if c := next[0]; c == '{' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+		return fmt.Errorf("{PRODUCT} must be followed by a separator like - in quote number pattern %s", s.pattern)
+	}
+	return nil
+}
+
+// DefaultNumberScheme returns the scheme of quote numbers like AC2410007.
+func DefaultNumberScheme() *NumberScheme {
+	s, err := NewNumberScheme(DefaultNumberPattern, time.January, nil)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *NumberScheme) String() string {
+	return s.pattern
+}
+
+// Product returns the product code for a template. It is empty if the pattern
+// has no {PRODUCT} token, so that all quotes share one counter.
+func (s *NumberScheme) Product(template string) string {
+	if !s.hasProduct {
+		return ""
+	}
+	if code, ok := s.products[template]; ok {
+		return code
+	}
+	return template
+}
+
+// fiscalYear returns the fiscal year t falls in.
+func (s *NumberScheme) fiscalYear(t time.Time) int {
+	if s.fiscalYearStart == time.January || t.Month() < s.fiscalYearStart {
+		return t.Year()
+	}
+	return t.Year() + 1
+}
+
+// At returns the number with the given serial for a quote made at time t.
+func (s *NumberScheme) At(t time.Time, product string, serial int) Number {
+	t = t.UTC()
+	return Number{
+		Year:       t.Year(),
+		FiscalYear: s.fiscalYear(t),
+		Month:      int(t.Month()),
+		Product:    product,
+		Serial:     serial,
+	}
+}
+
+// Format returns the quote number for n.
+func (s *NumberScheme) Format(n Number) string {
+	var sb strings.Builder
+	for _, t := range s.tokens {
+		switch t.kind {
+		case tokenLiteral:
+			sb.WriteString(t.literal)
+		case tokenYYYY:
+			fmt.Fprintf(&sb, "%04d", n.Year)
+		case tokenYY:
+			fmt.Fprintf(&sb, "%02d", n.Year%100)
+		case tokenFYYYY:
+			fmt.Fprintf(&sb, "%04d", n.FiscalYear)
+		case tokenFYY:
+			fmt.Fprintf(&sb, "%02d", n.FiscalYear%100)
+		case tokenMM:
+			fmt.Fprintf(&sb, "%02d", n.Month)
+		case tokenProduct:
+			sb.WriteString(n.Product)
+		case tokenSequence:
+			fmt.Fprintf(&sb, "%0*d", t.width, n.Serial)
+		}
+	}
+	return sb.String()
+}
+
+// Parse parses a quote number. Fields without a token in the pattern are zero.
+func (s *NumberScheme) Parse(quote string) (Number, error) {
+	var n Number
+	m := s.re.FindStringSubmatch(quote)
+	if m == nil {
+		return n, fmt.Errorf("quote %s does not match pattern %s", quote, s.pattern)
+	}
+	i := 1
+	for _, t := range s.tokens {
+		if t.kind == tokenLiteral {
+			continue
+		}
+		v := m[i]
+		i++
+		if t.kind == tokenProduct {
+			n.Product = v
+			continue
+		}
+		d, err := strconv.Atoi(v)
+		if err != nil {
+			return n, fmt.Errorf("failed to detect %s from quote %s", t.kind, quote)
+		}
+		switch t.kind {
+		case tokenYYYY:
+			n.Year = d
+		case tokenYY:
+			n.Year = 2000 + d
+		case tokenFYYYY:
+			n.FiscalYear = d
+		case tokenFYY:
+			n.FiscalYear = 2000 + d
+		case tokenMM:
+			if d < 1 || d > 12 {
+				return n, fmt.Errorf("failed to detect MM from quote %s", quote)
+			}
+			n.Month = d
+		case tokenSequence:
+			n.Serial = d
+		}
+	}
+	return n, nil
+}
+
+// period returns the counter period of n as a comparable number, considering
+// only the date tokens present in the pattern.
+func (s *NumberScheme) period(n Number) int {
+	var p int
+	for _, t := range s.tokens {
+		switch t.kind {
+		case tokenYYYY, tokenYY:
+			p += n.Year * 100
+		case tokenFYYYY, tokenFYY:
+			p += n.FiscalYear * 100
+		case tokenMM:
+			p += n.Month
+		}
+	}
+	return p
+}
+
+// Next returns the quote number following last for a quote of product made at
+// time now. last is the latest quote number with the same product, or empty.
+// The serial continues unless now is in a later period than last, so that
+// clock skew between writers never makes numbers go back in time.
+func (s *NumberScheme) Next(last string, now time.Time, product string) string {
+	next := s.At(now, product, 1)
+	if last == "" {
+		return s.Format(next)
+	}
+	n, err := s.Parse(last)
+	if err != nil || n.Product != product {
+		return s.Format(next)
+	}
+	if s.period(n) >= s.period(next) {
+		n.Serial++
+		return s.Format(n)
+	}
+	return s.Format(next)
+}
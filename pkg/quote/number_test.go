@@ -0,0 +1,183 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 12, 0, 0, 0, time.UTC)
+}
+
+func TestNumberSchemeTokens(t *testing.T) {
+	now := date(2024, time.October, 7)
+	tests := []struct {
+		pattern  string
+		fyStart  time.Month
+		products map[string]string
+		template string
+		want     string
+		parsed   Number
+	}{
+		{"AC{YY}{MM}{SEQ:3}", 0, nil, "kubedb", "AC2410007", Number{Year: 2024, Month: 10, Serial: 7}},
+		{"Q-{YYYY}-{SEQ:4}", 0, nil, "kubedb", "Q-2024-0007", Number{Year: 2024, Serial: 7}},
+		{"FY{FYYYY}/{SEQ}", time.April, nil, "kubedb", "FY2025/7", Number{FiscalYear: 2025, Serial: 7}},
+		{"FY{FYY}-{SEQ:2}", time.November, nil, "kubedb", "FY24-07", Number{FiscalYear: 2024, Serial: 7}},
+		{"{PRODUCT}-{YY}{SEQ:3}", 0, map[string]string{"kubedb-45": "KDB"}, "kubedb-45", "KDB-24007", Number{Year: 2024, Product: "KDB", Serial: 7}},
+		{"{PRODUCT}-{SEQ:2}", 0, nil, "kubedb-45", "kubedb-45-07", Number{Product: "kubedb-45", Serial: 7}},
+		{"{MM}{SEQ:1}", 0, nil, "kubedb", "107", Number{Month: 10, Serial: 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			s, err := NewNumberScheme(tt.pattern, tt.fyStart, tt.products)
+			if err != nil {
+				t.Fatal(err)
+			}
+			product := s.Product(tt.template)
+			got := s.Format(s.At(now, product, 7))
+			if got != tt.want {
+				t.Errorf("Format = %s, want %s", got, tt.want)
+			}
+			n, err := s.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.parsed {
+				t.Errorf("Parse(%s) = %+v, want %+v", got, n, tt.parsed)
+			}
+		})
+	}
+}
+
+func TestNumberSchemeSerialWidth(t *testing.T) {
+	s := DefaultNumberScheme()
+	now := date(2024, time.October, 7)
+	if got := s.Next("AC2410999", now, ""); got != "AC24101000" {
+		t.Errorf("Next after AC2410999 = %s, want AC24101000", got)
+	}
+	if n, err := s.Parse("AC24101000"); err != nil || n.Serial != 1000 {
+		t.Errorf("Parse(AC24101000) = %+v, %v", n, err)
+	}
+}
+
+func TestNumberSchemeNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		fyStart time.Month
+		last    string
+		now     time.Time
+		want    string
+	}{
+		{"first", "AC{YY}{MM}{SEQ:3}", 0, "", date(2024, time.October, 7), "AC2410001"},
+		{"same month", "AC{YY}{MM}{SEQ:3}", 0, "AC2410007", date(2024, time.October, 31), "AC2410008"},
+		{"next month", "AC{YY}{MM}{SEQ:3}", 0, "AC2410007", date(2024, time.November, 1), "AC2411001"},
+		{"next year", "AC{YY}{MM}{SEQ:3}", 0, "AC2412007", date(2025, time.January, 1), "AC2501001"},
+		{"clock skew", "AC{YY}{MM}{SEQ:3}", 0, "AC2411007", date(2024, time.October, 31), "AC2411008"},
+		{"same year", "Q{YYYY}-{SEQ:3}", 0, "Q2024-041", date(2024, time.December, 31), "Q2024-042"},
+		{"year rollover", "Q{YYYY}-{SEQ:3}", 0, "Q2024-041", date(2025, time.January, 1), "Q2025-001"},
+		{"same fiscal year", "FY{FYY}-{SEQ:3}", time.April, "FY25-041", date(2025, time.March, 31), "FY25-042"},
+		{"fiscal year rollover", "FY{FYY}-{SEQ:3}", time.April, "FY25-041", date(2025, time.April, 1), "FY26-001"},
+		{"no period", "Q-{SEQ:5}", 0, "Q-00041", date(2030, time.January, 1), "Q-00042"},
+		{"unparsable last", "AC{YY}{MM}{SEQ:3}", 0, "legacy-7", date(2024, time.October, 7), "AC2410001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewNumberScheme(tt.pattern, tt.fyStart, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tt.last, tt.now, ""); got != tt.want {
+				t.Errorf("Next(%q) = %s, want %s", tt.last, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumberSchemeProducts(t *testing.T) {
+	s, err := NewNumberScheme("{PRODUCT}-{YY}{MM}{SEQ:2}", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := date(2024, time.October, 7)
+	for _, product := range []string{"kubedb", "kubedb-45", "kubedb-4", "stash-100"} {
+		first := s.Next("", now, product)
+		second := s.Next(first, now, product)
+		if first == second {
+			t.Fatalf("%s: Next returned %s twice", product, first)
+		}
+		n, err := s.Parse(second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.Product != product || n.Serial != 2 {
+			t.Errorf("Parse(%s) = %+v, want product %s serial 2", second, n, product)
+		}
+	}
+	// the serials of other products are not continued
+	if got := s.Next("kubedb-4-241007", now, "kubedb-45"); got != "kubedb-45-241001" {
+		t.Errorf("Next for another product = %s, want kubedb-45-241001", got)
+	}
+
+	plain := DefaultNumberScheme()
+	if p := plain.Product("kubedb-45"); p != "" {
+		t.Errorf("Product without {PRODUCT} = %q, want empty", p)
+	}
+}
+
+func TestNewNumberSchemeErrors(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		fyStart  time.Month
+		products map[string]string
+		err      string
+	}{
+		{"AC{YY}{MM}", 0, nil, "exactly one {SEQ}"},
+		{"{SEQ}{SEQ}", 0, nil, "exactly one {SEQ}"},
+		{"AC{DD}{SEQ}", 0, nil, "unknown token {DD}"},
+		{"AC{YY:2}{SEQ}", 0, nil, "does not take a width"},
+		{"AC{SEQ}", 13, nil, "invalid fiscal year start"},
+		{"{PRODUCT}{SEQ:4}", 0, nil, "must be followed by a separator"},
+		{"{PRODUCT}{YY}-{SEQ}", 0, nil, "must be followed by a separator"},
+		{"{PRODUCT}x{SEQ}", 0, nil, "must be followed by a separator"},
+		{"{PRODUCT}7{SEQ}", 0, nil, "must be followed by a separator"},
+		{"{PRODUCT}-{SEQ}", 0, map[string]string{"kubedb": "KUBE DB"}, "invalid product code"},
+	}
+	for _, tt := range tests {
+		_, err := NewNumberScheme(tt.pattern, tt.fyStart, tt.products)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("NewNumberScheme(%s) = %v, want %q", tt.pattern, err, tt.err)
+		}
+	}
+	for _, pattern := range []string{"{PRODUCT}-{SEQ}", "{PRODUCT}_{YY}{SEQ}", "Q{SEQ}{PRODUCT}", "{YY}/{PRODUCT}/{SEQ:3}"} {
+		if _, err := NewNumberScheme(pattern, 0, nil); err != nil {
+			t.Errorf("NewNumberScheme(%s): %v", pattern, err)
+		}
+	}
+}
+
+func TestNumberSchemeParseErrors(t *testing.T) {
+	s := DefaultNumberScheme()
+	for _, q := range []string{"", "AC24100", "AC2413001", "XX2410001", "AC2410001-R1"} {
+		if _, err := s.Parse(q); err == nil {
+			t.Errorf("Parse(%q) succeeded", q)
+		}
+	}
+}
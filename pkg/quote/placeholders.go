@@ -0,0 +1,104 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// PlaceholderCheck selects what Generate does when the placeholders of a
+// template and the supplied data do not match.
+type PlaceholderCheck string
+
+const (
+	PlaceholderCheckIgnore PlaceholderCheck = "ignore"
+	PlaceholderCheckWarn   PlaceholderCheck = "warn"
+	PlaceholderCheckError  PlaceholderCheck = "error"
+)
+
+// Validate checks that c is ignore, warn or error. Empty means warn.
+func (c PlaceholderCheck) Validate() error {
+	switch c {
+	case "", PlaceholderCheckIgnore, PlaceholderCheckWarn, PlaceholderCheckError:
+		return nil
+	}
+	return fmt.Errorf("invalid placeholder check %q, must be %s, %s or %s", string(c), PlaceholderCheckIgnore, PlaceholderCheckWarn, PlaceholderCheckError)
+}
+
+var placeholderRegex = regexp.MustCompile(`\{\{[^{}]+\}\}`)
+
+// Placeholders returns the distinct {{...}} tokens in the body, headers,
+// footers, footnotes and tables of doc, sorted.
+func Placeholders(doc *docs.Document) []string {
+	seen := map[string]bool{}
+	backend.WalkContent(doc, func(content []*docs.StructuralElement) {
+		for _, se := range content {
+			if se.Paragraph == nil {
+				continue
+			}
+			for _, token := range placeholderRegex.FindAllString(backend.ParagraphText(se.Paragraph), -1) {
+				seen[token] = true
+			}
+		}
+	})
+	tokens := make([]string, 0, len(seen))
+	for token := range seen {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// CheckPlaceholders compares the placeholders found in a template with the
+// replacements. It returns the placeholders without a value and the supplied
+// keys that match no placeholder.
+func CheckPlaceholders(found []string, replacements map[string]string, supplied []string) (missing, unused []string) {
+	inTemplate := map[string]bool{}
+	for _, token := range found {
+		inTemplate[token] = true
+		if _, ok := replacements[token]; !ok {
+			missing = append(missing, token)
+		}
+	}
+	for _, key := range supplied {
+		if !inTemplate[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unused)
+	return missing, unused
+}
+
+// placeholderProblems describes the result of CheckPlaceholders.
+func placeholderProblems(template string, missing, unused []string) []string {
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("template %s has placeholders without value: %s", template, strings.Join(missing, ", ")))
+	}
+	if len(unused) > 0 {
+		problems = append(problems, fmt.Sprintf("data does not match any placeholder in template %s: %s", template, strings.Join(unused, ", ")))
+	}
+	return problems
+}
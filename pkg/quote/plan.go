@@ -0,0 +1,174 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/email"
+)
+
+// Plan describes what generating a quote would do.
+type Plan struct {
+	Template   string `json:"template"`
+	TemplateID string `json:"templateID"`
+	// Quote is the number that would be allocated if no other quote is
+	// logged first.
+	Quote string `json:"quote"`
+	// FolderName is the Drive folder of the customer's email domain. FolderID
+	// is empty if the folder would be created.
+	FolderName   string            `json:"folderName"`
+	FolderID     string            `json:"folderID,omitempty"`
+	DocName      string            `json:"docName"`
+	PDFPath      string            `json:"pdfPath"`
+	Replacements map[string]string `json:"replacements"`
+	LineItems    LineItems         `json:"lineItems"`
+	// Permissions are granted on the document after it is generated.
+	Permissions []backend.Permission `json:"permissions,omitempty"`
+	// Email is the email that would send the quote, without attachment.
+	Email    *email.Message `json:"email,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+// Plan resolves the template, computes the replacements and looks up the quote
+// number and folder a request would get, without writing anything.
+func (g *Generator) Plan(ctx context.Context, req Request) (*Plan, error) {
+	run, err := g.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := g.nextQuote(ctx, run.Row)
+	if err != nil {
+		return nil, err
+	}
+	pdfPath, err := g.PDFPath(run.Email, quote)
+	if err != nil {
+		return nil, err
+	}
+	folderName := FolderName(run.Email)
+	folderID, err := g.Folders.FindFolder(ctx, g.ParentFolderID, folderName)
+	if err != nil {
+		return nil, err
+	}
+
+	replacements := make(map[string]string, len(run.Replacements)+1)
+	for k, v := range run.Replacements {
+		replacements[k] = v
+	}
+	replacements["{{quote}}"] = quote
+	var msg *email.Message
+	if run.Send != nil {
+		r := *run
+		r.Replacements = replacements
+		msg, err = g.Email.compose(&r, run.Send)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Plan{
+		Template:     run.Template,
+		TemplateID:   run.TemplateID,
+		Quote:        quote,
+		FolderName:   folderName,
+		FolderID:     folderID,
+		DocName:      DocName(run.Email, quote),
+		PDFPath:      pdfPath,
+		Replacements: replacements,
+		LineItems:    run.LineItems,
+		Permissions:  g.Sharing.permissions(run.Email, replacements),
+		Email:        msg,
+		Warnings:     run.Warnings,
+	}, nil
+}
+
+// nextQuote returns the quote number LogQuotation would allocate for row now.
+func (g *Generator) nextQuote(ctx context.Context, row []string) (string, error) {
+	rows, err := g.Ledger.Rows(ctx)
+	if errors.Is(err, backend.ErrNotFound) {
+		// the quotation log is created with the first quote
+		rows = nil
+	} else if err != nil {
+		return "", fmt.Errorf("unable to read the quotation log: %v", err)
+	}
+	rows = append(rows, row)
+	return resolveQuote(g.scheme(), LedgerHeaders, rows, len(rows)-1, g.now()), nil
+}
+
+// Preview renders a request into a scratch copy of the template in
+// ParentFolderID and writes its PDF next to where the quote's PDF would go.
+// The copy is trashed afterwards. No quote number is allocated: the document
+// shows the number the quote would get. Unresolved placeholders are reported
+// in the result instead of failing the preview.
+func (g *Generator) Preview(ctx context.Context, req Request) (*Result, error) {
+	run, err := g.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	run.Quote, err = g.nextQuote(ctx, run.Row)
+	if err != nil {
+		return nil, err
+	}
+	run.Replacements["{{quote}}"] = run.Quote
+	run.AllowUnresolved = true
+
+	name := "PREVIEW " + DocName(run.Email, run.Quote)
+	run.DocID, err = g.Documents.CopyDocument(ctx, run.TemplateID, name, g.ParentFolderID)
+	if err != nil {
+		return nil, err
+	}
+	trashed := false
+	defer func() {
+		if trashed {
+			return
+		}
+		// the copy is trashed even if ctx is canceled half way
+		tctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_ = g.Documents.TrashDocument(tctx, run.DocID)
+	}()
+
+	if err = g.render(ctx, run); err != nil {
+		return nil, err
+	}
+	pdf, err := g.Documents.ExportPDF(ctx, run.DocID)
+	if err != nil {
+		return nil, err
+	}
+	trashed = true
+	if err = g.Documents.TrashDocument(ctx, run.DocID); err != nil {
+		run.Warnings = append(run.Warnings, fmt.Sprintf("unable to trash preview copy %s: %v", run.DocID, err))
+	}
+	path, err := g.PreviewPath(run.Email, run.Quote)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFile(path, pdf); err != nil {
+		return nil, err
+	}
+	run.PDFPath = path
+	run.FolderID = g.ParentFolderID
+	return run.result(), nil
+}
+
+// PreviewPath returns the path of the preview PDF of a quote in OutDir.
+func (g *Generator) PreviewPath(email, quote string) (string, error) {
+	return g.outPath(email, DocName(email, quote)+" PREVIEW.pdf")
+}
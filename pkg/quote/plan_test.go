@@ -0,0 +1,168 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+func TestPlanReadOnly(t *testing.T) {
+	g, store := newTestGenerator(t)
+	dir := filepath.Join(t.TempDir(), "ledger")
+	g.Ledger = backend.NewFileLedger(filepath.Join(dir, "quotes.jsonl"))
+	ctx := context.Background()
+
+	plan, err := g.Plan(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s: %v", dir, err)
+	}
+	if plan.FolderID != "" {
+		t.Errorf("dry run created folder %s", plan.FolderID)
+	}
+
+	result, err := g.Generate(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Quote != plan.Quote {
+		t.Errorf("generated quote %s, planned %s", result.Quote, plan.Quote)
+	}
+	if len(store.copies) != 1 {
+		t.Errorf("%d document copies, want 1", len(store.copies))
+	}
+}
+
+// readOnly fails the test on writes to the ledger or the journal.
+type readOnly struct {
+	t *testing.T
+	backend.QuoteLedger
+	backend.Journal
+}
+
+func (r *readOnly) EnsureSchema(context.Context, []string) error {
+	r.t.Error("ledger schema written")
+	return errFault
+}
+
+func (r *readOnly) Append(context.Context, []string) (int, error) {
+	r.t.Error("ledger row appended")
+	return 0, errFault
+}
+
+func (r *readOnly) Update(context.Context, int, []string) error {
+	r.t.Error("ledger row updated")
+	return errFault
+}
+
+func (r *readOnly) Acquire(id string) (func(), error) {
+	r.t.Errorf("journal entry %s acquired", id)
+	return nil, errFault
+}
+
+func (r *readOnly) Save(_ context.Context, id string, _ []byte) error {
+	r.t.Errorf("journal entry %s saved", id)
+	return errFault
+}
+
+func (r *readOnly) Remove(_ context.Context, id string) error {
+	r.t.Errorf("journal entry %s removed", id)
+	return errFault
+}
+
+func TestPreview(t *testing.T) {
+	g, store := newTestGenerator(t)
+	guard := &readOnly{t: t, QuoteLedger: store, Journal: store}
+	g.Ledger, g.Journal = guard, guard
+	ctx := context.Background()
+
+	result, err := g.Preview(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.copies) != 1 || result.DocID != store.copies[0] {
+		t.Fatalf("previewed %s with copies %v", result.DocID, store.copies)
+	}
+	if !store.Trashed(result.DocID) {
+		t.Errorf("preview copy %s was not trashed", result.DocID)
+	}
+	path, err := g.PreviewPath("jane@example.com", result.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PDFPath != path {
+		t.Errorf("got PDF %s, want %s", result.PDFPath, path)
+	}
+	pdf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(pdf), "Quote #"+result.Quote) || !strings.Contains(string(pdf), "For Jane Doe <jane@example.com>") {
+		t.Errorf("unexpected preview:\n%s", pdf)
+	}
+	if id, err := store.FindFolder(ctx, g.ParentFolderID, "example.com"); err != nil || id != "" {
+		t.Errorf("preview created the folder %s of example.com: %v", id, err)
+	}
+
+	// the preview shows the number the quote will get
+	g.Ledger, g.Journal = store, store
+	generated, err := g.Generate(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated.Quote != result.Quote {
+		t.Errorf("generated quote %s, previewed %s", generated.Quote, result.Quote)
+	}
+}
+
+func TestPreviewFailure(t *testing.T) {
+	for _, method := range []string{"BatchUpdate", "ExportPDF"} {
+		for _, cancel := range []bool{false, true} {
+			name := method
+			if cancel {
+				name += " canceled"
+			}
+			t.Run(name, func(t *testing.T) {
+				g, store := newTestGenerator(t)
+				guard := &readOnly{t: t, QuoteLedger: store, Journal: store}
+				g.Ledger, g.Journal = guard, guard
+				ctx := context.Background()
+				store.fail = method
+				if cancel {
+					ctx, store.cancel = context.WithCancel(ctx)
+					defer store.cancel()
+				}
+
+				if _, err := g.Preview(ctx, testRequest()); err == nil {
+					t.Fatal("preview succeeded")
+				}
+				if len(store.copies) != 1 {
+					t.Fatalf("got copies %v, want one", store.copies)
+				}
+				checkNoLeftovers(t, g, store)
+			})
+		}
+	}
+}
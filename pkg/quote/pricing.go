@@ -0,0 +1,194 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Term is the billing term of a license.
+type Term string
+
+const (
+	// TermAnnual is billed per year.
+	TermAnnual Term = "annual"
+	// TermOnDemand is billed per month.
+	TermOnDemand Term = "on-demand"
+)
+
+// period returns the unit a term is billed by.
+func (t Term) period(n int) string {
+	unit := "year"
+	if t == TermOnDemand {
+		unit = "month"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// Product is an entry of the pricing catalog.
+type Product struct {
+	// SKU identifies the product, e.g. kubedb-enterprise-cluster.
+	SKU string `json:"sku"`
+	// Name is shown in the line items of a quote.
+	Name string `json:"name"`
+	// Edition is appended to the name, e.g. Enterprise.
+	Edition     string `json:"edition,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Unit is what the quantity counts, e.g. cluster.
+	Unit string `json:"unit,omitempty"`
+	// Prices holds the price of one unit for one period of each term: a year
+	// for annual licenses and a month for on-demand licenses.
+	Prices map[Term]float64 `json:"prices"`
+}
+
+// DisplayName returns the product name followed by its edition.
+func (p Product) DisplayName() string {
+	if p.Edition == "" {
+		return p.Name
+	}
+	return p.Name + " " + p.Edition
+}
+
+// Catalog lists the products quotes can be priced from.
+type Catalog struct {
+	// Currency is the ISO 4217 code of the prices, e.g. USD.
+	Currency string    `json:"currency,omitempty"`
+	Products []Product `json:"products"`
+}
+
+// Validate checks that SKUs are unique and every product has a price.
+func (c *Catalog) Validate() error {
+	var errs []string
+	seen := map[string]bool{}
+	for i, p := range c.Products {
+		if p.SKU == "" {
+			errs = append(errs, fmt.Sprintf("product %d: missing sku", i+1))
+			continue
+		}
+		if seen[p.SKU] {
+			errs = append(errs, fmt.Sprintf("duplicate sku %s", p.SKU))
+		}
+		seen[p.SKU] = true
+		if p.Name == "" {
+			errs = append(errs, fmt.Sprintf("product %s: missing name", p.SKU))
+		}
+		if len(p.Prices) == 0 {
+			errs = append(errs, fmt.Sprintf("product %s: missing prices", p.SKU))
+		}
+		terms := make([]string, 0, len(p.Prices))
+		for term := range p.Prices {
+			terms = append(terms, string(term))
+		}
+		sort.Strings(terms)
+		for _, term := range terms {
+			if Term(term) != TermAnnual && Term(term) != TermOnDemand {
+				errs = append(errs, fmt.Sprintf("product %s: unknown term %q, must be %s or %s", p.SKU, term, TermAnnual, TermOnDemand))
+			} else if p.Prices[Term(term)] < 0 {
+				errs = append(errs, fmt.Sprintf("product %s: %s price must not be negative", p.SKU, term))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Product returns the product with the given SKU.
+func (c *Catalog) Product(sku string) (Product, bool) {
+	for _, p := range c.Products {
+		if p.SKU == sku {
+			return p, true
+		}
+	}
+	return Product{}, false
+}
+
+// Price fills in the line items that name a SKU from the catalog. The unit
+// price is the catalog price of the item's term times its number of periods,
+// so the amount is the total for the whole term. Items without a SKU are kept
+// as they are.
+func (c *Catalog) Price(items LineItems) (LineItems, error) {
+	out := items
+	out.Items = make([]LineItem, len(items.Items))
+	if out.Currency == "" && c != nil {
+		out.Currency = c.Currency
+	}
+	for i, li := range items.Items {
+		if li.SKU != "" {
+			var err error
+			li, err = c.price(li)
+			if err != nil {
+				return items, fmt.Errorf("line item %d: %v", i+1, err)
+			}
+		}
+		out.Items[i] = li
+	}
+	return out, nil
+}
+
+func (c *Catalog) price(li LineItem) (LineItem, error) {
+	if c == nil {
+		return li, fmt.Errorf("sku %s given without a pricing catalog", li.SKU)
+	}
+	p, ok := c.Product(li.SKU)
+	if !ok {
+		return li, fmt.Errorf("unknown sku %s", li.SKU)
+	}
+	if li.Term == "" {
+		li.Term = TermAnnual
+	}
+	price, ok := p.Prices[li.Term]
+	if !ok {
+		return li, fmt.Errorf("sku %s has no %s price", li.SKU, li.Term)
+	}
+	if li.Periods == 0 {
+		li.Periods = 1
+	}
+	if li.Periods < 0 {
+		return li, errors.New("periods must be positive")
+	}
+
+	if li.Product == "" {
+		li.Product = p.DisplayName()
+	}
+	if li.Description == "" {
+		li.Description = p.Description
+	}
+	li.UnitPrice = roundCents(price * float64(li.Periods))
+	return li, nil
+}
+
+// TermDescription returns a description of the item's license term, e.g. "annual,
+// 2 years", or an empty string if the item has no term. Items not priced from
+// the catalog may have no number of periods or a term of their own; their term
+// is returned as it is.
+func (li LineItem) TermDescription() string {
+	if li.Term == "" {
+		return ""
+	}
+	if li.Periods <= 0 || li.Term != TermAnnual && li.Term != TermOnDemand {
+		return string(li.Term)
+	}
+	return fmt.Sprintf("%s, %s", li.Term, li.Term.period(li.Periods))
+}
@@ -0,0 +1,222 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"strings"
+	"testing"
+)
+
+func testCatalog() *Catalog {
+	return &Catalog{
+		Currency: "USD",
+		Products: []Product{
+			{
+				SKU:         "kubedb-enterprise",
+				Name:        "KubeDB",
+				Edition:     "Enterprise",
+				Description: "Databases on Kubernetes",
+				Prices:      map[Term]float64{TermAnnual: 1200, TermOnDemand: 110.5},
+			},
+			{
+				SKU:    "stash",
+				Name:   "Stash",
+				Prices: map[Term]float64{TermAnnual: 99.999},
+			},
+		},
+	}
+}
+
+func TestCatalogPrice(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog *Catalog
+		items   LineItems
+		want    LineItems
+		err     string
+	}{
+		{
+			name:    "defaults",
+			catalog: testCatalog(),
+			items:   LineItems{Items: []LineItem{{SKU: "kubedb-enterprise", Quantity: 2}}},
+			want: LineItems{Currency: "USD", Items: []LineItem{{
+				SKU: "kubedb-enterprise", Product: "KubeDB Enterprise", Description: "Databases on Kubernetes",
+				Quantity: 2, UnitPrice: 1200, Term: TermAnnual, Periods: 1,
+			}}},
+		},
+		{
+			name:    "term and periods",
+			catalog: testCatalog(),
+			items:   LineItems{Currency: "EUR", Items: []LineItem{{SKU: "kubedb-enterprise", Quantity: 1, Term: TermOnDemand, Periods: 3}}},
+			want: LineItems{Currency: "EUR", Items: []LineItem{{
+				SKU: "kubedb-enterprise", Product: "KubeDB Enterprise", Description: "Databases on Kubernetes",
+				Quantity: 1, UnitPrice: 331.5, Term: TermOnDemand, Periods: 3,
+			}}},
+		},
+		{
+			name:    "overrides and rounding",
+			catalog: testCatalog(),
+			items:   LineItems{Items: []LineItem{{SKU: "stash", Product: "Stash Backup", Description: "Backups", Quantity: 1, Periods: 2}}},
+			want: LineItems{Currency: "USD", Items: []LineItem{{
+				SKU: "stash", Product: "Stash Backup", Description: "Backups",
+				Quantity: 1, UnitPrice: 200, Term: TermAnnual, Periods: 2,
+			}}},
+		},
+		{
+			name:    "items without sku",
+			catalog: nil,
+			items:   LineItems{Items: []LineItem{{Product: "Onboarding", Quantity: 1, UnitPrice: 500, Term: "one-time"}}},
+			want:    LineItems{Items: []LineItem{{Product: "Onboarding", Quantity: 1, UnitPrice: 500, Term: "one-time"}}},
+		},
+		{
+			name:    "no catalog",
+			catalog: nil,
+			items:   LineItems{Items: []LineItem{{SKU: "stash", Quantity: 1}}},
+			err:     "line item 1: sku stash given without a pricing catalog",
+		},
+		{
+			name:    "unknown sku",
+			catalog: testCatalog(),
+			items:   LineItems{Items: []LineItem{{SKU: "stash", Quantity: 1}, {SKU: "voyager", Quantity: 1}}},
+			err:     "line item 2: unknown sku voyager",
+		},
+		{
+			name:    "missing term",
+			catalog: testCatalog(),
+			items:   LineItems{Items: []LineItem{{SKU: "stash", Quantity: 1, Term: TermOnDemand}}},
+			err:     "line item 1: sku stash has no on-demand price",
+		},
+		{
+			name:    "negative periods",
+			catalog: testCatalog(),
+			items:   LineItems{Items: []LineItem{{SKU: "stash", Quantity: 1, Periods: -1}}},
+			err:     "line item 1: periods must be positive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.catalog.Price(tt.items)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Currency != tt.want.Currency || len(got.Items) != len(tt.want.Items) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got.Items {
+				if got.Items[i] != tt.want.Items[i] {
+					t.Errorf("item %d = %+v, want %+v", i+1, got.Items[i], tt.want.Items[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCatalogPriceKeepsItems(t *testing.T) {
+	items := LineItems{Items: []LineItem{{SKU: "stash", Quantity: 1}}}
+	if _, err := testCatalog().Price(items); err != nil {
+		t.Fatal(err)
+	}
+	if items.Items[0] != (LineItem{SKU: "stash", Quantity: 1}) {
+		t.Errorf("Price modified its argument: %+v", items.Items[0])
+	}
+}
+
+func TestCatalogValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		products []Product
+		errs     []string
+	}{
+		{
+			name:     "valid",
+			products: testCatalog().Products,
+		},
+		{
+			name:     "empty",
+			products: nil,
+		},
+		{
+			name: "missing fields",
+			products: []Product{
+				{Name: "KubeDB", Prices: map[Term]float64{TermAnnual: 1}},
+				{SKU: "stash", Prices: map[Term]float64{TermAnnual: 1}},
+				{SKU: "voyager", Name: "Voyager"},
+			},
+			errs: []string{"product 1: missing sku", "product stash: missing name", "product voyager: missing prices"},
+		},
+		{
+			name: "duplicate sku",
+			products: []Product{
+				{SKU: "stash", Name: "Stash", Prices: map[Term]float64{TermAnnual: 1}},
+				{SKU: "stash", Name: "Stash", Prices: map[Term]float64{TermAnnual: 2}},
+			},
+			errs: []string{"duplicate sku stash"},
+		},
+		{
+			name: "bad prices",
+			products: []Product{
+				{SKU: "stash", Name: "Stash", Prices: map[Term]float64{"weekly": 1, "daily": 1, TermOnDemand: -1}},
+			},
+			errs: []string{
+				`product stash: unknown term "daily", must be annual or on-demand`,
+				`product stash: on-demand price must not be negative`,
+				`product stash: unknown term "weekly", must be annual or on-demand`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Catalog{Products: tt.products}).Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if want := strings.Join(tt.errs, "; "); err == nil || err.Error() != want {
+				t.Errorf("got error %v, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestTermDescription(t *testing.T) {
+	tests := []struct {
+		item LineItem
+		want string
+	}{
+		{LineItem{}, ""},
+		{LineItem{Term: TermAnnual, Periods: 1}, "annual, 1 year"},
+		{LineItem{Term: TermAnnual, Periods: 3}, "annual, 3 years"},
+		{LineItem{Term: TermOnDemand, Periods: 1}, "on-demand, 1 month"},
+		{LineItem{Term: TermOnDemand, Periods: 6}, "on-demand, 6 months"},
+		{LineItem{Term: TermAnnual}, "annual"},
+		{LineItem{Term: "one-time", Periods: 2}, "one-time"},
+		{LineItem{Term: "3 years prepaid"}, "3 years prepaid"},
+	}
+	for _, tt := range tests {
+		if got := tt.item.TermDescription(); got != tt.want {
+			t.Errorf("TermDescription(%+v) = %q, want %q", tt.item, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Inputs are the inputs a quote was generated from. They are recorded as JSON
+// in the Inputs column of the quotation log, so that the quote can be
+// extended or renewed later.
+type Inputs struct {
+	Template   string `json:"template"`
+	TemplateID string `json:"templateID"`
+	// Data holds the placeholder values given, before the dates and the
+	// values derived from them are filled in.
+	Data            map[string]string              `json:"data"`
+	Lists           map[string][]map[string]string `json:"lists,omitempty"`
+	LineItems       LineItems                      `json:"lineItems"`
+	AllowUnresolved bool                           `json:"allowUnresolved,omitempty"`
+	// Optional lists the placeholders of Data that templates need not use.
+	Optional []string `json:"optional,omitempty"`
+}
+
+func newInputs(req Request, templateDocId string, data map[string]string, lineItems LineItems) Inputs {
+	in := Inputs{
+		Template:        req.Template,
+		TemplateID:      templateDocId,
+		Data:            map[string]string{},
+		Lists:           req.Lists,
+		LineItems:       lineItems,
+		AllowUnresolved: req.AllowUnresolved,
+	}
+	for k, v := range data {
+		in.Data[Placeholder(k)] = v
+	}
+	for k := range req.optional {
+		in.Optional = append(in.Optional, k)
+	}
+	sort.Strings(in.Optional)
+	return in
+}
+
+// ParseInputs returns the inputs recorded in a quotation log row. Quotes
+// logged before inputs were recorded fall back to the customer and template
+// columns of the row; their line items and lists are lost.
+func (g *Generator) ParseInputs(row []string) (*Inputs, error) {
+	quote := Field(row, ColQuote)
+	data := Field(row, ColInputs)
+	if data == "" {
+		template := Field(row, ColTemplate)
+		if template == "" || Field(row, ColEmail) == "" {
+			return nil, invalidRequest(fmt.Errorf("quote %s has no recorded inputs, template or email; issue a revision with --revise instead", quote))
+		}
+		return &Inputs{
+			Template:   template,
+			TemplateID: g.TemplateID(template),
+			Data:       revisionData(row, nil),
+		}, nil
+	}
+	var in Inputs
+	if err := json.Unmarshal([]byte(data), &in); err != nil {
+		return nil, fmt.Errorf("invalid inputs of quote %s: %v", quote, err)
+	}
+	return &in, nil
+}
+
+// RenewRequest returns a request for a new quote for a new term, pre-filled
+// with the inputs of quote. The request can be changed before it is passed
+// to Generate. Line items naming a SKU are priced again from the catalog.
+func (g *Generator) RenewRequest(ctx context.Context, quote string) (Request, error) {
+	row, _, err := FindQuote(ctx, g.Ledger, quote)
+	if err != nil {
+		return Request{}, err
+	}
+	if Status(row) == StatusVoid {
+		return Request{}, invalidRequest(fmt.Errorf("quote %s is void", quote))
+	}
+	in, err := g.ParseInputs(row)
+	if err != nil {
+		return Request{}, err
+	}
+	req := Request{
+		Template:        in.Template,
+		Data:            in.Data,
+		Lists:           in.Lists,
+		LineItems:       in.LineItems,
+		AllowUnresolved: in.AllowUnresolved,
+		RenewalOf:       quote,
+	}
+	if req.Template == "" {
+		req.Template = in.TemplateID
+	}
+	if len(in.Optional) > 0 {
+		req.optional = map[string]bool{}
+		for _, k := range in.Optional {
+			req.optional[k] = true
+		}
+	}
+	return req, nil
+}
+
+var docLinkRegex = regexp.MustCompile(`/d/([A-Za-z0-9_-]+)`)
+
+// docIDFromLink returns the document id of a link like
+// https://docs.google.com/document/d/ID/edit.
+func docIDFromLink(link string) string {
+	m := docLinkRegex.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// reopenStatus returns the status of an extended quote: its status if it is
+// still open, else the open status it had before it expired.
+func reopenStatus(row []string) string {
+	if s := Status(row); OpenStatus(s) {
+		return s
+	}
+	history := StatusHistory(row)
+	for i := len(history) - 1; i >= 0; i-- {
+		if OpenStatus(history[i].Status) {
+			return history[i].Status
+		}
+	}
+	return StatusDraft
+}
+
+// Extend gives quote a new validity: the quote keeps its number, and its
+// document and PDF are generated again from the same template and inputs,
+// prepared now and expiring after validity, or Validity if zero. The new
+// document replaces the old one, which is trashed, so its link changes. An
+// expired quote is open again. If send is set, the new PDF is emailed.
+//
+// Like Preview, Extend does not use the journal: if it fails before the
+// quotation log is updated, the new document is trashed and the quote is
+// left as it was.
+func (g *Generator) Extend(ctx context.Context, quote string, validity time.Duration, send *Delivery) (*Result, error) {
+	if g.ParentFolderID == "" {
+		return nil, errors.New("missing parent folder id")
+	}
+	if validity < 0 {
+		return nil, invalidRequest(fmt.Errorf("invalid validity %v", validity))
+	}
+	if validity == 0 {
+		validity = Validity
+	}
+	if err := g.Sharing.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sharing: %v", err)
+	}
+	if err := g.checkDelivery(send); err != nil {
+		return nil, err
+	}
+
+	row, _, err := FindQuote(ctx, g.Ledger, quote)
+	if err != nil {
+		return nil, err
+	}
+	switch s := Status(row); {
+	case Field(row, ColSupersededBy) != "":
+		return nil, invalidRequest(fmt.Errorf("quote %s is superseded by %s", quote, Field(row, ColSupersededBy)))
+	case !OpenStatus(s) && s != StatusExpired:
+		return nil, invalidRequest(fmt.Errorf("quote %s is %s, only open or expired quotes can be extended", quote, s))
+	}
+	in, err := g.ParseInputs(row)
+	if err != nil {
+		return nil, err
+	}
+
+	now := g.now()
+	replacements, err := Replacements(in.Data, now)
+	if err != nil {
+		return nil, invalidRequest(err)
+	}
+	replacements["{{expiry-date}}"] = now.Add(validity).Format(DateLayout)
+	if len(in.LineItems.Items) > 0 {
+		for k, v := range in.LineItems.Replacements() {
+			replacements[k] = v
+		}
+	}
+	replacements["{{quote}}"] = quote
+	r := &Run{
+		Template:        in.Template,
+		TemplateID:      in.TemplateID,
+		Email:           replacements["{{email}}"],
+		Lists:           in.Lists,
+		LineItems:       in.LineItems,
+		AllowUnresolved: in.AllowUnresolved,
+		Send:            send,
+		Quote:           quote,
+		Replacements:    replacements,
+	}
+
+	path, err := g.PDFPath(r.Email, quote)
+	if err != nil {
+		return nil, err
+	}
+	if send != nil {
+		if err = g.Email.check(r, send); err != nil {
+			return nil, invalidRequest(err)
+		}
+	}
+
+	r.FolderID, err = g.folder(ctx, FolderName(r.Email))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the folder of %s: %v", r.Email, err)
+	}
+	r.DocID, err = g.Documents.CopyDocument(ctx, r.TemplateID, DocName(r.Email, quote), r.FolderID)
+	if err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		// the copy is trashed even if ctx is canceled half way
+		tctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_ = g.Documents.TrashDocument(tctx, r.DocID)
+	}()
+
+	if err = g.render(ctx, r); err != nil {
+		return nil, err
+	}
+	pdf, err := g.Documents.ExportPDF(ctx, r.DocID)
+	if err != nil {
+		return nil, err
+	}
+	r.Link, err = g.Documents.ShareDocument(ctx, r.DocID, g.Sharing.permissions(r.Email, replacements))
+	if err != nil {
+		return nil, err
+	}
+
+	// the row is read again, as the quote may have changed meanwhile
+	row, idx, err := FindQuote(ctx, g.Ledger, quote)
+	if err != nil {
+		return nil, err
+	}
+	oldDocID := docIDFromLink(Field(row, ColLink))
+	expiry := replacements["{{expiry-date}}"]
+	row = SetField(row, ColPreparationDate, replacements["{{prep-date}}"])
+	row = SetField(row, ColExpirationDate, expiry)
+	row = SetField(row, ColLink, r.Link)
+	row = setStatus(row, reopenStatus(row), "extended until "+expiry, now)
+	if err = g.Ledger.Update(ctx, idx, row); err != nil {
+		return nil, fmt.Errorf("unable to extend quote %s in the quotation log: %v", quote, err)
+	}
+	committed = true
+
+	if oldDocID != "" && oldDocID != r.DocID {
+		if err = g.Documents.TrashDocument(ctx, oldDocID); err != nil {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("unable to trash the old document %s: %v", oldDocID, err))
+		}
+	}
+	if err = writeFile(path, pdf); err != nil {
+		return nil, fmt.Errorf("quote %s was extended, but its PDF could not be written: %v", quote, err)
+	}
+	r.PDFPath = path
+
+	if send != nil {
+		if err = g.send(ctx, r); err != nil {
+			return nil, &SendError{Result: r.result(), Err: err}
+		}
+	}
+	return r.result(), nil
+}
@@ -0,0 +1,322 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+// logLegacyQuote logs a quote the way versions without the Inputs column did.
+func logLegacyQuote(t *testing.T, g *Generator, template string) string {
+	t.Helper()
+	replacements, err := Replacements(map[string]string{
+		"name":    "Jane Doe",
+		"email":   "jane@example.com",
+		"company": "Example Inc",
+	}, g.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := LedgerRow(template, replacements)
+	quote, err := LogQuotation(context.Background(), g.Ledger, g.scheme(), LedgerHeaders, row, g.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quote
+}
+
+func TestRenewWithoutInputs(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+	old := logLegacyQuote(t, g, "kubedb")
+
+	req, err := g.RenewRequest(ctx, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Template != "kubedb" || req.RenewalOf != old {
+		t.Errorf("renewal of %s from template %q, want %s from kubedb", req.RenewalOf, req.Template, old)
+	}
+	for k, want := range map[string]string{"{{name}}": "Jane Doe", "{{email}}": "jane@example.com", "{{company}}": "Example Inc"} {
+		if got := req.Data[k]; got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+
+	result, err := g.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, _, err := FindQuote(ctx, store, result.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Field(row, ColRenewalOf) != old || Field(row, ColInputs) == "" {
+		t.Errorf("renewal of %q with inputs %q", Field(row, ColRenewalOf), Field(row, ColInputs))
+	}
+}
+
+func TestExtendWithoutInputs(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+	quote := logLegacyQuote(t, g, "kubedb")
+
+	result, err := g.Extend(ctx, quote, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := backend.PlainText(store.Document(result.DocID)); !strings.Contains(text, "For Jane Doe <jane@example.com>") {
+		t.Errorf("document not rendered:\n%s", text)
+	}
+}
+
+func TestParseInputsMissing(t *testing.T) {
+	g, _ := newTestGenerator(t)
+	for _, row := range [][]string{
+		SetField(SetField(nil, ColQuote, "Q1"), ColEmail, "jane@example.com"),
+		SetField(SetField(nil, ColQuote, "Q1"), ColTemplate, "kubedb"),
+	} {
+		if _, err := g.ParseInputs(row); err == nil || !strings.Contains(err.Error(), "no recorded inputs") {
+			t.Errorf("got error %v, want no recorded inputs", err)
+		}
+	}
+}
+
+func TestExtend(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	generated, err := g.Generate(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote := generated.Quote
+	if err = SetStatus(ctx, store, quote, StatusSent, "by hand", g.now()); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetStatus(ctx, store, quote, StatusViewed, "", g.now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the quote expired on Nov 6 and is extended a month later
+	now := time.Date(2024, 12, 9, 15, 0, 0, 0, time.UTC)
+	g.Now = func() time.Time { return now }
+	expired, err := ExpireQuotes(ctx, store, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0] != quote {
+		t.Fatalf("expired %v, want [%s]", expired, quote)
+	}
+
+	result, err := g.Extend(ctx, quote, 14*24*time.Hour, &Delivery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Quote != quote {
+		t.Errorf("extended quote is %s, want %s", result.Quote, quote)
+	}
+	if result.DocID == generated.DocID || result.Link == generated.Link {
+		t.Errorf("document %s was not replaced", generated.DocID)
+	}
+	if !store.Trashed(generated.DocID) {
+		t.Errorf("old document %s was not trashed", generated.DocID)
+	}
+	if store.Trashed(result.DocID) {
+		t.Errorf("new document %s was trashed", result.DocID)
+	}
+
+	text := backend.PlainText(store.Document(result.DocID))
+	for _, want := range []string{"Quote #" + quote, "For Jane Doe <jane@example.com>", "Valid until Dec 23, 2024"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("document misses %q:\n%s", want, text)
+		}
+	}
+	if result.PDFPath != generated.PDFPath {
+		t.Errorf("pdf path = %s, want %s", result.PDFPath, generated.PDFPath)
+	}
+	if pdf, err := os.ReadFile(result.PDFPath); err != nil || string(pdf) != text {
+		t.Errorf("pdf was not replaced: %s %v", pdf, err)
+	}
+	if sent := g.Mailer.(*testMailer).sent; len(sent) != 1 || sent[0].Subject != "Quote "+quote {
+		t.Errorf("sent %d emails, want the extended quote", len(sent))
+	}
+
+	row, _, err := FindQuote(ctx, store, quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for col, want := range map[string]string{
+		ColPreparationDate: "Dec 9, 2024",
+		ColExpirationDate:  "Dec 23, 2024",
+		ColLink:            result.Link,
+		// the quote is open again with the status it had before it expired,
+		// then sent
+		ColStatus: StatusSent,
+	} {
+		if got := Field(row, col); got != want {
+			t.Errorf("%s = %q, want %q", col, got, want)
+		}
+	}
+	var changes []string
+	for _, c := range StatusHistory(row) {
+		changes = append(changes, c.Status+": "+c.Note)
+	}
+	want := []string{
+		"draft: ",
+		"sent: by hand",
+		"viewed: ",
+		"expired: expired on Nov 6, 2024",
+		"viewed: extended until Dec 23, 2024",
+	}
+	if got := strings.Join(changes, "\n"); !strings.HasPrefix(got, strings.Join(want, "\n")+"\nsent: ") {
+		t.Errorf("status history:\n%s\nwant:\n%s\nsent: ...", got, strings.Join(want, "\n"))
+	}
+	if rows, err := store.Rows(ctx); err != nil || len(rows) != 1 {
+		t.Errorf("got %d ledger rows, want the quote only: %v", len(rows), err)
+	}
+}
+
+func TestReopenStatus(t *testing.T) {
+	now := time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		statuses []string
+		want     string
+	}{
+		{nil, StatusDraft},
+		{[]string{StatusDraft, StatusSent}, StatusSent},
+		{[]string{StatusDraft, StatusSent, StatusViewed, StatusExpired}, StatusViewed},
+		{[]string{StatusDraft, StatusExpired}, StatusDraft},
+		{[]string{StatusExpired}, StatusDraft},
+	}
+	for _, tt := range tests {
+		var row []string
+		for _, s := range tt.statuses {
+			row = setStatus(row, s, "", now)
+		}
+		if got := reopenStatus(row); got != tt.want {
+			t.Errorf("reopenStatus after %v = %s, want %s", tt.statuses, got, tt.want)
+		}
+	}
+}
+
+func TestExtendRejected(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	for _, status := range []string{StatusAccepted, StatusRejected, StatusVoid} {
+		result, err := g.Generate(ctx, testRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = SetStatus(ctx, store, result.Quote, status, "", g.now()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = g.Extend(ctx, result.Quote, 0, nil); !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), "only open or expired quotes") {
+			t.Errorf("extending a %s quote: got error %v", status, err)
+		}
+		if store.Trashed(result.DocID) {
+			t.Errorf("document of the %s quote was trashed", status)
+		}
+	}
+	if _, err := g.Extend(ctx, "missing", 0, nil); !errors.Is(err, ErrQuoteNotFound) {
+		t.Errorf("extending a missing quote: got error %v", err)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	store.PutDocument("items", backend.NewTextDocument("Template", itemsTemplate+"Valid until {{expiry-date}}\n"))
+	g.Templates["items"] = "items"
+
+	req := testRequest()
+	req.Template = "items"
+	req.Data["company"] = "Example Inc"
+	req.LineItems = LineItems{Items: []LineItem{{Product: "KubeDB", Quantity: 2, UnitPrice: 1000}}, Discount: 10}
+	old, err := g.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.Now = func() time.Time { return time.Date(2025, 9, 30, 9, 0, 0, 0, time.UTC) }
+	renewal, err := g.RenewRequest(ctx, old.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewal.Template != "items" || renewal.RenewalOf != old.Quote {
+		t.Errorf("renewal of %s from template %q, want %s from items", renewal.RenewalOf, renewal.Template, old.Quote)
+	}
+	if renewal.Data["{{company}}"] != "Example Inc" || len(renewal.LineItems.Items) != 1 || renewal.LineItems.Discount != 10 {
+		t.Errorf("renewal lost the inputs: %+v", renewal)
+	}
+
+	result, err := g.Generate(ctx, renewal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Quote == old.Quote || result.DocID == old.DocID {
+		t.Errorf("renewal reused quote %s and document %s", result.Quote, result.DocID)
+	}
+	if text := backend.PlainText(store.Document(result.DocID)); !strings.Contains(text, "Valid until Oct 30, 2025") {
+		t.Errorf("renewal is not valid from now:\n%s", text)
+	}
+	row, _, err := FindQuote(ctx, store, result.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for col, want := range map[string]string{
+		ColRenewalOf: old.Quote,
+		ColTotal:     "1800.00",
+		ColStatus:    StatusDraft,
+	} {
+		if got := Field(row, col); got != want {
+			t.Errorf("%s = %q, want %q", col, got, want)
+		}
+	}
+	oldRow, _, err := FindQuote(ctx, store, old.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Status(oldRow) != StatusDraft || store.Trashed(old.DocID) {
+		t.Errorf("renewed quote changed to %s", Status(oldRow))
+	}
+}
+
+func TestRenewVoid(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	result, err := g.Generate(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SetStatus(ctx, store, result.Quote, StatusVoid, "", g.now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.RenewRequest(ctx, result.Quote); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("got error %v, want an invalid request", err)
+	}
+}
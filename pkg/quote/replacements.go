@@ -0,0 +1,128 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/davegardnerisme/phonegeocode"
+	"github.com/gobuffalo/flect"
+	emailproviders "gomodules.xyz/email-providers"
+)
+
+// DateLayout is the layout of dates written into quotes and the ledger.
+const DateLayout = "Jan 2, 2006"
+
+// Validity is how long a quote stays valid after it is prepared.
+const Validity = 30 * 24 * time.Hour
+
+// Placeholder returns the template placeholder for key, e.g. {{company-name}}
+// for companyName.
+func Placeholder(key string) string {
+	if strings.HasPrefix(key, "{{") && strings.HasSuffix(key, "}}") {
+		return key
+	}
+	key = strings.Trim(key, "{}")
+	key = flect.Dasherize(key)
+	return fmt.Sprintf("{{%s}}", key)
+}
+
+// Replacements turns user supplied data into template replacements and fills
+// in the values derived from them: website, tel, country and the dates.
+func Replacements(data map[string]string, now time.Time) (map[string]string, error) {
+	replacements := map[string]string{}
+	for k, v := range data {
+		replacements[Placeholder(k)] = v
+	}
+
+	email, ok := replacements["{{email}}"]
+	if !ok {
+		return nil, errors.New("missing email")
+	}
+	if err := ValidateEmail(email); err != nil {
+		return nil, err
+	}
+	if emailproviders.IsPublicEmail(email) {
+		replacements["{{website}}"] = ""
+	} else {
+		replacements["{{website}}"] = emailproviders.Domain(email)
+	}
+
+	if v, ok := replacements["{{phone}}"]; ok {
+		replacements["{{tel}}"] = v
+	}
+	if v, ok := replacements["{{tel}}"]; ok {
+		tel := SanitizeTelNumber(v)
+		if !strings.HasPrefix(tel, "+") && len(tel) == 10 {
+			tel = "+1" + tel
+		}
+		replacements["{{tel}}"] = tel
+		if cc, err := phonegeocode.New().Country(tel); err == nil {
+			replacements["{{country}}"] = cc
+		}
+	}
+
+	replacements["{{prep-date}}"] = now.Format(DateLayout)
+	replacements["{{expiry-date}}"] = now.Add(Validity).Format(DateLayout)
+	return replacements, nil
+}
+
+// ErrInvalidEmail is returned for an email that is not a plain address or can
+// not be used to name the folder and files of a quote.
+var ErrInvalidEmail = errors.New("invalid email")
+
+// ValidateEmail checks that email is a bare address, e.g. user@example.com.
+// The address, or its domain, names the Drive folder and the local directory
+// of the quote, so path separators and ".." are rejected as well.
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidEmail, email, err)
+	}
+	if addr.Address != email {
+		return fmt.Errorf("%w %q: expected a bare address", ErrInvalidEmail, email)
+	}
+	if strings.ContainsAny(email, `/\`) || strings.Contains(email, "..") {
+		return fmt.Errorf("%w %q: must not contain path separators or \"..\"", ErrInvalidEmail, email)
+	}
+	return nil
+}
+
+// FolderName returns the name of the folder that holds quotes for email: the
+// email domain, or the address itself for public email providers.
+func FolderName(email string) string {
+	if emailproviders.IsPublicEmail(email) {
+		return email
+	}
+	parts := strings.Split(email, "@")
+	return parts[len(parts)-1]
+}
+
+func SanitizeTelNumber(tel string) string {
+	var buf bytes.Buffer
+	for _, r := range tel {
+		if r == '+' || (r >= '0' && r <= '9') {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
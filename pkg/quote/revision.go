@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+var revisionRegex = regexp.MustCompile(`^(.+)-R(\d+)$`)
+
+// SplitRevision splits a quote number like AC2410007-R2 into the original
+// quote number and the revision. The original quote is revision 1.
+func SplitRevision(quote string) (string, int) {
+	m := revisionRegex.FindStringSubmatch(quote)
+	if m == nil {
+		return quote, 1
+	}
+	rev, err := strconv.Atoi(m[2])
+	if err != nil || rev < 2 {
+		return quote, 1
+	}
+	return m[1], rev
+}
+
+// FormatRevision returns the quote number of a revision of base.
+func FormatRevision(base string, rev int) string {
+	if rev < 2 {
+		return base
+	}
+	return fmt.Sprintf("%s-R%d", base, rev)
+}
+
+func nextRevision(rev int) int {
+	if rev < 1 {
+		return 2
+	}
+	return rev + 1
+}
+
+// findLatestRevision returns the ledger row of the latest revision of quote
+// that is not void.
+func findLatestRevision(ctx context.Context, ledger backend.QuoteLedger, quote string) ([]string, error) {
+	rows, err := ledger.Rows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	base, _ := SplitRevision(quote)
+	var latest []string
+	var latestRev int
+	for _, row := range rows {
+		b, r := SplitRevision(Field(row, ColQuote))
+		if b == base && r > latestRev && Field(row, ColStatus) != StatusVoid {
+			latest, latestRev = row, r
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%w: %s", ErrQuoteNotFound, quote)
+	}
+	return latest, nil
+}
+
+// revisionData returns the customer data of the parent quote's ledger row,
+// overridden by data.
+func revisionData(parent []string, data map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, col := range []string{ColName, ColDesignation, ColEmail, ColTelephone, ColCompany, ColSalesRep} {
+		if v := Field(parent, col); v != "" {
+			out[ledgerData[col]] = v
+		}
+	}
+	for k, v := range data {
+		out[Placeholder(k)] = v
+	}
+	return out
+}
+
+// supersede marks every earlier revision of quote that is neither superseded
+// yet nor void as superseded by quote.
+func supersede(ctx context.Context, ledger backend.QuoteLedger, quote string) error {
+	base, rev := SplitRevision(quote)
+	rows, err := ledger.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		b, r := SplitRevision(Field(row, ColQuote))
+		if b != base || r >= rev || Field(row, ColSupersededBy) != "" || Status(row) == StatusVoid {
+			continue
+		}
+		if err = ledger.Update(ctx, i, SetField(row, ColSupersededBy, quote)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsupersede reverts supersede for a revision that was rolled back.
+func unsupersede(ctx context.Context, ledger backend.QuoteLedger, quote string) error {
+	rows, err := ledger.Rows(ctx)
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if Field(row, ColSupersededBy) != quote {
+			continue
+		}
+		if err = ledger.Update(ctx, i, SetField(row, ColSupersededBy, "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
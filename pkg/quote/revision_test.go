@@ -0,0 +1,212 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSplitRevision(t *testing.T) {
+	tests := []struct {
+		quote string
+		base  string
+		rev   int
+	}{
+		{"AC2410007", "AC2410007", 1},
+		{"AC2410007-R2", "AC2410007", 2},
+		{"AC2410007-R12", "AC2410007", 12},
+		{"KDB-2024-0007-R3", "KDB-2024-0007", 3},
+		// revision 1 is the original quote number
+		{"AC2410007-R1", "AC2410007-R1", 1},
+		{"AC2410007-R0", "AC2410007-R0", 1},
+		{"AC2410007-R", "AC2410007-R", 1},
+		{"-R2", "-R2", 1},
+	}
+	for _, tt := range tests {
+		base, rev := SplitRevision(tt.quote)
+		if base != tt.base || rev != tt.rev {
+			t.Errorf("SplitRevision(%s) = %s, %d, want %s, %d", tt.quote, base, rev, tt.base, tt.rev)
+		}
+		if rev > 1 {
+			if got := FormatRevision(base, rev); got != tt.quote {
+				t.Errorf("FormatRevision(%s, %d) = %s, want %s", base, rev, got, tt.quote)
+			}
+		}
+	}
+	if got := FormatRevision("AC2410007", 1); got != "AC2410007" {
+		t.Errorf("FormatRevision(AC2410007, 1) = %s", got)
+	}
+}
+
+// revise generates a revision of quote with a new company name.
+func revise(t *testing.T, g *Generator, quote, company string) *Result {
+	t.Helper()
+	result, err := g.Generate(context.Background(), Request{Revise: quote, Data: map[string]string{"company": company}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// supersededBy returns the SupersededBy column of every quote in the ledger.
+func supersededBy(t *testing.T, store *faultyStore) map[string]string {
+	t.Helper()
+	rows, err := store.Rows(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]string{}
+	for _, row := range rows {
+		out[Field(row, ColQuote)] = Field(row, ColSupersededBy)
+	}
+	return out
+}
+
+func checkSupersededBy(t *testing.T, store *faultyStore, want map[string]string) {
+	t.Helper()
+	got := supersededBy(t, store)
+	if len(got) != len(want) {
+		t.Errorf("got quotes %v, want %v", got, want)
+	}
+	for q, by := range want {
+		if got[q] != by {
+			t.Errorf("%s superseded by %q, want %q", q, got[q], by)
+		}
+	}
+}
+
+func TestRevise(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	req := testRequest()
+	req.Data["company"] = "Example Inc"
+	req.Data["tel"] = "+15550100"
+	original, err := g.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := original.Quote
+
+	r2 := revise(t, g, q, "Example LLC")
+	if r2.Quote != q+"-R2" {
+		t.Fatalf("revision of %s is %s, want %s-R2", q, r2.Quote, q)
+	}
+	row, _, err := FindQuote(ctx, store, r2.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for col, want := range map[string]string{
+		ColParent:    q,
+		ColTemplate:  "kubedb",
+		ColName:      "Jane Doe",
+		ColEmail:     "jane@example.com",
+		ColTelephone: "+15550100",
+		ColCompany:   "Example LLC",
+	} {
+		if got := Field(row, col); got != want {
+			t.Errorf("%s = %q, want %q", col, got, want)
+		}
+	}
+	checkSupersededBy(t, store, map[string]string{q: r2.Quote, r2.Quote: ""})
+
+	// revising a revision that is not the latest revises the latest one
+	r3 := revise(t, g, r2.Quote, "Example Corp")
+	r4 := revise(t, g, r2.Quote, "Example GmbH")
+	if r3.Quote != q+"-R3" || r4.Quote != q+"-R4" {
+		t.Fatalf("got revisions %s and %s, want %s-R3 and %s-R4", r3.Quote, r4.Quote, q, q)
+	}
+	row, _, err = FindQuote(ctx, store, r4.Quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Field(row, ColCompany) != "Example GmbH" || Field(row, ColParent) != r2.Quote {
+		t.Errorf("revision %s of %s for %s", r4.Quote, Field(row, ColParent), Field(row, ColCompany))
+	}
+	checkSupersededBy(t, store, map[string]string{
+		q:        r2.Quote,
+		r2.Quote: r3.Quote,
+		r3.Quote: r4.Quote,
+		r4.Quote: "",
+	})
+
+	// revising the original quote number revises the latest revision too
+	r5 := revise(t, g, q, "Example SA")
+	if r5.Quote != q+"-R5" {
+		t.Errorf("revision of %s is %s, want %s-R5", q, r5.Quote, q)
+	}
+
+	// other quotes keep their numbers
+	other, err := g.Generate(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base, rev := SplitRevision(other.Quote); rev != 1 || base == q {
+		t.Errorf("new quote after revisions is %s", other.Quote)
+	}
+}
+
+func TestReviseRollback(t *testing.T) {
+	g, store := newTestGenerator(t)
+	ctx := context.Background()
+
+	original, err := g.Generate(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := original.Quote
+	r2 := revise(t, g, q, "Example LLC")
+
+	store.fail = "ExportPDF"
+	_, err = g.Generate(ctx, Request{Revise: q})
+	if !errors.Is(err, errFault) {
+		t.Fatalf("got error %v, want %v", err, errFault)
+	}
+	row, _, err := FindQuote(ctx, store, q+"-R3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Status(row) != StatusVoid {
+		t.Errorf("rolled back revision is %s, want %s", Status(row), StatusVoid)
+	}
+	// the revision it superseded is the latest again
+	checkSupersededBy(t, store, map[string]string{q: r2.Quote, r2.Quote: "", q + "-R3": ""})
+	latest, err := findLatestRevision(ctx, store, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Field(latest, ColQuote) != r2.Quote {
+		t.Errorf("latest revision is %s, want %s", Field(latest, ColQuote), r2.Quote)
+	}
+
+	// the void revision number is not issued again
+	r4 := revise(t, g, q, "Example Corp")
+	if r4.Quote != q+"-R4" {
+		t.Errorf("revision after the rolled back one is %s, want %s-R4", r4.Quote, q)
+	}
+	checkSupersededBy(t, store, map[string]string{q: r2.Quote, r2.Quote: r4.Quote, q + "-R3": "", r4.Quote: ""})
+}
+
+func TestReviseMissing(t *testing.T) {
+	g, _ := newTestGenerator(t)
+	_, err := g.Generate(context.Background(), Request{Revise: "AC2410999"})
+	if !errors.Is(err, ErrQuoteNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrQuoteNotFound)
+	}
+}
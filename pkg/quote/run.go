@@ -0,0 +1,558 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Steps of generating a quote, in order. A Run records the last step it
+// completed.
+const (
+	StepStarted  = "started"
+	StepLogged   = "logged"
+	StepFolder   = "folder"
+	StepCopied   = "copied"
+	StepRendered = "rendered"
+	StepExported = "exported"
+	StepShared   = "shared"
+	StepSent     = "sent"
+)
+
+// nextStep maps each step to the one following it.
+var nextStep = map[string]string{
+	StepStarted:  StepLogged,
+	StepLogged:   StepFolder,
+	StepFolder:   StepCopied,
+	StepCopied:   StepRendered,
+	StepRendered: StepExported,
+	StepExported: StepShared,
+	StepShared:   StepSent,
+}
+
+// Run is the state of generating a quote. It holds everything needed to
+// finish the quote or to roll it back, and is saved in the journal after every
+// step.
+type Run struct {
+	ID      string    `json:"id"`
+	Started time.Time `json:"started"`
+	// Step is the last step completed.
+	Step string `json:"step"`
+	// Error is the error the run failed with, if it could not be rolled back
+	// or was interrupted.
+	Error string `json:"error,omitempty"`
+
+	Template        string                         `json:"template"`
+	TemplateID      string                         `json:"templateID"`
+	Revise          string                         `json:"revise,omitempty"`
+	Email           string                         `json:"email"`
+	Row             []string                       `json:"row"`
+	Replacements    map[string]string              `json:"replacements"`
+	Lists           map[string][]map[string]string `json:"lists,omitempty"`
+	LineItems       LineItems                      `json:"lineItems"`
+	AllowUnresolved bool                           `json:"allowUnresolved,omitempty"`
+	Send            *Delivery                      `json:"send,omitempty"`
+
+	// Marker is the pending marker of the ledger row reserved for the quote.
+	// It is saved before the row is appended, together with the quote number
+	// before the number is written, and cleared once the number is logged.
+	Marker string `json:"marker,omitempty"`
+	// Copy is the name of the document copy being made. It is saved before
+	// the copy is requested and cleared once DocID is known.
+	Copy string `json:"copy,omitempty"`
+
+	Quote       string           `json:"quote,omitempty"`
+	FolderID    string           `json:"folderID,omitempty"`
+	DocID       string           `json:"docID,omitempty"`
+	PDFPath     string           `json:"pdfPath,omitempty"`
+	Link        string           `json:"link,omitempty"`
+	SentTo      []string         `json:"sentTo,omitempty"`
+	Occurrences map[string]int64 `json:"occurrences,omitempty"`
+	Unresolved  []string         `json:"unresolved,omitempty"`
+	Warnings    []string         `json:"warnings,omitempty"`
+}
+
+func (r *Run) result() *Result {
+	return &Result{
+		Quote:       r.Quote,
+		DocID:       r.DocID,
+		FolderID:    r.FolderID,
+		PDFPath:     r.PDFPath,
+		Link:        r.Link,
+		SentTo:      r.SentTo,
+		Occurrences: r.Occurrences,
+		Unresolved:  r.Unresolved,
+		Warnings:    r.Warnings,
+	}
+}
+
+func (g *Generator) newRun(req Request, templateDocId, email string, row []string, replacements map[string]string, lineItems LineItems) (*Run, error) {
+	nonce := make([]byte, 4)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	now := g.now()
+	return &Run{
+		ID:              now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(nonce),
+		Started:         now,
+		Step:            StepStarted,
+		Template:        req.Template,
+		TemplateID:      templateDocId,
+		Revise:          req.Revise,
+		Email:           email,
+		Row:             row,
+		Replacements:    replacements,
+		Lists:           req.Lists,
+		LineItems:       lineItems,
+		AllowUnresolved: req.AllowUnresolved,
+		Send:            req.Send,
+	}, nil
+}
+
+// acquire claims the journal entry of a run.
+func (g *Generator) acquire(id string) (func(), error) {
+	if g.Journal == nil {
+		return func() {}, nil
+	}
+	return g.Journal.Acquire(id)
+}
+
+func (g *Generator) save(ctx context.Context, r *Run) error {
+	if g.Journal == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = g.Journal.Save(ctx, r.ID, data); err != nil {
+		return fmt.Errorf("unable to save run %s in the journal: %v", r.ID, err)
+	}
+	return nil
+}
+
+func (g *Generator) forget(ctx context.Context, r *Run) error {
+	if g.Journal == nil {
+		return nil
+	}
+	if err := g.Journal.Remove(ctx, r.ID); err != nil {
+		return fmt.Errorf("unable to remove run %s from the journal: %v", r.ID, err)
+	}
+	return nil
+}
+
+// execute runs the remaining steps of a claimed run. A failed run is rolled
+// back, unless it was interrupted by ctx and can be resumed from the journal.
+func (g *Generator) execute(ctx context.Context, r *Run) (*Result, error) {
+	err := g.steps(ctx, r)
+	if err == nil {
+		result := r.result()
+		if err = g.forget(ctx, r); err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+		}
+		return result, nil
+	}
+
+	r.Error = err.Error()
+	if r.Step == StepShared && r.Send != nil {
+		// the quote is complete, only sending it failed
+		sendErr := &SendError{Result: r.result(), Err: err}
+		if g.save(context.Background(), r) == nil && g.Journal != nil {
+			sendErr.RunID = r.ID
+		}
+		return nil, sendErr
+	}
+	rctx := ctx
+	if ctx.Err() != nil {
+		if g.Journal != nil {
+			if saveErr := g.save(context.Background(), r); saveErr == nil {
+				return nil, fmt.Errorf("%w; run %s was interrupted, finish or roll it back with resume", err, r.ID)
+			}
+		}
+		// without journal the run can not be resumed, so it is rolled back
+		// even though ctx is done
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+	}
+	if rbErr := g.rollback(rctx, r); rbErr != nil {
+		if g.Journal != nil && g.save(rctx, r) == nil {
+			return nil, fmt.Errorf("%w; rollback of run %s failed, retry with resume --rollback: %v", err, r.ID, rbErr)
+		}
+		return nil, fmt.Errorf("%w; rollback failed: %v", err, rbErr)
+	}
+	var unresolved *UnresolvedError
+	if errors.As(err, &unresolved) {
+		unresolved.DocID = ""
+	}
+	_ = g.forget(rctx, r)
+	return nil, err
+}
+
+// done reports whether the run completed its last step. Quotes are only
+// emailed if the run has a Delivery.
+func (r *Run) done() bool {
+	return r.Step == StepSent || (r.Step == StepShared && r.Send == nil)
+}
+
+// steps runs the steps following r.Step and saves r after each.
+func (g *Generator) steps(ctx context.Context, r *Run) error {
+	if err := g.save(ctx, r); err != nil {
+		return err
+	}
+	for !r.done() {
+		var err error
+		switch r.Step {
+		case StepStarted:
+			err = g.logQuote(ctx, r)
+		case StepLogged:
+			r.FolderID, err = g.folder(ctx, FolderName(r.Email))
+		case StepFolder:
+			err = g.copyTemplate(ctx, r)
+		case StepCopied:
+			err = g.render(ctx, r)
+		case StepRendered:
+			err = g.export(ctx, r)
+		case StepExported:
+			err = g.share(ctx, r)
+		case StepShared:
+			err = g.send(ctx, r)
+		default:
+			err = fmt.Errorf("run %s is at unknown step %q", r.ID, r.Step)
+		}
+		if err != nil {
+			return err
+		}
+		r.Step = nextStep[r.Step]
+		if r.done() {
+			// the run is removed from the journal next
+			return nil
+		}
+		if err = g.save(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logQuote allocates the quote number in the ledger. The reserved row is
+// saved in the journal before every write to the ledger, so that resume and
+// rollback can find it.
+func (g *Generator) logQuote(ctx context.Context, r *Run) error {
+	reserve := func(marker, quote string) error {
+		r.Marker, r.Quote = marker, quote
+		return g.save(ctx, r)
+	}
+	quote, err := logQuotation(ctx, g.Ledger, g.scheme(), LedgerHeaders, r.Row, g.now(), reserve)
+	if err != nil {
+		return fmt.Errorf("unable to append quotation: %v", err)
+	}
+	r.Marker = ""
+	r.Quote = quote
+	r.Replacements["{{quote}}"] = quote
+	if r.Revise != "" {
+		if err = supersede(ctx, g.Ledger, quote); err != nil {
+			return fmt.Errorf("unable to mark revisions of %s superseded: %v", quote, err)
+		}
+	}
+	return nil
+}
+
+// reclaimQuote finishes the allocation of the quote number of an interrupted
+// run. Quote is left empty if no number was allocated.
+func (g *Generator) reclaimQuote(ctx context.Context, r *Run) error {
+	quote, unique, err := reclaimQuote(ctx, g.Ledger, g.scheme(), LedgerHeaders, r.Row, r.Marker, r.Quote)
+	if err != nil {
+		return fmt.Errorf("unable to reclaim the ledger row reserved as %s: %v", r.Marker, err)
+	}
+	r.Marker, r.Quote = "", ""
+	if unique {
+		r.Quote = quote
+		r.Replacements["{{quote}}"] = quote
+	}
+	return nil
+}
+
+// copyTemplate copies the template into the customer's folder. The name of
+// the copy is saved in the journal first, so that resume and rollback can
+// find a copy whose id was never saved.
+func (g *Generator) copyTemplate(ctx context.Context, r *Run) error {
+	r.Copy = DocName(r.Email, r.Quote)
+	if err := g.save(ctx, r); err != nil {
+		return err
+	}
+	docID, err := g.Documents.CopyDocument(ctx, r.TemplateID, r.Copy, r.FolderID)
+	if err != nil {
+		return err
+	}
+	r.DocID, r.Copy = docID, ""
+	return nil
+}
+
+// trashCopies trashes the copies a run may have made without saving their id.
+func (g *Generator) trashCopies(ctx context.Context, r *Run) error {
+	if r.Copy == "" {
+		return nil
+	}
+	ids, err := g.Documents.FindDocuments(ctx, r.FolderID, r.Copy)
+	if err != nil {
+		return fmt.Errorf("unable to find copies named %s: %v", r.Copy, err)
+	}
+	for _, id := range ids {
+		if err = g.Documents.TrashDocument(ctx, id); err != nil {
+			return fmt.Errorf("unable to trash document %s: %v", id, err)
+		}
+	}
+	r.Copy = ""
+	return nil
+}
+
+// render fills in the document copy.
+func (g *Generator) render(ctx context.Context, r *Run) error {
+	err := renderSections(ctx, g.Documents, r.DocID, sectionValues{replacements: r.Replacements, lists: r.Lists})
+	if err != nil {
+		return err
+	}
+	if len(r.LineItems.Items) > 0 {
+		err = renderLineItems(ctx, g.Documents, r.DocID, r.LineItems.Items)
+		if err != nil {
+			return err
+		}
+	}
+	r.Occurrences, err = g.replace(ctx, r.DocID, r.Replacements)
+	if err != nil {
+		return err
+	}
+
+	doc, err := g.Documents.GetDocument(ctx, r.DocID)
+	if err != nil {
+		return err
+	}
+	r.Unresolved = Placeholders(doc)
+	if len(r.Unresolved) > 0 && !r.AllowUnresolved {
+		return &UnresolvedError{Quote: r.Quote, DocID: r.DocID, Placeholders: r.Unresolved}
+	}
+	return nil
+}
+
+// export writes the PDF of the document to OutDir.
+func (g *Generator) export(ctx context.Context, r *Run) error {
+	pdf, err := g.Documents.ExportPDF(ctx, r.DocID)
+	if err != nil {
+		return err
+	}
+	path, err := g.PDFPath(r.Email, r.Quote)
+	if err != nil {
+		return err
+	}
+	if err = writeFile(path, pdf); err != nil {
+		return err
+	}
+	r.PDFPath = path
+	return nil
+}
+
+// writeFile writes data to a temporary file renamed to path, so that a
+// failed write leaves no partial file behind.
+func writeFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// rollback compensates the steps done by a run, in reverse order: the PDF is
+// removed, the document copy trashed and the quote marked void in the ledger.
+// A copy or a ledger row the run reserved but did not record is looked up
+// first. Compensated steps are cleared from r, so a failed rollback can be
+// retried.
+func (g *Generator) rollback(ctx context.Context, r *Run) error {
+	if r.PDFPath != "" {
+		if err := os.Remove(r.PDFPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove %s: %v", r.PDFPath, err)
+		}
+		r.PDFPath = ""
+	}
+	if r.DocID != "" {
+		if err := g.Documents.TrashDocument(ctx, r.DocID); err != nil {
+			return fmt.Errorf("unable to trash document %s: %v", r.DocID, err)
+		}
+		r.DocID = ""
+	}
+	if err := g.trashCopies(ctx, r); err != nil {
+		return err
+	}
+	if r.Marker != "" {
+		if err := g.reclaimQuote(ctx, r); err != nil {
+			return err
+		}
+	}
+	if r.Quote != "" {
+		if r.Revise != "" {
+			if err := unsupersede(ctx, g.Ledger, r.Quote); err != nil {
+				return fmt.Errorf("unable to restore the revisions superseded by %s: %v", r.Quote, err)
+			}
+		}
+		if err := voidQuote(ctx, g.Ledger, r.Quote, g.now()); err != nil {
+			return fmt.Errorf("unable to void quote %s: %v", r.Quote, err)
+		}
+		r.Quote = ""
+	}
+	return nil
+}
+
+// Runs returns the runs left in the journal, because they were interrupted or
+// could not be rolled back.
+func (g *Generator) Runs(ctx context.Context) ([]*Run, error) {
+	if g.Journal == nil {
+		return nil, nil
+	}
+	ids, err := g.Journal.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*Run, 0, len(ids))
+	for _, id := range ids {
+		r, err := g.loadRun(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+func (g *Generator) loadRun(ctx context.Context, id string) (*Run, error) {
+	if g.Journal == nil {
+		return nil, errors.New("no journal")
+	}
+	data, err := g.Journal.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var r Run
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("unable to parse run %s: %v", id, err)
+	}
+	return &r, nil
+}
+
+// Resume finishes a run left in the journal. A document copy that may have
+// been rendered only in part, or whose id was not saved, is trashed and copied
+// again, and a ledger row reserved for the quote is resolved. If the run fails
+// again, it is rolled back.
+func (g *Generator) Resume(ctx context.Context, id string) (*Result, error) {
+	release, err := g.acquire(id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	r, err := g.loadRun(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.Error = ""
+	if r.Step == StepCopied && r.DocID != "" {
+		if err = g.Documents.TrashDocument(ctx, r.DocID); err != nil {
+			return nil, fmt.Errorf("unable to trash document %s: %v", r.DocID, err)
+		}
+		r.DocID = ""
+		r.Step = StepFolder
+	}
+	if r.Step == StepFolder {
+		if err = g.trashCopies(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+	if r.Step == StepStarted && r.Marker != "" {
+		if err = g.reclaimQuote(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+	if r.Step == StepStarted && r.Quote != "" {
+		// the quote number was allocated, but the revisions may not be
+		// superseded yet
+		r.Step = StepLogged
+		if r.Revise != "" {
+			if err = supersede(ctx, g.Ledger, r.Quote); err != nil {
+				return nil, fmt.Errorf("unable to mark revisions of %s superseded: %v", r.Quote, err)
+			}
+		}
+	}
+	return g.execute(ctx, r)
+}
+
+// Rollback compensates the steps done by a run left in the journal and
+// removes it.
+func (g *Generator) Rollback(ctx context.Context, id string) error {
+	release, err := g.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	r, err := g.loadRun(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = g.rollback(ctx, r); err != nil {
+		r.Error = err.Error()
+		_ = g.save(ctx, r)
+		return err
+	}
+	return g.forget(ctx, r)
+}
+
+// Describe summarizes the progress of a run.
+func (r *Run) Describe() string {
+	parts := []string{r.ID, r.Started.Format(time.RFC3339), "step " + r.Step}
+	if r.Quote != "" {
+		parts = append(parts, "quote "+r.Quote)
+	}
+	if r.Email != "" {
+		parts = append(parts, r.Email)
+	}
+	s := strings.Join(parts, "  ")
+	if r.Error != "" {
+		s += "\n  error: " + r.Error
+	}
+	return s
+}
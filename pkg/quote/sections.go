@@ -0,0 +1,364 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+
+	"google.golang.org/api/docs/v1"
+)
+
+// Block tags of templates. A section between {{#if key}} and {{/if}} is kept
+// if key has a truthy value, a section between {{#unless key}} and
+// {{/unless}} if it has none. Both may have an {{else}} part. A section between
+// {{#each list}} and {{/each}} is repeated for every element of the list, with
+// {{this.field}} replaced by the fields of the element.
+var blockTagRegex = regexp.MustCompile(`\{\{\s*(#if|#unless|#each|else|/if|/unless|/each)\s*([^{}]*?)\s*\}\}`)
+
+// thisPrefix starts the placeholders of the fields of an {{#each}} element.
+const thisPrefix = "{{this."
+
+// tag is a block tag found in a template.
+type tag struct {
+	kind    string
+	arg     string
+	segment string
+	// content is the list of elements holding the tag and para the index of
+	// the paragraph holding it.
+	content []*docs.StructuralElement
+	para    int
+	// start and end are the document indexes of the tag.
+	start, end int64
+}
+
+// span returns the range deleted to remove the tag. A tag that is the only
+// text of its paragraph is removed with the paragraph, unless that is the last
+// paragraph of its content, which Google Docs does not allow to delete.
+func (t *tag) span() (int64, int64) {
+	se := t.content[t.para]
+	text := strings.TrimSpace(backend.ParagraphText(se.Paragraph))
+	if text == t.text() && t.para < len(t.content)-1 {
+		return se.StartIndex, se.EndIndex
+	}
+	return t.start, t.end
+}
+
+func (t *tag) text() string {
+	se := t.content[t.para]
+	units := utf16.Encode([]rune(backend.ParagraphText(se.Paragraph)))
+	return string(utf16.Decode(units[t.start-se.StartIndex : t.end-se.StartIndex]))
+}
+
+// block is a conditional or repeated section of a template.
+type block struct {
+	open, els, close *tag
+	// nested reports whether the block contains other blocks.
+	nested bool
+}
+
+// findBlocks returns the blocks of doc. Every block must open and close in the
+// same body, header, footer or table cell.
+func findBlocks(doc *docs.Document) ([]*block, error) {
+	var blocks []*block
+	var errs []string
+	var walk func(segment string, content []*docs.StructuralElement)
+	walk = func(segment string, content []*docs.StructuralElement) {
+		var stack []*block
+		for i, se := range content {
+			if se.Table != nil {
+				for _, row := range se.Table.TableRows {
+					for _, cell := range row.TableCells {
+						walk(segment, cell.Content)
+					}
+				}
+				continue
+			}
+			if se.Paragraph == nil {
+				continue
+			}
+			text := backend.ParagraphText(se.Paragraph)
+			for _, m := range blockTagRegex.FindAllStringSubmatchIndex(text, -1) {
+				t := &tag{
+					kind:    text[m[2]:m[3]],
+					arg:     text[m[4]:m[5]],
+					segment: segment,
+					content: content,
+					para:    i,
+					start:   se.StartIndex + utf16Len(text[:m[0]]),
+					end:     se.StartIndex + utf16Len(text[:m[1]]),
+				}
+				switch t.kind {
+				case "#if", "#unless", "#each":
+					if t.arg == "" {
+						errs = append(errs, fmt.Sprintf("%s at %d has no argument", t.text(), t.start))
+						continue
+					}
+					if len(stack) > 0 {
+						stack[len(stack)-1].nested = true
+					}
+					stack = append(stack, &block{open: t})
+				case "else":
+					if len(stack) == 0 || stack[len(stack)-1].open.kind == "#each" || stack[len(stack)-1].els != nil {
+						errs = append(errs, fmt.Sprintf("unexpected %s at %d", t.text(), t.start))
+						continue
+					}
+					stack[len(stack)-1].els = t
+				default:
+					if len(stack) == 0 || "/"+stack[len(stack)-1].open.kind[1:] != t.kind {
+						errs = append(errs, fmt.Sprintf("unexpected %s at %d", t.text(), t.start))
+						continue
+					}
+					b := stack[len(stack)-1]
+					b.close = t
+					stack = stack[:len(stack)-1]
+					blocks = append(blocks, b)
+				}
+			}
+		}
+		for _, b := range stack {
+			errs = append(errs, fmt.Sprintf("%s at %d is not closed", b.open.text(), b.open.start))
+		}
+	}
+
+	if doc.Body != nil {
+		walk("", doc.Body.Content)
+	}
+	for id, h := range doc.Headers {
+		walk(id, h.Content)
+	}
+	for id, f := range doc.Footers {
+		walk(id, f.Content)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("invalid template sections: %s", strings.Join(errs, "; "))
+	}
+	return blocks, nil
+}
+
+func utf16Len(s string) int64 {
+	return int64(len(utf16.Encode([]rune(s))))
+}
+
+// Truthy reports whether a value enables an {{#if}} section: any value but
+// the empty string, false, no, off and 0.
+func Truthy(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "false", "no", "off", "0":
+		return false
+	}
+	return true
+}
+
+// sectionValues holds the values {{#if}}, {{#unless}} and {{#each}} sections
+// are evaluated with.
+type sectionValues struct {
+	replacements map[string]string
+	lists        map[string][]map[string]string
+}
+
+func (v sectionValues) truthy(key string) bool {
+	key = Placeholder(key)
+	for k, list := range v.lists {
+		if Placeholder(k) == key {
+			return len(list) > 0
+		}
+	}
+	return Truthy(v.replacements[key])
+}
+
+func (v sectionValues) list(key string) []map[string]string {
+	key = Placeholder(key)
+	for k, list := range v.lists {
+		if Placeholder(k) == key {
+			return list
+		}
+	}
+	return nil
+}
+
+// renderSections evaluates the conditional and repeated sections of the
+// document. Each pass handles the innermost blocks, from the end of the
+// document to the start so that the indexes of the blocks not yet handled do
+// not move, until no block is left.
+func renderSections(ctx context.Context, store backend.DocumentStore, docID string, values sectionValues) error {
+	var limit int
+	for pass := 0; ; pass++ {
+		doc, err := store.GetDocument(ctx, docID)
+		if err != nil {
+			return err
+		}
+		blocks, err := findBlocks(doc)
+		if err != nil {
+			return err
+		}
+		if len(blocks) == 0 {
+			return nil
+		}
+		// every pass removes at least one block, unless values add new ones
+		if pass == 0 {
+			limit = len(blocks)
+		} else if pass > limit {
+			return errors.New("template sections do not converge, values must not hold block tags")
+		}
+
+		inner := blocks[:0]
+		for _, b := range blocks {
+			if !b.nested {
+				inner = append(inner, b)
+			}
+		}
+		sort.Slice(inner, func(i, j int) bool {
+			return inner[i].open.start > inner[j].open.start
+		})
+		var reqs []*docs.Request
+		for _, b := range inner {
+			r, err := b.requests(values)
+			if err != nil {
+				return err
+			}
+			reqs = append(reqs, r...)
+		}
+		if _, err = store.BatchUpdate(ctx, docID, reqs); err != nil {
+			return fmt.Errorf("unable to render template sections: %v", err)
+		}
+	}
+}
+
+// requests returns the requests rendering the block, from its end to its
+// start.
+func (b *block) requests(values sectionValues) ([]*docs.Request, error) {
+	segment := b.open.segment
+	openStart, openEnd := b.open.span()
+	closeStart, closeEnd := b.close.span()
+	if b.open.kind == "#each" {
+		body, err := textRange(b.open.content, openEnd, closeStart)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.open.text(), err)
+		}
+		var sb strings.Builder
+		for _, el := range values.list(b.open.arg) {
+			sb.WriteString(renderThis(body, el))
+		}
+		reqs := []*docs.Request{deleteRange(segment, openStart, closeEnd)}
+		if sb.Len() > 0 {
+			reqs = append(reqs, &docs.Request{
+				InsertText: &docs.InsertTextRequest{
+					Location: &docs.Location{SegmentId: segment, Index: openStart},
+					Text:     sb.String(),
+				},
+			})
+		}
+		return reqs, nil
+	}
+
+	keep := values.truthy(b.open.arg)
+	if b.open.kind == "#unless" {
+		keep = !keep
+	}
+	switch {
+	case keep && b.els != nil:
+		elseStart, _ := b.els.span()
+		return []*docs.Request{
+			deleteRange(segment, elseStart, closeEnd),
+			deleteRange(segment, openStart, openEnd),
+		}, nil
+	case keep:
+		return []*docs.Request{
+			deleteRange(segment, closeStart, closeEnd),
+			deleteRange(segment, openStart, openEnd),
+		}, nil
+	case b.els != nil:
+		_, elseEnd := b.els.span()
+		return []*docs.Request{
+			deleteRange(segment, closeStart, closeEnd),
+			deleteRange(segment, openStart, elseEnd),
+		}, nil
+	default:
+		return []*docs.Request{deleteRange(segment, openStart, closeEnd)}, nil
+	}
+}
+
+func deleteRange(segment string, start, end int64) *docs.Request {
+	return &docs.Request{
+		DeleteContentRange: &docs.DeleteContentRangeRequest{
+			Range: &docs.Range{SegmentId: segment, StartIndex: start, EndIndex: end},
+		},
+	}
+}
+
+// textRange returns the text between two indexes of content. Repeated sections
+// can only hold text, as tables can not be copied with InsertText.
+func textRange(content []*docs.StructuralElement, start, end int64) (string, error) {
+	var sb strings.Builder
+	for _, se := range content {
+		if se.EndIndex <= start || se.StartIndex >= end {
+			continue
+		}
+		if se.Paragraph == nil {
+			return "", errors.New("repeated sections can only hold text")
+		}
+		units := utf16.Encode([]rune(backend.ParagraphText(se.Paragraph)))
+		from, to := start-se.StartIndex, end-se.StartIndex
+		if from < 0 {
+			from = 0
+		}
+		if to > int64(len(units)) {
+			to = int64(len(units))
+		}
+		sb.WriteString(string(utf16.Decode(units[from:to])))
+	}
+	return sb.String(), nil
+}
+
+// renderThis replaces the {{this.field}} placeholders of text with the fields
+// of an {{#each}} element.
+func renderThis(text string, fields map[string]string) string {
+	for k, v := range fields {
+		text = strings.ReplaceAll(text, thisPrefix+strings.Trim(Placeholder(k), "{}")+"}}", v)
+	}
+	return text
+}
+
+// sectionTokens splits the placeholders of a template into the block tags and
+// {{this.*}} placeholders evaluated by the sections, returned as the keys the
+// sections refer to, and the remaining placeholders.
+func sectionTokens(found []string) (placeholders, keys []string) {
+	seen := map[string]bool{}
+	for _, token := range found {
+		if m := blockTagRegex.FindStringSubmatch(token); m != nil && m[0] == token {
+			if m[2] != "" && !seen[m[2]] {
+				seen[m[2]] = true
+				keys = append(keys, Placeholder(m[2]))
+			}
+			continue
+		}
+		if strings.HasPrefix(token, thisPrefix) {
+			continue
+		}
+		placeholders = append(placeholders, token)
+	}
+	return placeholders, keys
+}
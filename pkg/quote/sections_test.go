@@ -0,0 +1,201 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+func renderText(t *testing.T, text string, values sectionValues) (string, error) {
+	t.Helper()
+	mem := backend.NewMemory()
+	mem.PutDocument("doc", backend.NewTextDocument("Quote", text))
+	if err := renderSections(context.Background(), mem, "doc", values); err != nil {
+		return "", err
+	}
+	return backend.PlainText(mem.Document("doc")), nil
+}
+
+func TestRenderSections(t *testing.T) {
+	values := sectionValues{
+		replacements: map[string]string{
+			"{{support}}":  "yes",
+			"{{discount}}": "0",
+			"{{company}}":  "Example Inc",
+		},
+		lists: map[string][]map[string]string{
+			"contacts": {
+				{"name": "Jane", "email": "jane@example.com"},
+				{"name": "John", "email": "john@example.com"},
+				{"name": "Joe", "email": "joe@example.com"},
+			},
+			"owners": {
+				{"name": "Ann"},
+			},
+			"none": {},
+		},
+	}
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "if kept",
+			text: "Quote\n{{#if support}}\n24/7 support\n{{/if}}\nEnd\n",
+			want: "Quote\n24/7 support\nEnd\n",
+		},
+		{
+			name: "if removed",
+			text: "Quote\n{{#if discount}}\nDiscount applied\n{{/if}}\nEnd\n",
+			want: "Quote\nEnd\n",
+		},
+		{
+			name: "if missing value",
+			text: "Quote\n{{#if missing}}\nMissing\n{{/if}}\nEnd\n",
+			want: "Quote\nEnd\n",
+		},
+		{
+			name: "inline if",
+			text: "Support: {{#if support}}included{{else}}extra{{/if}}.\nEnd\n",
+			want: "Support: included.\nEnd\n",
+		},
+		{
+			name: "else taken",
+			text: "Quote\n{{#if discount}}\nDiscount\n{{else}}\nList price\n{{/if}}\nEnd\n",
+			want: "Quote\nList price\nEnd\n",
+		},
+		{
+			name: "else skipped",
+			text: "Quote\n{{#if support}}\nSupport\n{{else}}\nNo support\n{{/if}}\nEnd\n",
+			want: "Quote\nSupport\nEnd\n",
+		},
+		{
+			name: "unless",
+			text: "Quote\n{{#unless discount}}\nList price\n{{else}}\nDiscount\n{{/unless}}\nEnd\n",
+			want: "Quote\nList price\nEnd\n",
+		},
+		{
+			name: "each none",
+			text: "Contacts:\n{{#each none}}\n- {{this.name}}\n{{/each}}\nEnd\n",
+			want: "Contacts:\nEnd\n",
+		},
+		{
+			name: "each one",
+			text: "Owners:\n{{#each owners}}\n- {{this.name}}\n{{/each}}\nEnd\n",
+			want: "Owners:\n- Ann\nEnd\n",
+		},
+		{
+			name: "each many",
+			text: "Contacts:\n{{#each contacts}}\n- {{this.name}} <{{this.email}}>\n{{/each}}\nEnd\n",
+			want: "Contacts:\n- Jane <jane@example.com>\n- John <john@example.com>\n- Joe <joe@example.com>\nEnd\n",
+		},
+		{
+			name: "inline each",
+			text: "To: {{#each contacts}}{{this.name}}, {{/each}}and us.\n",
+			want: "To: Jane, John, Joe, and us.\n",
+		},
+		{
+			name: "nested",
+			text: "Quote\n{{#if support}}\nSupport for {{company}}\n{{#if discount}}\nDiscounted\n{{else}}\nContacts:\n{{#each owners}}\n- {{this.name}}\n{{/each}}\n{{/if}}\n{{/if}}\nEnd\n",
+			want: "Quote\nSupport for {{company}}\nContacts:\n- Ann\nEnd\n",
+		},
+		{
+			name: "nested removed",
+			text: "Quote\n{{#if discount}}\nA\n{{#each contacts}}\n- {{this.name}}\n{{/each}}\nB\n{{/if}}\nEnd\n",
+			want: "Quote\nEnd\n",
+		},
+		{
+			name: "non-ASCII before tags",
+			text: "Préis für 🚀 Kunden {{#if support}}mit Support 😀{{/if}} ✓\nÜber {{#each owners}}{{this.name}} 🙂 {{/each}}ende\n",
+			want: "Préis für 🚀 Kunden mit Support 😀 ✓\nÜber Ann 🙂 ende\n",
+		},
+		{
+			// the last paragraph of a document can't be deleted, only emptied
+			name: "last paragraph",
+			text: "Quote\n{{#if discount}}\nDiscount\n{{/if}}",
+			want: "Quote\n\n",
+		},
+		{
+			name: "table cell",
+			text: "Items\n| {{#if support}}Support{{/if}} | {{#if discount}}Discount{{/if}} |\nEnd\n",
+			want: "Items\nSupport\n\nEnd\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderText(t, tt.text, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderSectionsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{"unclosed", "{{#if support}}\nSupport\n", "is not closed"},
+		{"unopened", "Support\n{{/if}}\n", "unexpected {{/if}}"},
+		{"mismatched", "{{#if support}}\n{{/each}}\n", "unexpected {{/each}}"},
+		{"else without if", "{{else}}\n", "unexpected {{else}}"},
+		{"else in each", "{{#each contacts}}\n{{else}}\n{{/each}}\n", "unexpected {{else}}"},
+		{"two elses", "{{#if a}}\n{{else}}\n{{else}}\n{{/if}}\n", "unexpected {{else}}"},
+		{"missing argument", "{{#if }}\n{{/if}}\n", "has no argument"},
+		{"across cells", "| {{#if a}} | {{/if}} |\n", "is not closed"},
+		{"table in each", "{{#each contacts}}\n| {{this.name}} |\n{{/each}}\n", "can only hold text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderText(t, tt.text, sectionValues{lists: map[string][]map[string]string{"contacts": {{"name": "Jane"}}}})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTruthy(t *testing.T) {
+	for v, want := range map[string]bool{
+		"": false, " ": false, "false": false, "No": false, "OFF": false, "0": false,
+		"yes": true, "1": true, "true": true, "anything": true, "0.0": true,
+	} {
+		if got := Truthy(v); got != want {
+			t.Errorf("Truthy(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
+
+func TestSectionTokens(t *testing.T) {
+	placeholders, keys := sectionTokens([]string{"{{name}}", "{{#if support}}", "{{/if}}", "{{#each contacts}}", "{{this.name}}", "{{/each}}", "{{else}}", "{{#unless support}}"})
+	if strings.Join(placeholders, " ") != "{{name}}" {
+		t.Errorf("placeholders = %v, want [{{name}}]", placeholders)
+	}
+	if strings.Join(keys, " ") != "{{support}} {{contacts}}" {
+		t.Errorf("keys = %v, want [{{support}} {{contacts}}]", keys)
+	}
+}
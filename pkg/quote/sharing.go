@@ -0,0 +1,136 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+// Sharing selects who generated quotes are shared with.
+type Sharing struct {
+	// Customer is the role granted to the customer's email address:
+	// backend.RoleReader or backend.RoleCommenter. Empty does not share with
+	// the customer.
+	Customer string `json:"customer,omitempty"`
+	// Notify emails the customer about the shared quote, including Message.
+	// Google requires it for addresses without a Google account.
+	Notify bool `json:"notify,omitempty"`
+	// Message is added to the notification email. Placeholders like {{name}}
+	// and {{quote}} are filled in.
+	Message string `json:"message,omitempty"`
+	// Group is a Google Group, e.g. sales@appscode.com, granted GroupRole
+	// without notification.
+	Group string `json:"group,omitempty"`
+	// GroupRole defaults to backend.RoleWriter.
+	GroupRole string `json:"groupRole,omitempty"`
+	// AnyoneWithLink lets anyone with the link view the quote. The quote can
+	// not be found by searching.
+	AnyoneWithLink bool `json:"anyoneWithLink,omitempty"`
+}
+
+// Validate checks the roles and the group address.
+func (s Sharing) Validate() error {
+	var errs []string
+	switch s.Customer {
+	case "", backend.RoleReader, backend.RoleCommenter:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid customer role %q, must be %s or %s", s.Customer, backend.RoleReader, backend.RoleCommenter))
+	}
+	switch s.GroupRole {
+	case "", backend.RoleReader, backend.RoleCommenter, backend.RoleWriter:
+	default:
+		errs = append(errs, fmt.Sprintf("invalid group role %q, must be %s, %s or %s", s.GroupRole, backend.RoleReader, backend.RoleCommenter, backend.RoleWriter))
+	}
+	if s.Group != "" && !strings.Contains(s.Group, "@") {
+		errs = append(errs, fmt.Sprintf("invalid group email %q", s.Group))
+	}
+	if s.Customer == "" && (s.Notify || s.Message != "") {
+		errs = append(errs, "notify and message need sharing with the customer")
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// permissions returns the permissions to grant on the quote for the customer's
+// email.
+func (s Sharing) permissions(email string, replacements map[string]string) []backend.Permission {
+	var perms []backend.Permission
+	if s.Customer != "" && email != "" {
+		perms = append(perms, backend.Permission{
+			Type:         backend.GranteeUser,
+			Role:         s.Customer,
+			EmailAddress: email,
+			Notify:       s.Notify,
+			Message:      fillIn(s.Message, replacements),
+		})
+	}
+	if s.Group != "" {
+		role := s.GroupRole
+		if role == "" {
+			role = backend.RoleWriter
+		}
+		perms = append(perms, backend.Permission{
+			Type:         backend.GranteeGroup,
+			Role:         role,
+			EmailAddress: s.Group,
+		})
+	}
+	if s.AnyoneWithLink {
+		perms = append(perms, backend.Permission{
+			Type: backend.GranteeAnyone,
+			Role: backend.RoleReader,
+		})
+	}
+	return perms
+}
+
+// fillIn replaces the placeholders in s.
+func fillIn(s string, replacements map[string]string) string {
+	if s == "" {
+		return s
+	}
+	oldnew := make([]string, 0, 2*len(replacements))
+	for k, v := range replacements {
+		oldnew = append(oldnew, k, v)
+	}
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+// share grants the permissions of Sharing on the quote document and records
+// its link in the ledger.
+func (g *Generator) share(ctx context.Context, r *Run) error {
+	link, err := g.Documents.ShareDocument(ctx, r.DocID, g.Sharing.permissions(r.Email, r.Replacements))
+	if err != nil {
+		return err
+	}
+	row, idx, err := FindQuote(ctx, g.Ledger, r.Quote)
+	if err != nil {
+		return err
+	}
+	if err = g.Ledger.Update(ctx, idx, SetField(row, ColLink, link)); err != nil {
+		return fmt.Errorf("unable to record the link of %s: %v", r.Quote, err)
+	}
+	r.Link = link
+	return nil
+}
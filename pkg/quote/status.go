@@ -0,0 +1,196 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+// Statuses of a quote in the quotation log.
+const (
+	// StatusDraft is a generated quote that was not sent to the customer yet.
+	StatusDraft    = "draft"
+	StatusSent     = "sent"
+	StatusViewed   = "viewed"
+	StatusAccepted = "accepted"
+	StatusRejected = "rejected"
+	// StatusExpired is a quote that passed its expiration date while the
+	// customer had not decided.
+	StatusExpired = "expired"
+	// StatusVoid marks a quote whose generation failed and was rolled back, or
+	// that was withdrawn. Its number is not issued again.
+	StatusVoid = "void"
+)
+
+// Statuses lists the statuses of a quote in lifecycle order.
+var Statuses = []string{
+	StatusDraft,
+	StatusSent,
+	StatusViewed,
+	StatusAccepted,
+	StatusRejected,
+	StatusExpired,
+	StatusVoid,
+}
+
+// ValidStatus reports whether status is one of Statuses.
+func ValidStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenStatus reports whether a quote with the status still awaits the
+// customer's decision.
+func OpenStatus(status string) bool {
+	switch status {
+	case StatusDraft, StatusSent, StatusViewed:
+		return true
+	}
+	return false
+}
+
+// Status returns the status of a quotation log row. Rows logged before
+// statuses were tracked are drafts.
+func Status(row []string) string {
+	if s := Field(row, ColStatus); s != "" {
+		return s
+	}
+	return StatusDraft
+}
+
+// StatusChange is an entry of the status history of a quote.
+type StatusChange struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// StatusHistory returns the status changes recorded in a quotation log row,
+// oldest first. Each change is a line like
+// "2024-10-07T09:30:00Z accepted: signed PO".
+func StatusHistory(row []string) []StatusChange {
+	var changes []StatusChange
+	for _, line := range strings.Split(Field(row, ColStatusHistory), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			continue
+		}
+		c := StatusChange{Time: t, Status: fields[1]}
+		if i := strings.Index(fields[1], ": "); i >= 0 {
+			c.Status, c.Note = fields[1][:i], fields[1][i+2:]
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// setStatus sets the status of a quotation log row and appends the change to
+// its history.
+func setStatus(row []string, status, note string, now time.Time) []string {
+	// the history holds a change per line
+	note = strings.Join(strings.Fields(note), " ")
+	changed := now.UTC().Format(time.RFC3339)
+	entry := changed + " " + status
+	if note != "" {
+		entry += ": " + note
+	}
+	if history := Field(row, ColStatusHistory); history != "" {
+		entry = history + "\n" + entry
+	}
+	row = SetField(row, ColStatus, status)
+	row = SetField(row, ColStatusChanged, changed)
+	row = SetField(row, ColStatusNote, note)
+	return SetField(row, ColStatusHistory, entry)
+}
+
+// SetStatus changes the status of a quote in the ledger, recording the time
+// of the change and an optional note. Void quotes keep their status.
+func SetStatus(ctx context.Context, ledger backend.QuoteLedger, quote, status, note string, now time.Time) error {
+	if !ValidStatus(status) {
+		return invalidRequest(fmt.Errorf("unknown status %q, must be one of %s", status, strings.Join(Statuses, ", ")))
+	}
+	row, idx, err := FindQuote(ctx, ledger, quote)
+	if err != nil {
+		return err
+	}
+	if Status(row) == StatusVoid && status != StatusVoid {
+		return invalidRequest(fmt.Errorf("quote %s is void", quote))
+	}
+	return ledger.Update(ctx, idx, setStatus(row, status, note, now))
+}
+
+// voidQuote marks a quote that was rolled back void in the ledger.
+func voidQuote(ctx context.Context, ledger backend.QuoteLedger, quote string, now time.Time) error {
+	row, idx, err := FindQuote(ctx, ledger, quote)
+	if err != nil {
+		return err
+	}
+	return ledger.Update(ctx, idx, setStatus(row, StatusVoid, "generation rolled back", now))
+}
+
+// Expired reports whether the quote of a quotation log row is open and its
+// expiration date is before the day of now. Superseded revisions do not
+// expire, as the customer decides on the latest revision.
+func Expired(row []string, now time.Time) bool {
+	if !OpenStatus(Status(row)) || Field(row, ColSupersededBy) != "" {
+		return false
+	}
+	expiry, err := time.Parse(DateLayout, Field(row, ColExpirationDate))
+	if err != nil {
+		return false
+	}
+	today, err := time.Parse(DateLayout, now.Format(DateLayout))
+	if err != nil {
+		return false
+	}
+	return today.After(expiry)
+}
+
+// ExpireQuotes marks the open quotes whose expiration date passed as expired
+// and returns their numbers.
+func ExpireQuotes(ctx context.Context, ledger backend.QuoteLedger, now time.Time) ([]string, error) {
+	rows, err := ledger.Rows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	for i, row := range rows {
+		quote := Field(row, ColQuote)
+		if _, pending := parsePendingMarker(quote); pending || quote == "" || !Expired(row, now) {
+			continue
+		}
+		note := "expired on " + Field(row, ColExpirationDate)
+		if err = ledger.Update(ctx, i, setStatus(row, StatusExpired, note, now)); err != nil {
+			return expired, fmt.Errorf("unable to mark quote %s expired: %v", quote, err)
+		}
+		expired = append(expired, quote)
+	}
+	return expired, nil
+}
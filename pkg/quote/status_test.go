@@ -0,0 +1,229 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quote
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+)
+
+// statusRow returns a quotation log row of quote with the given status and
+// expiration date.
+func statusRow(quote, status, expiry string) []string {
+	row := SetField(make([]string, len(LedgerHeaders)), ColQuote, quote)
+	row = SetField(row, ColExpirationDate, expiry)
+	return SetField(row, ColStatus, status)
+}
+
+func TestExpired(t *testing.T) {
+	east := time.FixedZone("UTC+3", 3*60*60)
+	west := time.FixedZone("UTC-5", -5*60*60)
+	tests := []struct {
+		name string
+		row  []string
+		now  time.Time
+		want bool
+	}{
+		{"draft", statusRow("AC2410001", "", "Nov 6, 2024"), time.Date(2024, 11, 7, 0, 0, 0, 0, time.UTC), true},
+		{"sent", statusRow("AC2410001", StatusSent, "Nov 6, 2024"), time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC), true},
+		{"viewed", statusRow("AC2410001", StatusViewed, "Nov 6, 2024"), time.Date(2024, 11, 7, 0, 0, 0, 0, time.UTC), true},
+		{"last day", statusRow("AC2410001", StatusSent, "Nov 6, 2024"), time.Date(2024, 11, 6, 23, 59, 59, 0, time.UTC), false},
+		{"before", statusRow("AC2410001", StatusSent, "Nov 6, 2024"), time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC), false},
+		// the day is that of now in its location
+		{"next day east", statusRow("AC2410001", StatusSent, "Nov 6, 2024"), time.Date(2024, 11, 7, 1, 0, 0, 0, east), true},
+		{"last day west", statusRow("AC2410001", StatusSent, "Nov 6, 2024"), time.Date(2024, 11, 6, 22, 0, 0, 0, west), false},
+		{"accepted", statusRow("AC2410001", StatusAccepted, "Nov 6, 2024"), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), false},
+		{"rejected", statusRow("AC2410001", StatusRejected, "Nov 6, 2024"), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), false},
+		{"already expired", statusRow("AC2410001", StatusExpired, "Nov 6, 2024"), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), false},
+		{"void", statusRow("AC2410001", StatusVoid, "Nov 6, 2024"), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), false},
+		{"superseded", SetField(statusRow("AC2410001", StatusSent, "Nov 6, 2024"), ColSupersededBy, "AC2410001-R2"), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), false},
+		{"no expiration date", statusRow("AC2410001", StatusSent, ""), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), false},
+		{"malformed expiration date", statusRow("AC2410001", StatusSent, "2024-11-06"), time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Expired(tt.row, tt.now); got != tt.want {
+				t.Errorf("Expired(%s) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpireQuotes(t *testing.T) {
+	ctx := context.Background()
+	store := backend.NewMemory()
+	now := time.Date(2024, 12, 1, 9, 30, 0, 0, time.UTC)
+
+	sent := setStatus(statusRow("AC2410001", "", "Nov 6, 2024"), StatusSent, "by email", now.Add(-30*24*time.Hour))
+	rows := [][]string{
+		sent,
+		statusRow(pendingQuote+"@2024-10-07T09:30:00Z#0a1b2c3d", "", "Nov 6, 2024"),
+		statusRow("AC2410002", StatusVoid, "Nov 6, 2024"),
+		SetField(statusRow("AC2410003", "", "Nov 6, 2024"), ColSupersededBy, "AC2410003-R2"),
+		statusRow("AC2410003-R2", "", "Nov 30, 2024"),
+		statusRow("AC2410004", StatusAccepted, "Nov 6, 2024"),
+		statusRow("AC2411001", "", "Dec 1, 2024"),
+		statusRow("", "", "Nov 6, 2024"),
+	}
+	for _, row := range rows {
+		if _, err := store.Append(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expired, err := ExpireQuotes(ctx, store, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 2 || expired[0] != "AC2410001" || expired[1] != "AC2410003-R2" {
+		t.Fatalf("expired %v, want [AC2410001 AC2410003-R2]", expired)
+	}
+
+	got, err := store.Rows(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range got {
+		quote := Field(row, ColQuote)
+		switch quote {
+		case "AC2410001", "AC2410003-R2":
+			if Status(row) != StatusExpired || Field(row, ColStatusChanged) != "2024-12-01T09:30:00Z" {
+				t.Errorf("quote %s has status %s changed at %s", quote, Status(row), Field(row, ColStatusChanged))
+			}
+			if note := "expired on " + Field(rows[i], ColExpirationDate); Field(row, ColStatusNote) != note {
+				t.Errorf("quote %s has note %q, want %q", quote, Field(row, ColStatusNote), note)
+			}
+		default:
+			if !equalRows(row, rows[i]) {
+				t.Errorf("row %d changed from %q to %q", i, rows[i], row)
+			}
+		}
+	}
+
+	history := StatusHistory(got[0])
+	if len(history) != 2 || history[0].Status != StatusSent || history[0].Note != "by email" || history[1].Status != StatusExpired || !history[1].Time.Equal(now) {
+		t.Errorf("got history %+v", history)
+	}
+
+	// expired quotes are not expired again
+	expired, err = ExpireQuotes(ctx, store, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0] != "AC2411001" {
+		t.Errorf("expired %v the next day, want [AC2411001]", expired)
+	}
+}
+
+func equalRows(a, b []string) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if cell(a, i) != cell(b, i) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSetStatus(t *testing.T) {
+	ctx := context.Background()
+	store := backend.NewMemory()
+	now := time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC)
+	for _, row := range [][]string{statusRow("AC2410001", "", "Nov 6, 2024"), statusRow("AC2410002", StatusVoid, "Nov 6, 2024")} {
+		if _, err := store.Append(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := SetStatus(ctx, store, "AC2410001", StatusSent, "", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetStatus(ctx, store, "AC2410001", StatusAccepted, "signed PO\n  #4711", now.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	row, _, err := FindQuote(ctx, store, "AC2410001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Status(row) != StatusAccepted || Field(row, ColStatusNote) != "signed PO #4711" || Field(row, ColStatusChanged) != "2024-10-09T09:30:00Z" {
+		t.Errorf("got status %s, note %q, changed at %s", Status(row), Field(row, ColStatusNote), Field(row, ColStatusChanged))
+	}
+	want := []StatusChange{
+		{Time: now, Status: StatusSent},
+		{Time: now.Add(48 * time.Hour), Status: StatusAccepted, Note: "signed PO #4711"},
+	}
+	history := StatusHistory(row)
+	if len(history) != len(want) {
+		t.Fatalf("got history %+v, want %+v", history, want)
+	}
+	for i := range want {
+		if !history[i].Time.Equal(want[i].Time) || history[i].Status != want[i].Status || history[i].Note != want[i].Note {
+			t.Errorf("change %d = %+v, want %+v", i, history[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		name   string
+		quote  string
+		status string
+		err    error
+	}{
+		{"unknown status", "AC2410001", "signed", ErrInvalidRequest},
+		{"unknown quote", "AC2410009", StatusSent, ErrQuoteNotFound},
+		{"void quote", "AC2410002", StatusSent, ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetStatus(ctx, store, tt.quote, tt.status, "", now); !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+	row, _, err = FindQuote(ctx, store, "AC2410002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Status(row) != StatusVoid || len(StatusHistory(row)) != 0 {
+		t.Errorf("void quote changed to %s with history %+v", Status(row), StatusHistory(row))
+	}
+
+	// a void quote may be voided again, e.g. with a better note
+	if err = SetStatus(ctx, store, "AC2410002", StatusVoid, "duplicate of AC2410001", now); err != nil {
+		t.Fatal(err)
+	}
+	row, _, err = FindQuote(ctx, store, "AC2410002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history := StatusHistory(row); len(history) != 1 || history[0].Note != "duplicate of AC2410001" {
+		t.Errorf("got history %+v", history)
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	row := SetField(nil, ColStatusHistory, "2024-10-07T09:30:00Z sent\nnot a change\n2024-10-08 viewed\n2024-10-09T09:30:00Z rejected: too expensive: maybe next year")
+	history := StatusHistory(row)
+	if len(history) != 2 || history[0].Status != StatusSent || history[1].Status != StatusRejected || history[1].Note != "too expensive: maybe next year" {
+		t.Errorf("got history %+v", history)
+	}
+	if history := StatusHistory(nil); len(history) != 0 {
+		t.Errorf("got history %+v of an empty row", history)
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+)
+
+// maxRequestBytes bounds the size of a quote request body.
+const maxRequestBytes = 1 << 20
+
+// Server serves the quote generator over HTTP:
+//
+//	POST /quotes                 generate a quote from a quote.QuoteRequest
+//	GET  /quotes/{number}        show the quotation log entry of a quote
+//	GET  /quotes/{number}/pdf    download the PDF of a quote
+//	POST /quotes/{number}/status set the status of a quote from a StatusRequest
+//
+// Errors are returned as JSON objects with an "error" field.
+type Server struct {
+	Generator *quote.Generator
+	// DefaultTemplate is used for requests without template.
+	DefaultTemplate string
+	// DefaultData holds placeholder values used unless set in a request.
+	DefaultData map[string]string
+	// Token, if set, must be sent as bearer token in the Authorization header.
+	Token string
+	// ErrorLog logs failed requests. Defaults to the standard logger.
+	ErrorLog *log.Logger
+}
+
+// Handler returns the HTTP handler of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quotes", s.handleQuotes)
+	mux.HandleFunc("/quotes/", s.handleQuote)
+	return s.authorize(mux)
+}
+
+// ErrorResponse is the body of error responses.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Unresolved lists the placeholders left in a generated document.
+	Unresolved []string `json:"unresolved,omitempty"`
+}
+
+// StatusRequest is the body of POST /quotes/{number}/status.
+type StatusRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note,omitempty"`
+}
+
+// QuoteResponse is the body of GET /quotes/{number}.
+type QuoteResponse struct {
+	Quote string `json:"quote"`
+	// Fields maps the columns of the quotation log to the values of the quote.
+	Fields map[string]string `json:"fields"`
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				s.writeError(w, r, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleQuotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.writeError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	req, err := s.decodeRequest(w, r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// a quote number is spent once generation starts, so a client going away
+	// must not abort it halfway
+	result, err := s.Generator.Generate(context.Background(), req)
+	if err != nil {
+		s.writeGenerateError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/quotes/"+result.Quote)
+	writeJSON(w, http.StatusCreated, result)
+}
+
+func (s *Server) decodeRequest(w http.ResponseWriter, r *http.Request) (quote.Request, error) {
+	body := http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	var qr quote.QuoteRequest
+	if err := dec.Decode(&qr); err != nil {
+		return quote.Request{}, fmt.Errorf("invalid quote request: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return quote.Request{}, errors.New("invalid quote request: trailing data after JSON object")
+	}
+
+	req, err := qr.Request()
+	if err != nil {
+		return req, fmt.Errorf("invalid quote request: %v", err)
+	}
+	if req.Template == "" && req.Revise == "" {
+		req.Template = s.DefaultTemplate
+	}
+	data := make(map[string]string, len(s.DefaultData)+len(req.Data))
+	for k, v := range s.DefaultData {
+		data[quote.Placeholder(k)] = v
+	}
+	for k, v := range req.Data {
+		data[k] = v
+	}
+	req.Data = data
+	if email, ok := data["{{email}}"]; ok {
+		if err = quote.ValidateEmail(email); err != nil {
+			return req, fmt.Errorf("invalid quote request: %v", err)
+		}
+	}
+	return req, nil
+}
+
+func (s *Server) writeGenerateError(w http.ResponseWriter, r *http.Request, err error) {
+	var unresolved *quote.UnresolvedError
+	var sendErr *quote.SendError
+	switch {
+	case errors.As(err, &sendErr):
+		// the quote exists, but the SMTP server failed
+		s.writeError(w, r, http.StatusBadGateway, err)
+	case errors.Is(err, quote.ErrInvalidEmail):
+		s.writeError(w, r, http.StatusBadRequest, err)
+	case errors.As(err, &unresolved):
+		s.writeJSONError(w, r, http.StatusUnprocessableEntity, ErrorResponse{Error: err.Error(), Unresolved: unresolved.Placeholders})
+	case errors.Is(err, quote.ErrInvalidRequest):
+		s.writeError(w, r, http.StatusUnprocessableEntity, err)
+	case errors.Is(err, quote.ErrQuoteNotFound):
+		s.writeError(w, r, http.StatusNotFound, err)
+	default:
+		s.writeError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleQuote(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/quotes/"), "/")
+	number := parts[0]
+	if len(parts) == 2 && parts[1] == "status" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			s.writeError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		s.setStatus(w, r, number)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.writeError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	switch {
+	case number == "":
+		s.writeError(w, r, http.StatusNotFound, errors.New("missing quote number"))
+	case len(parts) == 1:
+		s.getQuote(w, r, number)
+	case len(parts) == 2 && parts[1] == "pdf":
+		s.getPDF(w, r, number)
+	default:
+		s.writeError(w, r, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+	}
+}
+
+func (s *Server) getQuote(w http.ResponseWriter, r *http.Request, number string) {
+	row, _, err := quote.FindQuote(r.Context(), s.Generator.Ledger, number)
+	if err != nil {
+		s.writeGenerateError(w, r, err)
+		return
+	}
+	resp := QuoteResponse{Quote: number, Fields: map[string]string{}}
+	for _, h := range quote.LedgerHeaders {
+		resp.Fields[h] = quote.Field(row, h)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) setStatus(w http.ResponseWriter, r *http.Request, number string) {
+	body := http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	var req StatusRequest
+	if err := dec.Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Errorf("invalid status request: %v", err))
+		return
+	}
+	err := quote.SetStatus(r.Context(), s.Generator.Ledger, number, req.Status, req.Note, time.Now())
+	if err != nil {
+		s.writeGenerateError(w, r, err)
+		return
+	}
+	s.getQuote(w, r, number)
+}
+
+func (s *Server) getPDF(w http.ResponseWriter, r *http.Request, number string) {
+	row, _, err := quote.FindQuote(r.Context(), s.Generator.Ledger, number)
+	if err != nil {
+		s.writeGenerateError(w, r, err)
+		return
+	}
+	filename, err := s.Generator.PDFPath(quote.Field(row, quote.ColEmail), number)
+	if err != nil {
+		s.writeGenerateError(w, r, err)
+		return
+	}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		s.writeError(w, r, http.StatusNotFound, fmt.Errorf("pdf of quote %s not found", number))
+		return
+	} else if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(filename)))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	s.writeJSONError(w, r, code, ErrorResponse{Error: err.Error()})
+}
+
+func (s *Server) writeJSONError(w http.ResponseWriter, r *http.Request, code int, resp ErrorResponse) {
+	if code >= http.StatusInternalServerError {
+		logger := s.ErrorLog
+		if logger == nil {
+			logger = log.Default()
+		}
+		logger.Printf("%s %s: %s", r.Method, r.URL.Path, resp.Error)
+	}
+	writeJSON(w, code, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,199 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *backend.Memory, string) {
+	t.Helper()
+	ctx := context.Background()
+	mem := backend.NewMemory()
+	root, err := mem.CreateFolder(ctx, "", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem.PutDocument("tmpl", backend.NewTextDocument("Template", "Quote #{{quote}}\nFor {{name}} <{{email}}>\nValid until {{expiry-date}}\n"))
+
+	outDir := filepath.Join(t.TempDir(), "out")
+	s := &Server{
+		Generator: &quote.Generator{
+			Folders:        mem,
+			Documents:      mem,
+			Ledger:         mem,
+			Journal:        mem,
+			ParentFolderID: root,
+			OutDir:         outDir,
+			Templates:      map[string]string{"kubedb": "tmpl"},
+			Now: func() time.Time {
+				return time.Date(2024, 10, 7, 9, 30, 0, 0, time.UTC)
+			},
+		},
+		DefaultTemplate: "kubedb",
+		ErrorLog:        log.New(io.Discard, "", 0),
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts, mem, outDir
+}
+
+func post(t *testing.T, url, body string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func get(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func TestCreateQuote(t *testing.T) {
+	ts, mem, _ := newTestServer(t)
+
+	resp, body := post(t, ts.URL+"/quotes", `{"template": "kubedb", "customer": {"name": "Jane Doe", "email": "jane@example.com"}}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /quotes: got %d %s, want %d", resp.StatusCode, body, http.StatusCreated)
+	}
+	var result quote.Result
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Quote == "" {
+		t.Fatalf("POST /quotes: missing quote number in %s", body)
+	}
+	if got, want := resp.Header.Get("Location"), "/quotes/"+result.Quote; got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	if text := backend.PlainText(mem.Document(result.DocID)); !strings.Contains(text, "For Jane Doe <jane@example.com>") {
+		t.Errorf("document not rendered:\n%s", text)
+	}
+
+	resp, body = get(t, ts.URL+"/quotes/"+result.Quote)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET quote: got %d %s", resp.StatusCode, body)
+	}
+	var qr QuoteResponse
+	if err := json.Unmarshal(body, &qr); err != nil {
+		t.Fatal(err)
+	}
+	if got := qr.Fields[quote.ColEmail]; got != "jane@example.com" {
+		t.Errorf("email = %q, want jane@example.com", got)
+	}
+
+	resp, body = get(t, ts.URL+"/quotes/"+result.Quote+"/pdf")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET pdf: got %d %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/pdf" {
+		t.Errorf("Content-Type = %q, want application/pdf", got)
+	}
+	if !strings.Contains(string(body), "Quote #"+result.Quote) {
+		t.Errorf("unexpected pdf:\n%s", body)
+	}
+}
+
+func TestCreateQuoteErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"bad json", `{"customer": {"email": "jane@example.com"}`, http.StatusBadRequest},
+		{"unknown field", `{"customer": {"email": "jane@example.com"}, "foo": 1}`, http.StatusBadRequest},
+		{"trailing data", `{"customer": {"email": "jane@example.com"}} {}`, http.StatusBadRequest},
+		{"unknown template", `{"template": "voyager", "customer": {"email": "jane@example.com"}}`, http.StatusUnprocessableEntity},
+		{"missing email", `{"customer": {"name": "Jane Doe"}}`, http.StatusUnprocessableEntity},
+		{"display name", `{"customer": {"email": "Jane Doe <jane@example.com>"}}`, http.StatusBadRequest},
+		{"domain traversal", `{"customer": {"email": "jane@../../etc"}}`, http.StatusBadRequest},
+		{"domain separator", `{"customer": {"email": "jane@example.com\\..\\x"}}`, http.StatusBadRequest},
+		{"address traversal", `{"customer": {"email": "../../jane@gmail.com"}}`, http.StatusBadRequest},
+		{"address separator", `{"customer": {"email": "jane/x@gmail.com"}}`, http.StatusBadRequest},
+		{"data traversal", `{"customer": {"name": "Jane Doe"}, "data": {"email": "jane@../etc"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, mem, outDir := newTestServer(t)
+
+			resp, body := post(t, ts.URL+"/quotes", tt.body)
+			if resp.StatusCode != tt.code {
+				t.Fatalf("got %d %s, want %d", resp.StatusCode, body, tt.code)
+			}
+			var er ErrorResponse
+			if err := json.Unmarshal(body, &er); err != nil || er.Error == "" {
+				t.Errorf("expected a JSON error, got %s", body)
+			}
+			rows, err := mem.Rows(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 0 {
+				t.Errorf("rejected request logged %d quotes", len(rows))
+			}
+			if _, err := os.Stat(outDir); !os.IsNotExist(err) {
+				t.Errorf("rejected request created %s: %v", outDir, err)
+			}
+		})
+	}
+}
+
+func TestGetPDFTraversal(t *testing.T) {
+	ts, mem, _ := newTestServer(t)
+	ctx := context.Background()
+
+	// a row logged before emails were validated
+	row := quote.LedgerRow("kubedb", map[string]string{"{{email}}": "jane@x/../../../etc"})
+	number, err := quote.LogQuotation(ctx, mem, quote.DefaultNumberScheme(), quote.LedgerHeaders, row, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, body := get(t, ts.URL+"/quotes/"+number+"/pdf")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got %d %s, want %d", resp.StatusCode, body, http.StatusBadRequest)
+	}
+}
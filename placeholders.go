@@ -0,0 +1,64 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+func NewCmdPlaceholders() *cobra.Command {
+	return &cobra.Command{
+		Use:          "placeholders TEMPLATE",
+		Short:        "List the {{...}} placeholders of a template",
+		Long:         "List the {{...}} placeholders found in the body, headers, footers, footnotes and tables of a template, given by name or document id.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			templateDocId := args[0]
+			if id, ok := cfg.Templates[templateDocId]; ok {
+				templateDocId = id
+			}
+
+			client, err := newGoogleClient(context.TODO(), cfg)
+			if err != nil {
+				return err
+			}
+			srvDrive, srvDoc, err := newGoogleServices(context.TODO(), client)
+			if err != nil {
+				return err
+			}
+			doc, err := backend.NewGoogleDocs(srvDrive, srvDoc).GetDocument(context.TODO(), templateDocId)
+			if err != nil {
+				return err
+			}
+			for _, token := range quote.Placeholders(doc) {
+				fmt.Println(token)
+			}
+			return nil
+		},
+	}
+}
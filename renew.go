@@ -0,0 +1,113 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+func NewCmdRenew() *cobra.Command {
+	var (
+		templateDocId    string
+		replacementInput map[string]string
+		lineItemsFile    string
+		placeholderCheck string
+		dryRun           bool
+		delivery         deliveryFlags
+	)
+	cmd := &cobra.Command{
+		Use:   "renew QUOTE",
+		Short: "Issue a new quote for a new term, pre-filled from an existing quote",
+		Long: `Issue a new quote for a new term, pre-filled from an existing quote.
+
+The new quote gets a new number and is generated from the template, customer
+data, lists and line items of the old one, prepared today. Line items naming
+a SKU are priced from the current catalog. The Renewal Of column of the
+quotation log records the old quote. To keep the number and only move the
+expiration date, use extend instead.`,
+		Example: `  quote-generator renew AC2410007
+  quote-generator renew AC2410007 --line-items items-2025.yaml --send`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			check := quote.PlaceholderCheck(placeholderCheck)
+			if err = check.Validate(); err != nil {
+				return err
+			}
+			gen, err := newGenerator(context.TODO(), cfg)
+			if err != nil {
+				return err
+			}
+			gen.PlaceholderCheck = check
+			req, err := gen.RenewRequest(context.TODO(), args[0])
+			if err != nil {
+				return err
+			}
+
+			flags := cmd.Flags()
+			if flags.Changed("template-doc-id") {
+				req.Template = templateDocId
+			}
+			for k, v := range replacementInput {
+				req.Data[quote.Placeholder(k)] = v
+			}
+			if lineItemsFile != "" {
+				req.LineItems, err = readLineItems(lineItemsFile)
+				if err != nil {
+					return err
+				}
+			}
+			req.Send = delivery.apply(flags, nil)
+
+			if dryRun {
+				plan, err := gen.Plan(context.TODO(), req)
+				if err != nil {
+					return err
+				}
+				printPlan(plan, cfg.ParentFolderID)
+				return nil
+			}
+			result, err := gen.Generate(context.TODO(), req)
+			if err != nil {
+				var sendErr *quote.SendError
+				if errors.As(err, &sendErr) {
+					printResult(sendErr.Result)
+				}
+				return err
+			}
+			printResult(result)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&templateDocId, "template-doc-id", "", "Template of the new quote, instead of the old quote's")
+	flags.StringToStringVar(&replacementInput, "data", nil, "key-value pairs for text replacement, overriding the old quote's values")
+	flags.StringVar(&lineItemsFile, "line-items", "", "Path to YAML or JSON file with the line items of the new term, instead of the old quote's")
+	flags.StringVar(&placeholderCheck, "placeholder-check", string(quote.PlaceholderCheckWarn), "What to do when template placeholders have no value or data matches no placeholder: ignore, warn or error")
+	flags.BoolVar(&dryRun, "dry-run", false, "Show the quote number, folder and replacements the new quote would get, without writing anything")
+	delivery.addFlags(flags)
+	return cmd
+}
@@ -0,0 +1,110 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/appscodelabs/quote-generator/pkg/backend"
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+func NewCmdResume() *cobra.Command {
+	var (
+		rollback bool
+		list     bool
+	)
+	cmd := &cobra.Command{
+		Use:   "resume [RUN...]",
+		Short: "Finish or roll back interrupted quote generation runs",
+		Long: `Finish or roll back interrupted quote generation runs.
+
+Every quote is recorded in a journal while it is generated. Runs that were
+interrupted, or whose rollback failed, stay in the journal. Without arguments,
+all of them are resumed, except runs still in progress in another process.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			ctx := context.Background()
+			journal := &quote.Generator{Journal: backend.NewFileJournal(cfg.JournalDir)}
+			ids := args
+			if len(ids) == 0 || list {
+				runs, err := journal.Runs(ctx)
+				if err != nil {
+					return err
+				}
+				if list {
+					for _, r := range runs {
+						fmt.Println(r.Describe())
+					}
+					return nil
+				}
+				for _, r := range runs {
+					ids = append(ids, r.ID)
+				}
+				if len(ids) == 0 {
+					fmt.Println("No runs to resume.")
+					return nil
+				}
+			}
+
+			gen, err := newGenerator(ctx, cfg)
+			if err != nil {
+				return err
+			}
+
+			var failed int
+			for _, id := range ids {
+				if rollback {
+					err = gen.Rollback(ctx, id)
+					if err == nil {
+						fmt.Println("rolled back:", id)
+					}
+				} else {
+					result, rerr := gen.Resume(ctx, id)
+					if err = rerr; err == nil {
+						fmt.Println("resumed:", id)
+						printResult(result)
+					}
+				}
+				if errors.Is(err, backend.ErrBusy) && len(args) == 0 {
+					fmt.Fprintf(os.Stderr, "skipped %s: in progress\n", id)
+					continue
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", id, err)
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d runs failed", failed, len(ids))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&rollback, "rollback", false, "Roll the runs back instead of finishing them")
+	cmd.Flags().BoolVar(&list, "list", false, "List the runs in the journal")
+	return cmd
+}
@@ -0,0 +1,144 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+	"github.com/appscodelabs/quote-generator/pkg/server"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+// EnvServeToken is the environment variable holding the bearer token of the
+// HTTP API.
+const EnvServeToken = "QUOTE_GENERATOR_TOKEN"
+
+func NewCmdServe() *cobra.Command {
+	var (
+		addr             string
+		placeholderCheck string
+		shutdownTimeout  time.Duration
+		expireInterval   time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the quote generator over HTTP",
+		Long: `Serve the quote generator over HTTP:
+
+  POST /quotes                 generate a quote from a JSON quote request
+  GET  /quotes/{number}        show the quotation log entry of a quote
+  GET  /quotes/{number}/pdf    download the PDF of a quote
+  POST /quotes/{number}/status set the status of a quote from {"status": ..., "note": ...}
+
+If $` + EnvServeToken + ` is set, requests must send it as bearer token. Open quotes
+past their expiration date are marked expired every --expire-interval.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			// a server must never wait for a login prompt
+			nonInteractive = true
+			check := quote.PlaceholderCheck(placeholderCheck)
+			if err = check.Validate(); err != nil {
+				return err
+			}
+			gen, err := newGenerator(context.TODO(), cfg)
+			if err != nil {
+				return err
+			}
+			gen.PlaceholderCheck = check
+
+			s := &server.Server{
+				Generator:       gen,
+				DefaultTemplate: cfg.Defaults.Template,
+				DefaultData:     cfg.Defaults.Data,
+				Token:           os.Getenv(EnvServeToken),
+			}
+			srv := &http.Server{
+				Addr:              addr,
+				Handler:           s.Handler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			stop := make(chan struct{})
+			if expireInterval > 0 {
+				go expireQuotes(gen, expireInterval, stop)
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				sig := make(chan os.Signal, 1)
+				signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+				<-sig
+				log.Println("shutting down, waiting for running requests")
+				close(stop)
+				ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+				defer cancel()
+				done <- srv.Shutdown(ctx)
+			}()
+
+			log.Println("listening on", addr)
+			if err = srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			if err = <-done; err != nil {
+				return fmt.Errorf("unable to shut down gracefully: %v", err)
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&addr, "addr", ":8080", "Address to listen on")
+	flags.StringVar(&placeholderCheck, "placeholder-check", string(quote.PlaceholderCheckWarn), "What to do when template placeholders have no value or data matches no placeholder: ignore, warn or error")
+	flags.DurationVar(&shutdownTimeout, "shutdown-timeout", time.Minute, "How long to wait for running requests on shutdown")
+	flags.DurationVar(&expireInterval, "expire-interval", time.Hour, "How often open quotes past their expiration date are marked expired, 0 to disable")
+	return cmd
+}
+
+// expireQuotes marks expired quotes at startup and then every interval until
+// stop is closed.
+func expireQuotes(gen *quote.Generator, interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		expired, err := quote.ExpireQuotes(context.Background(), gen.Ledger, time.Now())
+		for _, q := range expired {
+			log.Println("expired:", q)
+		}
+		if err != nil {
+			log.Println("unable to expire quotes:", err)
+		}
+		select {
+		case <-t.C:
+		case <-stop:
+			return
+		}
+	}
+}
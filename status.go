@@ -0,0 +1,152 @@
+/*
+Copyright AppsCode Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appscodelabs/quote-generator/pkg/quote"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+func NewCmdStatus() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Track the status of quotes in the quotation log",
+		Long: `Track the status of quotes in the quotation log.
+
+A quote is logged as ` + quote.StatusDraft + ` and becomes ` + quote.StatusSent + ` when it is emailed. Its status can
+be set to any of: ` + strings.Join(quote.Statuses, ", ") + `. Every change is recorded
+with its time in the Status History column.`,
+	}
+	cmd.AddCommand(NewCmdStatusShow())
+	cmd.AddCommand(NewCmdStatusSet())
+	cmd.AddCommand(NewCmdStatusExpire())
+	return cmd
+}
+
+func NewCmdStatusShow() *cobra.Command {
+	return &cobra.Command{
+		Use:          "show QUOTE",
+		Short:        "Show the status and status history of a quote",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			ledger, err := newLedger(context.TODO(), cfg, nil)
+			if err != nil {
+				return err
+			}
+			row, _, err := quote.FindQuote(context.TODO(), ledger, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Println("status:", quote.Status(row))
+			if quote.Expired(row, time.Now()) {
+				fmt.Printf("expired on %s, not marked yet\n", quote.Field(row, quote.ColExpirationDate))
+			}
+			if note := quote.Field(row, quote.ColStatusNote); note != "" {
+				fmt.Println("note:", note)
+			}
+			for _, c := range quote.StatusHistory(row) {
+				line := fmt.Sprintf("  %s  %s", c.Time.Local().Format("2006-01-02 15:04"), c.Status)
+				if c.Note != "" {
+					line += ": " + c.Note
+				}
+				fmt.Println(line)
+			}
+			return nil
+		},
+	}
+}
+
+func NewCmdStatusSet() *cobra.Command {
+	var note string
+	cmd := &cobra.Command{
+		Use:          "set QUOTE STATUS",
+		Short:        "Set the status of a quote",
+		Example:      "  quote-generator status set AC2410007 accepted --note \"PO 4711 received\"",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 1 {
+				return quote.Statuses, cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			ledger, err := newLedger(context.TODO(), cfg, nil)
+			if err != nil {
+				return err
+			}
+			err = quote.SetStatus(context.TODO(), ledger, args[0], args[1], note, time.Now())
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s is %s\n", args[0], args[1])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&note, "note", "", "Note recorded with the status change, e.g. why a quote was rejected")
+	return cmd
+}
+
+func NewCmdStatusExpire() *cobra.Command {
+	return &cobra.Command{
+		Use:   "expire",
+		Short: "Mark open quotes past their expiration date as expired",
+		Long: `Mark open quotes past their expiration date as expired.
+
+Quotes that are ` + quote.StatusDraft + `, ` + quote.StatusSent + ` or ` + quote.StatusViewed + ` the day after their Expiration Date
+become ` + quote.StatusExpired + `. Run it daily, e.g. from cron; quote-generator serve does
+so on its own.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			ledger, err := newLedger(context.TODO(), cfg, nil)
+			if err != nil {
+				return err
+			}
+			expired, err := quote.ExpireQuotes(context.TODO(), ledger, time.Now())
+			for _, q := range expired {
+				fmt.Println("expired:", q)
+			}
+			if err != nil {
+				return err
+			}
+			if len(expired) == 0 {
+				fmt.Println("No quotes expired.")
+			}
+			return nil
+		},
+	}
+}